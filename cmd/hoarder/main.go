@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"syscall"
 
@@ -10,12 +11,17 @@ import (
 	"github.com/jfk9w-go/confi"
 	"github.com/pkg/errors"
 
+	"github.com/jfk9w/hoarder/internal/backup"
 	"github.com/jfk9w/hoarder/internal/captcha"
+	"github.com/jfk9w/hoarder/internal/database"
+	"github.com/jfk9w/hoarder/internal/events"
 	"github.com/jfk9w/hoarder/internal/firefly"
+	"github.com/jfk9w/hoarder/internal/firefly/webhook"
 	"github.com/jfk9w/hoarder/internal/jobs"
 	"github.com/jfk9w/hoarder/internal/jobs/lkdr"
 	"github.com/jfk9w/hoarder/internal/jobs/tbank"
 	"github.com/jfk9w/hoarder/internal/logs"
+	"github.com/jfk9w/hoarder/internal/retention"
 	"github.com/jfk9w/hoarder/internal/selenium"
 	"github.com/jfk9w/hoarder/internal/triggers"
 	"github.com/jfk9w/hoarder/internal/triggers/schedule"
@@ -32,13 +38,41 @@ type Config struct {
 		Values bool `yaml:"values,omitempty" doc:"Вывод значений конфигурации по умолчанию в JSON."`
 	} `yaml:"dump,omitempty" doc:"Вывод параметров конфигурации в стандартный поток вывода.\n\nПредназначены для использования как CLI-параметры."`
 
+	Migrate *struct {
+		Plan  bool `yaml:"plan,omitempty" doc:"Вывод списка непримененных миграций БД без их применения."`
+		Apply bool `yaml:"apply,omitempty" doc:"Применение миграций БД."`
+	} `yaml:"migrate,omitempty" doc:"Миграции БД включенных джобов и завершение работы.\n\nПри обычном запуске миграции применяются автоматически. Предназначены для использования как CLI-параметры."`
+
+	Fsck *struct {
+		Check  bool `yaml:"check,omitempty" doc:"Вывод найденных нарушений целостности данных."`
+		Repair bool `yaml:"repair,omitempty" doc:"Исправление безопасных случаев нарушений (удаление записей без родительских записей, очистка недействительных идентификаторов Firefly III)."`
+	} `yaml:"fsck,omitempty" doc:"Проверка целостности данных в БД включенных джобов и завершение работы.\n\nЕсли остались неисправленные нарушения, процесс завершается с кодом 1. Предназначены для использования как CLI-параметры."`
+
+	Retag string `yaml:"retag,omitempty" doc:"Повторно применить правила тегов и заметок ко всем транзакциям Firefly III, синхронизированным из Т-Банка для указанного пользователя, и завершить работу.\n\nПредназначен для использования как CLI-параметр."`
+
+	Reprocess string `yaml:"reprocess,omitempty" doc:"Заново построить таблицы Т-Банка для указанного пользователя из архива ответов API без обращения к Т-Банку и завершить работу.\n\nТребует включенного архивирования ответов (tinkoff.archive). Предназначен для использования как CLI-параметр."`
+
+	Restore string `yaml:"restore,omitempty" doc:"Восстановить БД джобы из резервной копии в указанном каталоге и завершить работу.\n\nБД должна быть пустой. Драйвер БД может отличаться от драйвера БД, из которой сделана копия. Предназначен для использования как CLI-параметр."`
+
 	Log logs.Config `yaml:"log,omitempty" doc:"Настройки логирования для библиотеки slog."`
 
+	Database *database.Config `yaml:"database,omitempty" doc:"Общая БД для джобов.\n\nИспользуется джобами, для которых не задан драйвер БД. Таблицы каждой джобы хранятся в отдельной схеме (postgres), базе данных (mysql) или файле (sqlite), поэтому не конфликтуют друг с другом."`
+
 	Firefly *struct {
 		firefly.Config `yaml:",inline"`
-		Enabled        bool `yaml:"enabled,omitempty" doc:"Включить синхронизацию с Firefly III."`
+		Enabled        bool                      `yaml:"enabled,omitempty" doc:"Включить синхронизацию с Firefly III."`
+		Users          map[string]firefly.Config `yaml:"users,omitempty" doc:"Отдельные экземпляры Firefly III для пользователей.\n\nКлючи совпадают с именами пользователей в настройках загрузки данных. Пользователи без отдельных настроек синхронизируются с экземпляром, указанным в serverUrl и accessToken."`
+		Webhook        *struct {
+			webhook.Config `yaml:",inline"`
+			Enabled        bool `yaml:"enabled,omitempty" doc:"Включить прием вебхуков Firefly III.\n\nИзменения категорий, описаний и контрагентов синхронизированных транзакций, сделанные в Firefly III, сохраняются и не перезаписываются при следующих синхронизациях. Удаленные транзакции не создаются повторно."`
+		} `yaml:"webhook,omitempty" doc:"Настройки приема вебхуков Firefly III."`
 	} `yaml:"firefly,omitempty" doc:"Настройки подключения к Firefly III."`
 
+	Events *struct {
+		events.Config `yaml:",inline"`
+		Enabled       bool `yaml:"enabled,omitempty" doc:"Включить доставку событий."`
+	} `yaml:"events,omitempty" doc:"Доставка доменных событий (новые и списанные операции, изменения сумм, полученные чеки, открытые и закрытые счета).\n\nСобытия записываются загрузчиками в таблицу outbox_events БД джобы в той же транзакции, что и изменения данных, и доставляются подписчикам не менее одного раза. Позиция доставки для каждого подписчика хранится в таблице outbox_offsets. Если доставка выключена или не указан ни один подписчик, события не записываются."`
+
 	Schedule *struct {
 		schedule.Config `yaml:",inline"`
 		Enabled         bool `yaml:"enabled,omitempty" doc:"Включить фоновую синхронизацию."`
@@ -68,6 +102,16 @@ type Config struct {
 		Enabled      bool `yaml:"enabled,omitempty" doc:"Включает загрузку данных из Т-Банка."`
 	} `yaml:"tinkoff,omitempty" doc:"Настройка загрузки данных из Т-Банка"`
 
+	Backup *struct {
+		backup.Config `yaml:",inline"`
+		Enabled       bool `yaml:"enabled,omitempty" doc:"Включает джобу резервного копирования БД."`
+	} `yaml:"backup,omitempty" doc:"Настройки резервного копирования БД джобов."`
+
+	Retention *struct {
+		retention.Config `yaml:",inline"`
+		Enabled          bool `yaml:"enabled,omitempty" doc:"Включает джобу удаления устаревших записей."`
+	} `yaml:"retention,omitempty" doc:"Настройки удаления устаревших записей из БД джобов."`
+
 	Selenium *struct {
 		selenium.Config `yaml:",inline"`
 		Enabled         bool `yaml:"enabled,omitempty" doc:"Включает аутентификацию через Selenium."`
@@ -101,10 +145,91 @@ func main() {
 
 	clock := based.StandardClock
 
-	var fireflyClient firefly.Invoker
+	if db, cfg := cfg.Database, cfg.LKDR; cfg != nil {
+		cfg.Database = cfg.Database.Inherit(db, lkdr.JobID)
+	}
+
+	if db, cfg := cfg.Database, cfg.Tinkoff; cfg != nil {
+		cfg.Database = cfg.Database.Inherit(db, tbank.JobID)
+	}
+
+	var databases []database.Params
+	if cfg := cfg.LKDR; pointer.Get(cfg).Enabled {
+		params, err := lkdr.DatabaseParams(lkdr.MigrateParams{
+			Clock:  clock,
+			Logger: log,
+			Config: cfg.Config,
+		})
+
+		if err != nil {
+			panic(errors.Wrapf(err, "get %s database params", lkdr.JobID))
+		}
+
+		databases = append(databases, params)
+	}
+
+	if cfg := cfg.Tinkoff; pointer.Get(cfg).Enabled {
+		params, err := tbank.DatabaseParams(tbank.MigrateParams{
+			Clock:  clock,
+			Logger: log,
+			Config: cfg.Config,
+		})
+
+		if err != nil {
+			panic(errors.Wrapf(err, "get %s database params", tbank.JobID))
+		}
+
+		databases = append(databases, params)
+	}
+
+	if migrate := cfg.Migrate; migrate != nil {
+		plan := migrate.Plan && !migrate.Apply
+		for _, params := range databases {
+			migrations, err := database.Migrate(ctx, params, plan)
+			if err != nil {
+				panic(errors.Wrapf(err, "migrate %s", params.Name))
+			}
+
+			printMigrations(params.Name, migrations, plan)
+		}
+
+		return
+	}
+
+	if fsck := cfg.Fsck; fsck != nil {
+		var unrepaired bool
+		for _, params := range databases {
+			findings, err := database.Fsck(ctx, params, fsck.Repair)
+			printFindings(params.Name, findings)
+			if err != nil {
+				panic(errors.Wrapf(err, "check %s", params.Name))
+			}
+
+			for _, finding := range findings {
+				unrepaired = unrepaired || finding.Repaired < finding.Found
+			}
+		}
+
+		if unrepaired {
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	if restore := cfg.Restore; restore != "" {
+		if err := backup.Restore(ctx, databases, restore); err != nil {
+			panic(errors.Wrap(err, "restore"))
+		}
+
+		return
+	}
+
+	var fireflyInstances *firefly.Instances
 	if cfg := cfg.Firefly; pointer.Get(cfg).Enabled {
-		fireflyClient, err = firefly.NewDefaultClient(firefly.ClientParams{
+		fireflyInstances, err = firefly.NewInstances(firefly.InstancesParams{
 			Config: cfg.Config,
+			Users:  cfg.Users,
 		})
 
 		if err != nil {
@@ -112,6 +237,32 @@ func main() {
 		}
 	}
 
+	var fireflyWebhooks *webhook.Server
+	if cfg := cfg.Firefly; pointer.Get(cfg).Enabled && pointer.Get(cfg.Webhook).Enabled {
+		fireflyWebhooks, err = webhook.NewServer(webhook.ServerParams{
+			Clock:  clock,
+			Logger: log,
+			Config: cfg.Webhook.Config,
+		})
+
+		if err != nil {
+			panic(errors.Wrap(err, "create firefly webhook server"))
+		}
+	}
+
+	var eventDispatcher *events.Dispatcher
+	if cfg := cfg.Events; pointer.Get(cfg).Enabled {
+		eventDispatcher, err = events.NewDispatcher(events.DispatcherParams{
+			Clock:  clock,
+			Logger: log,
+			Config: cfg.Config,
+		})
+
+		if err != nil {
+			panic(errors.Wrap(err, "create event dispatcher"))
+		}
+	}
+
 	var captchaSolver captcha.TokenProvider
 	if cfg := cfg.Captcha; cfg != nil {
 		captchaSolver, err = captcha.NewTokenProvider(cfg, clock)
@@ -132,14 +283,18 @@ func main() {
 		defer seleniumService.Stop()
 	}
 
+	retag, reprocess := cfg.Retag, cfg.Reprocess
 	jobs := new(jobs.Registry)
 
+	var fireflyResetters []tbank.FireflyResetter
 	if cfg := cfg.LKDR; pointer.Get(cfg).Enabled {
 		job, err := lkdr.NewJob(ctx, lkdr.JobParams{
 			Clock:         clock,
 			Logger:        log,
 			Config:        cfg.Config,
 			CaptchaSolver: captchaSolver,
+			Firefly:       fireflyInstances,
+			Events:        eventDispatcher,
 		})
 
 		if err != nil {
@@ -147,6 +302,7 @@ func main() {
 		}
 
 		jobs.Register(job)
+		fireflyResetters = append(fireflyResetters, job)
 	}
 
 	if cfg := cfg.Tinkoff; pointer.Get(cfg).Enabled {
@@ -154,8 +310,11 @@ func main() {
 			Clock:    clock,
 			Logger:   log,
 			Config:   cfg.Config,
-			Firefly:  fireflyClient,
+			Firefly:  fireflyInstances,
 			Selenium: seleniumService,
+			Events:   eventDispatcher,
+
+			FireflyResetters: fireflyResetters,
 		})
 
 		if err != nil {
@@ -163,9 +322,75 @@ func main() {
 		}
 
 		defer job.Close()
+
+		if retag != "" {
+			if err := job.Retag(ctx, clock.Now(), retag); err != nil {
+				panic(errors.Wrap(err, "retag"))
+			}
+
+			return
+		}
+
+		if reprocess != "" {
+			if err := job.Reprocess(ctx, reprocess); err != nil {
+				panic(errors.Wrap(err, "reprocess"))
+			}
+
+			return
+		}
+
+		jobs.Register(job)
+		for _, job := range job.FireflyJobs() {
+			jobs.Register(job)
+		}
+
+		if fireflyWebhooks != nil {
+			fireflyWebhooks.Register(job)
+		}
+	}
+
+	if retag != "" || reprocess != "" {
+		panic(errors.Errorf("%s job is not enabled", tbank.JobID))
+	}
+
+	if cfg := cfg.Backup; pointer.Get(cfg).Enabled {
+		job, err := backup.NewJob(backup.JobParams{
+			Config:    cfg.Config,
+			Databases: databases,
+		})
+
+		if err != nil {
+			panic(errors.Wrapf(err, "create %s job", backup.JobID))
+		}
+
+		jobs.Register(job)
+	}
+
+	if cfg := cfg.Retention; pointer.Get(cfg).Enabled {
+		job, err := retention.NewJob(retention.JobParams{
+			Config:    cfg.Config,
+			Databases: databases,
+		})
+
+		if err != nil {
+			panic(errors.Wrapf(err, "create %s job", retention.JobID))
+		}
+
 		jobs.Register(job)
 	}
 
+	if fireflyWebhooks != nil {
+		based.Go(ctx, func(ctx context.Context) {
+			if err := fireflyWebhooks.Run(ctx); err != nil {
+				log.Error("firefly webhook server failed", logs.Error(err))
+			}
+		})
+	}
+
+	if eventDispatcher != nil {
+		based.Go(ctx, eventDispatcher.Run)
+	}
+
 	triggers := triggers.NewRegistry(log)
 
 	if cfg := cfg.Schedule; pointer.Get(cfg).Enabled {
@@ -228,6 +453,23 @@ func main() {
 	}
 }
 
+func printMigrations(jobID string, migrations []database.Migration, plan bool) {
+	status := "applied"
+	if plan {
+		status = "pending"
+	}
+
+	for _, migration := range migrations {
+		fmt.Printf("%s\t%d\t%s\t%s\n", jobID, migration.Version, status, migration.Description)
+	}
+}
+
+func printFindings(jobID string, findings []database.Finding) {
+	for _, finding := range findings {
+		fmt.Printf("%s\t%s\t%d\t%d\t%s\n", jobID, finding.Category, finding.Found, finding.Repaired, finding.Description)
+	}
+}
+
 func dump(value any, codec confi.Codec) {
 	if err := codec.Marshal(value, os.Stdout); err != nil {
 		panic(err)
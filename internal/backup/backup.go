@@ -0,0 +1,158 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/jfk9w-go/based"
+	"github.com/pkg/errors"
+
+	"github.com/jfk9w/hoarder/internal/database"
+	"github.com/jfk9w/hoarder/internal/jobs"
+)
+
+const (
+	JobID = "backup"
+
+	// nameLayout is the layout of backup directory names, which are ordered by time.
+	nameLayout = "20060102T150405Z"
+	tmpSuffix  = ".tmp"
+)
+
+type Config struct {
+	Dir  string `yaml:"dir" doc:"Каталог для резервных копий.\n\nКопии каждой БД хранятся в подкаталоге с идентификатором джобы, каждая копия – в подкаталоге с временем ее создания."`
+	Keep int    `yaml:"keep,omitempty" default:"7" doc:"Количество хранимых резервных копий каждой БД.\n\nБолее старые копии удаляются после создания новой."`
+}
+
+type JobParams struct {
+	Config    Config            `validate:"required"`
+	Databases []database.Params `validate:"required"`
+}
+
+// Job backs up databases of other jobs. sqlite databases are copied with VACUUM INTO,
+// other databases are exported to JSONL files, which can be restored to a database of any supported driver.
+//
+// Backups do not depend on the user, so concurrent runs for different users are skipped.
+type Job struct {
+	dir       string
+	keep      int
+	databases []database.Params
+	mu        sync.Mutex
+}
+
+func NewJob(params JobParams) (*Job, error) {
+	if err := based.Validate(params); err != nil {
+		return nil, err
+	}
+
+	return &Job{
+		dir:       params.Config.Dir,
+		keep:      params.Config.Keep,
+		databases: params.Databases,
+	}, nil
+}
+
+func (j *Job) Info() jobs.Info {
+	return jobs.Info{
+		ID:          JobID,
+		Description: "Резервное копирование БД",
+		Manual:      true,
+	}
+}
+
+func (j *Job) Run(ctx jobs.Context, now time.Time, _ string) (errs error) {
+	if !j.mu.TryLock() {
+		ctx.Info("backup is already running")
+		return nil
+	}
+
+	defer j.mu.Unlock()
+
+	for _, params := range j.databases {
+		ctx := ctx.With("database", params.Name)
+		_ = ctx.Error(&errs, j.backup(ctx, now, params), "failed to back up database")
+	}
+
+	return
+}
+
+func (j *Job) backup(ctx jobs.Context, now time.Time, params database.Params) error {
+	dir := filepath.Join(j.dir, params.Name)
+	path := filepath.Join(dir, now.UTC().Format(nameLayout))
+	if _, err := os.Stat(path); err == nil {
+		// backup was already made in this run, e.g. triggered for another user
+		return nil
+	}
+
+	tmp := path + tmpSuffix
+	if err := os.RemoveAll(tmp); err != nil {
+		return errors.Wrap(err, "remove incomplete backup")
+	}
+
+	manifest, err := database.Backup(ctx, params, tmp)
+	if err != nil {
+		_ = os.RemoveAll(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return errors.Wrap(err, "rename backup")
+	}
+
+	ctx.Info("created backup", "path", path, "format", manifest.Format)
+	return j.prune(ctx, dir)
+}
+
+// prune removes all backups in the directory except for the latest ones.
+func (j *Job) prune(ctx jobs.Context, dir string) error {
+	if j.keep <= 0 {
+		return nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return errors.Wrap(err, "list backups")
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if _, err := time.Parse(nameLayout, entry.Name()); entry.IsDir() && err == nil {
+			names = append(names, entry.Name())
+		}
+	}
+
+	if len(names) <= j.keep {
+		return nil
+	}
+
+	slices.Sort(names)
+	for _, name := range names[:len(names)-j.keep] {
+		if err := os.RemoveAll(filepath.Join(dir, name)); err != nil {
+			return errors.Wrapf(err, "remove backup %s", name)
+		}
+
+		ctx.Info("removed backup", "path", filepath.Join(dir, name))
+	}
+
+	return nil
+}
+
+// Restore loads the backup stored in the directory into the database of the job it was made for.
+// The database must be empty.
+func Restore(ctx context.Context, databases []database.Params, dir string) error {
+	manifest, err := database.ReadBackupManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	i := slices.IndexFunc(databases, func(params database.Params) bool { return params.Name == manifest.Job })
+	if i < 0 {
+		return errors.Errorf("%s job is not enabled", manifest.Job)
+	}
+
+	return database.Restore(ctx, databases[i], dir)
+}
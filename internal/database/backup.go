@@ -0,0 +1,628 @@
+package database
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// BackupFormat is the format of backed up data.
+type BackupFormat string
+
+const (
+	// BackupSQLite is a copy of a sqlite database made with VACUUM INTO.
+	BackupSQLite BackupFormat = "sqlite"
+
+	// BackupJSONL is a logical export with rows of each table stored as JSON objects, one per line.
+	// It can be restored to a database of any supported driver.
+	BackupJSONL BackupFormat = "jsonl"
+)
+
+const (
+	manifestFile = "manifest.json"
+	sqliteFile   = "database.sqlite"
+)
+
+// BackupManifest describes a backup stored in a directory along with the data.
+type BackupManifest struct {
+	Job       string       `json:"job"`
+	Driver    Driver       `json:"driver"`
+	Format    BackupFormat `json:"format"`
+	CreatedAt time.Time    `json:"createdAt"`
+
+	// Migrations are versions of migrations applied to the database at the moment of backup.
+	Migrations []int `json:"migrations"`
+
+	// Tables are exported tables (only for BackupJSONL).
+	Tables []BackupTable `json:"tables,omitempty"`
+}
+
+type BackupTable struct {
+	Name    string         `json:"name"`
+	Columns []BackupColumn `json:"columns"`
+}
+
+type BackupColumn struct {
+	Name string     `json:"name"`
+	Kind columnKind `json:"kind"`
+}
+
+// columnKind is a driver-independent type of column values.
+type columnKind string
+
+const (
+	kindBytes  columnKind = "bytes"
+	kindBool   columnKind = "bool"
+	kindTime   columnKind = "time"
+	kindInt    columnKind = "int"
+	kindFloat  columnKind = "float"
+	kindString columnKind = "string"
+)
+
+func kindOf(columnType gorm.ColumnType) columnKind {
+	name := strings.ToLower(columnType.DatabaseTypeName())
+	switch {
+	case strings.Contains(name, "bytea"), strings.Contains(name, "blob"), strings.Contains(name, "binary"):
+		return kindBytes
+	case strings.Contains(name, "bool"):
+		return kindBool
+	case strings.Contains(name, "time"), strings.Contains(name, "date"):
+		return kindTime
+	case isIntegerType(name), strings.Contains(name, "serial"):
+		return kindInt
+	case strings.Contains(name, "numeric"), strings.Contains(name, "decimal"),
+		strings.Contains(name, "real"), strings.Contains(name, "float"), strings.Contains(name, "double"):
+		return kindFloat
+	default:
+		return kindString
+	}
+}
+
+// timeLayouts are layouts of time values stored as strings by supported drivers.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999",
+	time.DateOnly,
+}
+
+// Backup copies the database into the directory, which is created if it does not exist.
+// sqlite databases are copied as is, other databases are exported to JSONL files.
+// The backup is a consistent snapshot of the database, so it can be made while the database is in use.
+func Backup(ctx context.Context, params Params, dir string) (*BackupManifest, error) {
+	db, err := open(params)
+	if err != nil {
+		return nil, err
+	}
+
+	defer closeDB(db)
+	db = db.WithContext(ctx)
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, errors.Wrap(err, "create backup directory")
+	}
+
+	manifest := &BackupManifest{
+		Job:       params.Name,
+		Driver:    params.Config.Driver,
+		Format:    BackupJSONL,
+		CreatedAt: params.Clock.Now(),
+	}
+
+	if db.Migrator().HasTable(new(SchemaMigration)) {
+		if err := db.Model(new(SchemaMigration)).
+			Where("job = ?", params.Name).
+			Order("version").
+			Pluck("version", &manifest.Migrations).
+			Error; err != nil {
+			return nil, errors.Wrap(err, "select applied migrations")
+		}
+	}
+
+	if params.Config.Driver == "sqlite" {
+		// VACUUM INTO makes a consistent copy on its own and cannot run in a transaction
+		manifest.Format = BackupSQLite
+		if err := db.Exec("vacuum into ?", filepath.Join(dir, sqliteFile)).Error; err != nil {
+			return nil, errors.Wrap(err, "vacuum into backup file")
+		}
+	} else if err := db.Transaction(func(tx *gorm.DB) error {
+		tables, err := listTables(tx)
+		if err != nil {
+			return err
+		}
+
+		for _, table := range tables {
+			columns, err := exportTable(tx, table, filepath.Join(dir, table+".jsonl"))
+			if err != nil {
+				return errors.Wrapf(err, "export %s", table)
+			}
+
+			manifest.Tables = append(manifest.Tables, BackupTable{Name: table, Columns: columns})
+		}
+
+		return nil
+	}, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}); err != nil {
+		return nil, err
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal manifest")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, manifestFile), data, 0o644); err != nil {
+		return nil, errors.Wrap(err, "write manifest")
+	}
+
+	return manifest, nil
+}
+
+// ReadBackupManifest reads the manifest of the backup stored in the directory.
+func ReadBackupManifest(dir string) (*BackupManifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, manifestFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "read manifest")
+	}
+
+	manifest := new(BackupManifest)
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, errors.Wrap(err, "unmarshal manifest")
+	}
+
+	return manifest, nil
+}
+
+// Restore loads the backup stored in the directory into an empty database, which may use any supported driver.
+//
+// The database is migrated to the version of the backup before loading the data
+// and then the remaining migrations are applied, so that backups of older versions can be restored as well.
+func Restore(ctx context.Context, params Params, dir string) error {
+	manifest, err := ReadBackupManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	if manifest.Job != params.Name {
+		return errors.Errorf("backup belongs to %s", manifest.Job)
+	}
+
+	var source backupSource
+	switch manifest.Format {
+	case BackupJSONL:
+		source = jsonlSource{dir: dir, manifest: manifest.Tables}
+	case BackupSQLite:
+		sourceParams := params
+		sourceParams.Config = Config{Driver: "sqlite", DSN: "file:" + filepath.Join(dir, sqliteFile) + "?mode=ro"}
+		db, err := open(sourceParams)
+		if err != nil {
+			return errors.Wrap(err, "open backup file")
+		}
+
+		defer closeDB(db)
+		source = dbSource{db: db.WithContext(ctx)}
+	default:
+		return errors.Errorf("unsupported backup format: %q", manifest.Format)
+	}
+
+	db, err := open(params)
+	if err != nil {
+		return err
+	}
+
+	defer closeDB(db)
+
+	pendingMigrations, err := pending(DB{DB: db.WithContext(ctx)}, params)
+	if err != nil {
+		return err
+	}
+
+	if len(pendingMigrations) < len(params.Migrations) {
+		return errors.New("database is not empty")
+	}
+
+	backupParams := params
+	backupParams.Migrations = nil
+	for _, migration := range params.Migrations {
+		if slices.Contains(manifest.Migrations, migration.Version) {
+			backupParams.Migrations = append(backupParams.Migrations, migration)
+		}
+	}
+
+	if len(backupParams.Migrations) < len(manifest.Migrations) {
+		return errors.New("backup contains unknown migrations, it was probably made by a newer version")
+	}
+
+	if err := migrate(ctx, db, backupParams); err != nil {
+		return errors.Wrap(err, "migrate database to backup version")
+	}
+
+	tables, err := source.tables()
+	if err != nil {
+		return err
+	}
+
+	tables = slices.DeleteFunc(tables, func(table string) bool {
+		return table == SchemaMigration{}.TableName()
+	})
+
+	if err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return loadTables(tx, source, tables)
+	}); err != nil {
+		return err
+	}
+
+	if err := migrate(ctx, db, params); err != nil {
+		return errors.Wrap(err, "migrate database")
+	}
+
+	return nil
+}
+
+func listTables(db *gorm.DB) ([]string, error) {
+	tables, err := db.Migrator().GetTables()
+	if err != nil {
+		return nil, errors.Wrap(err, "list tables")
+	}
+
+	tables = slices.DeleteFunc(tables, func(table string) bool {
+		return strings.HasPrefix(table, "sqlite_")
+	})
+
+	slices.Sort(tables)
+	return tables, nil
+}
+
+func getColumns(db *gorm.DB, table string) ([]BackupColumn, error) {
+	columnTypes, err := db.Migrator().ColumnTypes(table)
+	if err != nil {
+		return nil, errors.Wrap(err, "get column types")
+	}
+
+	columns := make([]BackupColumn, len(columnTypes))
+	for i, columnType := range columnTypes {
+		columns[i] = BackupColumn{Name: columnType.Name(), Kind: kindOf(columnType)}
+	}
+
+	return columns, nil
+}
+
+// scanRows calls fn for each row of the table with values normalized according to column kinds.
+func scanRows(db *gorm.DB, table string, columns []BackupColumn, fn func(row map[string]any) error) error {
+	names := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = column.Name
+	}
+
+	rows, err := db.Table(table).Select(names).Rows()
+	if err != nil {
+		return errors.Wrap(err, "select rows")
+	}
+
+	defer rows.Close()
+
+	values := make([]any, len(columns))
+	dest := make([]any, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return errors.Wrap(err, "scan row")
+		}
+
+		row := make(map[string]any, len(columns))
+		for i, column := range columns {
+			value := values[i]
+			if data, ok := value.([]byte); ok && column.Kind != kindBytes {
+				value = string(data)
+			}
+
+			row[column.Name] = value
+		}
+
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func exportTable(db *gorm.DB, table string, path string) (columns []BackupColumn, err error) {
+	columns, err = getColumns(db, table)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "create file")
+	}
+
+	defer func() {
+		if closeErr := file.Close(); err == nil && closeErr != nil {
+			err = errors.Wrap(closeErr, "close file")
+		}
+	}()
+
+	w := bufio.NewWriter(file)
+	encoder := json.NewEncoder(w)
+	if err := scanRows(db, table, columns, func(row map[string]any) error {
+		return encoder.Encode(row)
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := w.Flush(); err != nil {
+		return nil, errors.Wrap(err, "write file")
+	}
+
+	return columns, nil
+}
+
+// backupSource reads rows of backed up tables.
+type backupSource interface {
+	tables() ([]string, error)
+	rows(table string, fn func(row map[string]any) error) error
+}
+
+type dbSource struct {
+	db *gorm.DB
+}
+
+func (s dbSource) tables() ([]string, error) {
+	return listTables(s.db)
+}
+
+func (s dbSource) rows(table string, fn func(row map[string]any) error) error {
+	columns, err := getColumns(s.db, table)
+	if err != nil {
+		return err
+	}
+
+	return scanRows(s.db, table, columns, fn)
+}
+
+type jsonlSource struct {
+	dir      string
+	manifest []BackupTable
+}
+
+func (s jsonlSource) tables() ([]string, error) {
+	tables := make([]string, len(s.manifest))
+	for i, table := range s.manifest {
+		tables[i] = table.Name
+	}
+
+	return tables, nil
+}
+
+func (s jsonlSource) rows(table string, fn func(row map[string]any) error) error {
+	i := slices.IndexFunc(s.manifest, func(t BackupTable) bool { return t.Name == table })
+	if i < 0 {
+		return errors.Errorf("table %s not found", table)
+	}
+
+	bytes := make(map[string]bool)
+	for _, column := range s.manifest[i].Columns {
+		if column.Kind == kindBytes {
+			bytes[column.Name] = true
+		}
+	}
+
+	file, err := os.Open(filepath.Join(s.dir, table+".jsonl"))
+	if err != nil {
+		return errors.Wrap(err, "open file")
+	}
+
+	defer file.Close()
+
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	decoder.UseNumber()
+	for decoder.More() {
+		var row map[string]any
+		if err := decoder.Decode(&row); err != nil {
+			return errors.Wrap(err, "decode row")
+		}
+
+		for column := range bytes {
+			if value, ok := row[column].(string); ok {
+				data, err := base64.StdEncoding.DecodeString(value)
+				if err != nil {
+					return errors.Wrapf(err, "decode %s", column)
+				}
+
+				row[column] = data
+			}
+		}
+
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadTables inserts rows of the tables into the database. Since the order of tables
+// referencing each other is not known, tables failing to load (each one in a savepoint)
+// are retried after the others until no more tables can be loaded.
+func loadTables(tx *gorm.DB, source backupSource, tables []string) error {
+	errs := make(map[string]error)
+	for len(tables) > 0 {
+		var postponed []string
+		for _, table := range tables {
+			if err := tx.Transaction(func(tx *gorm.DB) error {
+				return loadTable(tx, source, table)
+			}); err != nil {
+				errs[table] = err
+				postponed = append(postponed, table)
+			}
+		}
+
+		if len(postponed) == len(tables) {
+			return errors.Wrapf(errs[postponed[0]], "load %s", postponed[0])
+		}
+
+		tables = postponed
+	}
+
+	return nil
+}
+
+func loadTable(tx *gorm.DB, source backupSource, table string) error {
+	if !tx.Migrator().HasTable(table) {
+		return errors.New("table does not exist")
+	}
+
+	columns, err := getColumns(tx, table)
+	if err != nil {
+		return err
+	}
+
+	kinds := make(map[string]columnKind, len(columns))
+	for _, column := range columns {
+		kinds[column.Name] = column.Kind
+	}
+
+	const batchSize = 500
+	batch := make([]map[string]any, 0, batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		if err := tx.Table(table).Create(&batch).Error; err != nil {
+			return errors.Wrap(err, "insert rows")
+		}
+
+		batch = batch[:0]
+		return nil
+	}
+
+	if err := source.rows(table, func(row map[string]any) error {
+		for column, value := range row {
+			kind, ok := kinds[column]
+			if !ok {
+				return errors.Errorf("column %s does not exist", column)
+			}
+
+			value, err := restoreValue(kind, value)
+			if err != nil {
+				return errors.Wrapf(err, "convert %s", column)
+			}
+
+			row[column] = value
+		}
+
+		batch = append(batch, row)
+		if len(batch) < batchSize {
+			return nil
+		}
+
+		return flush()
+	}); err != nil {
+		return err
+	}
+
+	if err := flush(); err != nil {
+		return err
+	}
+
+	if tx.Dialector.Name() == "postgres" {
+		// values of serial columns were inserted explicitly, so sequences need to be moved past them
+		for _, column := range columns {
+			if column.Kind != kindInt {
+				continue
+			}
+
+			if err := tx.Exec(
+				"select setval(seq, (select coalesce(max(?), 0) + 1 from ?), false) "+
+					"from pg_get_serial_sequence(?, ?) seq where seq is not null",
+				clause.Column{Name: column.Name}, clause.Table{Name: table}, table, column.Name).
+				Error; err != nil {
+				return errors.Wrapf(err, "reset sequence for %s", column.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// restoreValue converts the backed up value to the column kind of the target database.
+// Numbers may be stored as strings, since some drivers return them as text.
+func restoreValue(kind columnKind, value any) (any, error) {
+	if number, ok := value.(json.Number); ok {
+		value = number.String()
+	}
+
+	switch value := value.(type) {
+	case string:
+		switch kind {
+		case kindBytes:
+			return []byte(value), nil
+
+		case kindTime:
+			for _, layout := range timeLayouts {
+				if value, err := time.Parse(layout, value); err == nil {
+					return value, nil
+				}
+			}
+
+			return nil, errors.Errorf("invalid time value: %s", value)
+
+		case kindInt:
+			if value, err := strconv.ParseInt(value, 10, 64); err == nil {
+				return value, nil
+			}
+
+			number, err := strconv.ParseFloat(value, 64)
+			return int64(number), err
+
+		case kindFloat:
+			return strconv.ParseFloat(value, 64)
+
+		case kindBool:
+			if value, err := strconv.ParseBool(value); err == nil {
+				return value, nil
+			}
+
+			number, err := strconv.ParseFloat(value, 64)
+			return number != 0, err
+		}
+
+	case int64:
+		if kind == kindBool {
+			return value != 0, nil
+		}
+
+	case float64:
+		if kind == kindBool {
+			return value != 0, nil
+		}
+
+	case bool:
+		if kind == kindInt {
+			if value {
+				return int64(1), nil
+			}
+
+			return int64(0), nil
+		}
+	}
+
+	return value, nil
+}
@@ -0,0 +1,245 @@
+package database
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	encryptedPrefix = "enc:v1:"
+	keySize         = 32
+)
+
+type EncryptionKey struct {
+	ID    string `yaml:"id" doc:"Идентификатор ключа. Сохраняется вместе с зашифрованными значениями."`
+	Value string `yaml:"value,omitempty" doc:"Ключ длиной 32 байта в base64."`
+	File  string `yaml:"file,omitempty" doc:"Путь к файлу с ключом длиной 32 байта в base64."`
+	Env   string `yaml:"env,omitempty" doc:"Имя переменной среды с ключом длиной 32 байта в base64."`
+}
+
+type EncryptionConfig struct {
+	Keys []EncryptionKey `yaml:"keys" doc:"Ключи шифрования.\n\nНовые значения шифруются первым ключом, остальные ключи используются только для расшифровки. Для ротации ключа добавьте новый ключ в начало списка – при запуске все значения будут перешифрованы им, после чего старый ключ можно удалить."`
+}
+
+// Cipher encrypts secret values stored in the database with envelope encryption.
+//
+// Each value is encrypted with a random data key using AES-256-GCM, and the data key is in turn encrypted
+// with a master key from the configuration. The encrypted value has the form of
+// "enc:v1:<key id>:<encrypted data key>:<encrypted value>", so that values may be decrypted after key rotation.
+//
+// A nil Cipher stores values as is. Values which are not encrypted are returned as is by Decrypt.
+type Cipher struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// NewCipher creates a Cipher for the configured keys or returns nil if there are no keys.
+func NewCipher(cfg *EncryptionConfig) (*Cipher, error) {
+	if cfg == nil || len(cfg.Keys) == 0 {
+		return nil, nil
+	}
+
+	c := &Cipher{
+		current: cfg.Keys[0].ID,
+		keys:    make(map[string]cipher.AEAD, len(cfg.Keys)),
+	}
+
+	for _, key := range cfg.Keys {
+		if key.ID == "" || strings.Contains(key.ID, ":") {
+			return nil, errors.Errorf("invalid key id %q", key.ID)
+		}
+
+		if _, ok := c.keys[key.ID]; ok {
+			return nil, errors.Errorf("duplicate key id %q", key.ID)
+		}
+
+		value, err := key.load()
+		if err != nil {
+			return nil, errors.Wrapf(err, "load key %s", key.ID)
+		}
+
+		aead, err := newAEAD(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "init key %s", key.ID)
+		}
+
+		c.keys[key.ID] = aead
+	}
+
+	return c, nil
+}
+
+func (k EncryptionKey) load() ([]byte, error) {
+	value := k.Value
+	switch {
+	case k.File != "":
+		data, err := os.ReadFile(k.File)
+		if err != nil {
+			return nil, errors.Wrap(err, "read file")
+		}
+
+		value = string(data)
+	case k.Env != "":
+		var ok bool
+		if value, ok = os.LookupEnv(k.Env); !ok {
+			return nil, errors.Errorf("environment variable %s is not set", k.Env)
+		}
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return nil, errors.Wrap(err, "decode base64")
+	}
+
+	if len(key) != keySize {
+		return nil, errors.Errorf("expected %d bytes, got %d", keySize, len(key))
+	}
+
+	return key, nil
+}
+
+// Encrypt encrypts the value. Associated data binds the encrypted value to its record,
+// so that it cannot be copied to another one.
+func (c *Cipher) Encrypt(value, associatedData string) (string, error) {
+	if c == nil {
+		return value, nil
+	}
+
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", errors.Wrap(err, "generate data key")
+	}
+
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	encryptedKey, err := sealAEAD(c.keys[c.current], dataKey, []byte(c.current))
+	if err != nil {
+		return "", errors.Wrap(err, "encrypt data key")
+	}
+
+	encryptedValue, err := sealAEAD(data, []byte(value), []byte(associatedData))
+	if err != nil {
+		return "", errors.Wrap(err, "encrypt value")
+	}
+
+	return encryptedPrefix + c.current + ":" +
+		base64.RawURLEncoding.EncodeToString(encryptedKey) + ":" +
+		base64.RawURLEncoding.EncodeToString(encryptedValue), nil
+}
+
+// Decrypt decrypts the value encrypted with any of the configured keys.
+func (c *Cipher) Decrypt(value, associatedData string) (string, error) {
+	rest, ok := strings.CutPrefix(value, encryptedPrefix)
+	if !ok {
+		return value, nil
+	}
+
+	parts := strings.Split(rest, ":")
+	if len(parts) != 3 {
+		return "", errors.New("malformed encrypted value")
+	}
+
+	keyID := parts[0]
+	if c == nil {
+		return "", errors.Errorf("value is encrypted with key %s, but encryption is not configured", keyID)
+	}
+
+	key, ok := c.keys[keyID]
+	if !ok {
+		return "", errors.Errorf("unknown key %s", keyID)
+	}
+
+	encryptedKey, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", errors.Wrap(err, "decode data key")
+	}
+
+	dataKey, err := openAEAD(key, encryptedKey, []byte(keyID))
+	if err != nil {
+		return "", errors.Wrap(err, "decrypt data key")
+	}
+
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	encryptedValue, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", errors.Wrap(err, "decode value")
+	}
+
+	plaintext, err := openAEAD(data, encryptedValue, []byte(associatedData))
+	if err != nil {
+		return "", errors.Wrap(err, "decrypt value")
+	}
+
+	return string(plaintext), nil
+}
+
+// Current checks if the value is stored as configured, i.e. encrypted with the current key,
+// or not encrypted if encryption is not configured.
+func (c *Cipher) Current(value string) bool {
+	if c == nil {
+		return !strings.HasPrefix(value, encryptedPrefix)
+	}
+
+	return strings.HasPrefix(value, encryptedPrefix+c.current+":")
+}
+
+// Reencrypt decrypts the value and encrypts it with the current key if it is not stored as configured.
+// It returns false if the value is already stored as configured.
+func (c *Cipher) Reencrypt(value, associatedData string) (string, bool, error) {
+	if c.Current(value) {
+		return value, false, nil
+	}
+
+	plaintext, err := c.Decrypt(value, associatedData)
+	if err != nil {
+		return "", false, err
+	}
+
+	value, err = c.Encrypt(plaintext, associatedData)
+	return value, true, err
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "create block cipher")
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "create gcm")
+	}
+
+	return aead, nil
+}
+
+func sealAEAD(aead cipher.AEAD, plaintext, associatedData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.Wrap(err, "generate nonce")
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, associatedData), nil
+}
+
+func openAEAD(aead cipher.AEAD, ciphertext, associatedData []byte) ([]byte, error) {
+	nonceSize := aead.NonceSize()
+	if len(ciphertext) < nonceSize {
+		return nil, errors.New("ciphertext is too short")
+	}
+
+	return aead.Open(nil, ciphertext[:nonceSize], ciphertext[nonceSize:], associatedData)
+}
@@ -1,8 +1,16 @@
 package database
 
 import (
+	"database/sql"
+	"net/url"
+	"path/filepath"
+	"regexp"
 	"sort"
+	"strings"
+	"time"
 
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/pkg/errors"
 	"gorm.io/driver/mysql"
 	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
@@ -28,6 +36,145 @@ func (Driver) SchemaEnum() any {
 }
 
 type Config struct {
-	Driver Driver `yaml:"driver"`
-	DSN    string `yaml:"dsn" examples:"\"file::memory:?cache=shared\", \"host=localhost port=5432 user=postgres password=postgres dbname=postgres search_path=public\""`
+	Driver Driver `yaml:"driver,omitempty"`
+	DSN    string `yaml:"dsn,omitempty" examples:"\"file::memory:?cache=shared\", \"host=localhost port=5432 user=postgres password=postgres dbname=postgres search_path=public\""`
+	Schema string `yaml:"schema,omitempty" pattern:"^[a-z_][a-z0-9_]*$" doc:"Схема (postgres) или база данных (mysql) для таблиц джобы.\n\nСоздается автоматически, если не существует. Для общей БД по умолчанию совпадает с идентификатором джобы. Для sqlite таблицы джобы хранятся в отдельном файле рядом с общим, имя которого начинается со схемы и символа '_'."`
+
+	Pool PoolConfig `yaml:"pool,omitempty" doc:"Настройки пула соединений.\n\nПул создается для каждой джобы отдельно, в том числе при использовании общей БД, поэтому ограничения действуют на каждую джобу."`
+
+	Encryption *EncryptionConfig `yaml:"encryption,omitempty" doc:"Шифрование секретов (сессий и токенов) в БД.\n\nЕсли не задано, секреты хранятся в открытом виде."`
+}
+
+type PoolConfig struct {
+	MaxOpenConns    int           `yaml:"maxOpenConns,omitempty" doc:"Максимальное количество открытых соединений джобы.\n\nЕсли не задано, количество не ограничено."`
+	MaxIdleConns    int           `yaml:"maxIdleConns,omitempty" doc:"Максимальное количество простаивающих соединений.\n\nЕсли не задано, используется значение по умолчанию (2)."`
+	ConnMaxLifetime time.Duration `yaml:"connMaxLifetime,omitempty" doc:"Максимальное время жизни соединения."`
+	ConnMaxIdleTime time.Duration `yaml:"connMaxIdleTime,omitempty" doc:"Максимальное время простоя соединения."`
+}
+
+// Inherit returns the job database config, which uses the shared database unless the job has its own connection settings.
+// Tables of different jobs in the shared database are placed in separate schemas named after the jobs by default
+// (in separate databases for sqlite).
+func (c Config) Inherit(shared *Config, job string) Config {
+	if c.Driver != "" || shared == nil {
+		return c
+	}
+
+	result := *shared
+	result.Schema = job
+	if c.Schema != "" {
+		result.Schema = c.Schema
+	}
+
+	if c.Encryption != nil {
+		result.Encryption = c.Encryption
+	}
+
+	return result
+}
+
+var schemaPattern = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// connect returns the dialector for the configured schema and creates the schema if it does not exist.
+func (c Config) connect(driver func(string) gorm.Dialector, config *gorm.Config) (gorm.Dialector, error) {
+	if c.Schema == "" {
+		return driver(c.DSN), nil
+	}
+
+	if !schemaPattern.MatchString(c.Schema) {
+		return nil, errors.Errorf("invalid schema name %q", c.Schema)
+	}
+
+	var (
+		dsn    string
+		create string
+	)
+
+	switch c.Driver {
+	case "postgres":
+		dsn = withSearchPath(c.DSN, c.Schema)
+		create = "create schema if not exists " + c.Schema
+	case "mysql":
+		cfg, err := mysqldriver.ParseDSN(c.DSN)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse dsn")
+		}
+
+		cfg.DBName = c.Schema
+		dsn = cfg.FormatDSN()
+		create = "create database if not exists " + c.Schema
+	case "sqlite":
+		// sqlite has no schemas, and tables are named by entities themselves, so every job uses a separate database
+		return driver(sqliteSchemaDSN(c.DSN, c.Schema)), nil
+	default:
+		return nil, errors.Errorf("schemas are not supported by %s", c.Driver)
+	}
+
+	db, err := gorm.Open(driver(c.DSN), config)
+	if err != nil {
+		return nil, errors.Wrap(err, "open database")
+	}
+
+	defer closeDB(db)
+	if err := db.Exec(create).Error; err != nil {
+		return nil, errors.Wrapf(err, "create schema %s", c.Schema)
+	}
+
+	return driver(dsn), nil
+}
+
+func (c PoolConfig) apply(db *sql.DB) {
+	if c.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(c.MaxOpenConns)
+	}
+
+	if c.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(c.MaxIdleConns)
+	}
+
+	if c.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(c.ConnMaxLifetime)
+	}
+
+	if c.ConnMaxIdleTime > 0 {
+		db.SetConnMaxIdleTime(c.ConnMaxIdleTime)
+	}
+}
+
+// sqliteSchemaDSN returns the DSN of the sqlite database for the schema. The database file is placed next to the shared one
+// and its name is prefixed with the schema, while in-memory databases are named after the schema.
+// Databases of the schemas may be attached to the shared one for queries across jobs.
+func sqliteSchemaDSN(dsn, schema string) string {
+	prefix := ""
+	if strings.HasPrefix(dsn, "file:") {
+		prefix = "file:"
+	}
+
+	path, query, _ := strings.Cut(strings.TrimPrefix(dsn, prefix), "?")
+	if path == "" || path == ":memory:" {
+		if !strings.Contains(query, "mode=memory") {
+			query = strings.TrimPrefix(query+"&mode=memory", "&")
+		}
+
+		return "file:" + schema + "?" + query
+	}
+
+	dir, file := filepath.Split(path)
+	dsn = prefix + dir + schema + "_" + file
+	if query != "" {
+		dsn += "?" + query
+	}
+
+	return dsn
+}
+
+func withSearchPath(dsn, schema string) string {
+	if u, err := url.Parse(dsn); err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql") {
+		query := u.Query()
+		query.Set("search_path", schema)
+		u.RawQuery = query.Encode()
+		return u.String()
+	}
+
+	return strings.TrimSpace(dsn + " search_path=" + schema)
 }
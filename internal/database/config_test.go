@@ -0,0 +1,74 @@
+package database
+
+import (
+	"context"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jfk9w-go/based"
+)
+
+func TestSqliteSchemaDSN(t *testing.T) {
+	for _, test := range []struct {
+		dsn, expected string
+	}{
+		{"file::memory:?cache=shared", "file:lkdr?cache=shared&mode=memory"},
+		{":memory:", "file:lkdr?mode=memory"},
+		{"file:test?mode=memory&cache=shared", "file:lkdr_test?mode=memory&cache=shared"},
+		{"file:/var/lib/hoarder.db?_fk=1", "file:/var/lib/lkdr_hoarder.db?_fk=1"},
+		{"hoarder.db", "lkdr_hoarder.db"},
+	} {
+		if actual := sqliteSchemaDSN(test.dsn, "lkdr"); actual != test.expected {
+			t.Errorf("expected %q for %q, got %q", test.expected, test.dsn, actual)
+		}
+	}
+}
+
+type testJobUser struct {
+	Phone string `gorm:"primaryKey"`
+	Job   string
+}
+
+func (testJobUser) TableName() string {
+	return "users"
+}
+
+func TestConfig_SharedSqlite(t *testing.T) {
+	shared := &Config{Driver: "sqlite", DSN: "file:" + filepath.Join(t.TempDir(), "hoarder.db")}
+	for _, job := range []string{"lkdr", "tinkoff"} {
+		db, err := Open(context.Background(), Params{
+			Clock:  based.StandardClock,
+			Logger: slog.Default(),
+			Config: Config{}.Inherit(shared, job),
+			Name:   job,
+			Migrations: []Migration{
+				{Version: 1, Description: "create tables", Up: AutoMigrate(new(testJobUser))},
+			},
+		})
+
+		if err != nil && strings.Contains(err.Error(), "cgo") {
+			t.Skipf("sqlite is not available: %v", err)
+		}
+
+		if err != nil {
+			t.Fatalf("open %s: %v", job, err)
+		}
+
+		if err := db.Create(&testJobUser{Phone: "+7", Job: job}).Error; err != nil {
+			t.Errorf("create %s user: %v", job, err)
+		}
+
+		var users []testJobUser
+		if err := db.Find(&users).Error; err != nil {
+			t.Errorf("select %s users: %v", job, err)
+		} else if len(users) != 1 || users[0].Job != job {
+			t.Errorf("expected only the %s user, got %v", job, users)
+		}
+
+		if sqlDB, err := db.DB.DB(); err == nil {
+			_ = sqlDB.Close()
+		}
+	}
+}
@@ -2,10 +2,65 @@ package database
 
 import (
 	"encoding/json"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
 	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
 )
 
+// maxDepth limits nesting of converted values, so that cyclic values fall back to encoding/json,
+// which reports an error for them.
+const maxDepth = 1000
+
+var errUnsupported = errors.New("unsupported value")
+
+// ToViaJSON converts source to T as if it was marshaled to JSON and unmarshaled into T,
+// with string values trimmed (empty strings are dropped from objects) and 1-based "dbIdx"
+// set on objects in arrays.
+//
+// Values are converted with reflection. Values with custom JSON or text (un)marshalers are converted
+// with encoding/json, and if anything else is not supported, the whole conversion falls back
+// to the JSON round-trip, so that the result is the same in any case.
 func ToViaJSON[T any](source any) (target T, err error) {
+	if value, err := encode(reflect.ValueOf(source), 0); err == nil {
+		if err := decode(reflect.ValueOf(&target).Elem(), value, 0); err == nil {
+			return target, nil
+		}
+	}
+
+	return toViaJSON[T](source)
+}
+
+// CheckToViaJSON converts source to T like ToViaJSON does and returns an error if the conversion falls back
+// to the JSON round-trip or if its result differs from the one of the round-trip.
+// It is used in tests of conversions of API responses to entities.
+func CheckToViaJSON[T any](source any) (target T, err error) {
+	expected, err := toViaJSON[T](source)
+	if err != nil {
+		return target, errors.Wrap(err, "convert via json")
+	}
+
+	value, err := encode(reflect.ValueOf(source), 0)
+	if err == nil {
+		err = decode(reflect.ValueOf(&target).Elem(), value, 0)
+	}
+
+	if err != nil {
+		return target, errors.Wrap(err, "convert with reflection")
+	}
+
+	if !reflect.DeepEqual(expected, target) {
+		return target, errors.Errorf("conversion differs from JSON round-trip\nexpected: %#v\nactual:   %#v", expected, target)
+	}
+
+	return target, nil
+}
+
+func toViaJSON[T any](source any) (target T, err error) {
 	data, err := json.Marshal(source)
 	if err != nil {
 		return
@@ -80,3 +135,443 @@ func index(source any) {
 		}
 	}
 }
+
+// encode converts the value to what trim and index would produce
+// from the value marshaled to JSON and unmarshaled into any.
+func encode(v reflect.Value, depth int) (any, error) {
+	if !v.IsValid() {
+		return nil, nil
+	}
+
+	if depth > maxDepth {
+		return nil, errUnsupported
+	}
+
+	info := typeInfoOf(v.Type())
+	if info.unsupported {
+		return nil, errUnsupported
+	}
+
+	switch {
+	case info.addrMarshaler && v.CanAddr():
+		return encodeJSON(v.Addr().Interface())
+	case info.marshaler:
+		return encodeJSON(v.Interface())
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return v.Bool(), nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint()), nil
+
+	case reflect.Float32, reflect.Float64:
+		value := v.Float()
+		if math.IsInf(value, 0) || math.IsNaN(value) {
+			return nil, errUnsupported
+		}
+
+		if v.Kind() == reflect.Float32 {
+			// float32 values are marshaled with 32-bit precision
+			return strconv.ParseFloat(strconv.FormatFloat(value, 'g', -1, 32), 64)
+		}
+
+		return value, nil
+
+	case reflect.String:
+		value := v.String()
+		if !utf8.ValidString(value) {
+			// invalid bytes are replaced with utf8.RuneError when marshaled
+			var b strings.Builder
+			for _, r := range value {
+				b.WriteRune(r)
+			}
+
+			value = b.String()
+		}
+
+		if value = strings.Trim(value, " "); value == "" {
+			return nil, nil
+		}
+
+		return value, nil
+
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil, nil
+		}
+
+		return encode(v.Elem(), depth+1)
+
+	case reflect.Struct:
+		values := make(map[string]any, len(info.fields))
+	fields:
+		for _, field := range info.fields {
+			fv := v
+			for _, i := range field.index {
+				if fv.Kind() == reflect.Ptr {
+					if fv.IsNil() {
+						continue fields
+					}
+
+					fv = fv.Elem()
+				}
+
+				fv = fv.Field(i)
+			}
+
+			if field.omitEmpty && isEmptyValue(fv) {
+				continue
+			}
+
+			value, err := encode(fv, depth+1)
+			if err != nil {
+				return nil, err
+			}
+
+			if value != nil {
+				values[field.name] = value
+			}
+		}
+
+		return values, nil
+
+	case reflect.Map:
+		if v.IsNil() {
+			return nil, nil
+		}
+
+		values := make(map[string]any, v.Len())
+		for iter := v.MapRange(); iter.Next(); {
+			value, err := encode(iter.Value(), depth+1)
+			if err != nil {
+				return nil, err
+			}
+
+			if value != nil {
+				values[iter.Key().String()] = value
+			}
+		}
+
+		return values, nil
+
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil, nil
+		}
+
+		values := make([]any, v.Len())
+		for i := range values {
+			value, err := encode(v.Index(i), depth+1)
+			if err != nil {
+				return nil, err
+			}
+
+			if value, ok := value.(map[string]any); ok {
+				value["dbIdx"] = i + 1
+			}
+
+			values[i] = value
+		}
+
+		return values, nil
+
+	default:
+		return nil, errUnsupported
+	}
+}
+
+func encodeJSON(source any) (any, error) {
+	data, err := json.Marshal(source)
+	if err != nil {
+		return nil, err
+	}
+
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+
+	value = trim(value)
+	index(value)
+	return value, nil
+}
+
+// decode stores the value produced by encode into v as if it was marshaled to JSON and unmarshaled into v.
+func decode(v reflect.Value, value any, depth int) error {
+	if depth > maxDepth {
+		return errUnsupported
+	}
+
+	t := v.Type()
+	info := typeInfoOf(t)
+	if info.unsupported {
+		return errUnsupported
+	}
+
+	if value == nil || info.unmarshaler {
+		return decodeJSON(v, value)
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		value, ok := value.(bool)
+		if !ok {
+			return errUnsupported
+		}
+
+		v.SetBool(value)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		value, ok := toNumber(value)
+		if !ok || value != math.Trunc(value) || value < math.MinInt64 || value >= math.MaxInt64 ||
+			v.OverflowInt(int64(value)) {
+			return errUnsupported
+		}
+
+		v.SetInt(int64(value))
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		value, ok := toNumber(value)
+		if !ok || value != math.Trunc(value) || math.Signbit(value) || value >= math.MaxUint64 ||
+			v.OverflowUint(uint64(value)) {
+			return errUnsupported
+		}
+
+		v.SetUint(uint64(value))
+
+	case reflect.Float32, reflect.Float64:
+		value, ok := toNumber(value)
+		if !ok {
+			return errUnsupported
+		}
+
+		if v.Kind() == reflect.Float32 {
+			var err error
+			value, err = strconv.ParseFloat(strconv.FormatFloat(value, 'g', -1, 64), 32)
+			if err != nil {
+				return errUnsupported
+			}
+		}
+
+		if v.OverflowFloat(value) {
+			return errUnsupported
+		}
+
+		v.SetFloat(value)
+
+	case reflect.String:
+		value, ok := value.(string)
+		if !ok {
+			return errUnsupported
+		}
+
+		v.SetString(value)
+
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(t.Elem()))
+		}
+
+		return decode(v.Elem(), value, depth+1)
+
+	case reflect.Struct:
+		values, ok := value.(map[string]any)
+		if !ok {
+			return errUnsupported
+		}
+
+		// keys are processed in the order of marshaled JSON, which matters only
+		// if several keys match the same field, i.e. some keys match case-insensitively
+		var keys []string
+		for key := range values {
+			if _, ok := info.exactNames[key]; !ok && info.field(key) != nil {
+				keys = make([]string, 0, len(values))
+				for key := range values {
+					keys = append(keys, key)
+				}
+
+				slices.Sort(keys)
+				break
+			}
+		}
+
+		if keys == nil {
+			for key, value := range values {
+				if field := info.exactNames[key]; field != nil {
+					if err := decodeField(v, field, value, depth); err != nil {
+						return err
+					}
+				}
+			}
+
+			break
+		}
+
+		for _, key := range keys {
+			if field := info.field(key); field != nil {
+				if err := decodeField(v, field, values[key], depth); err != nil {
+					return err
+				}
+			}
+		}
+
+	case reflect.Map:
+		values, ok := value.(map[string]any)
+		if !ok {
+			return errUnsupported
+		}
+
+		if v.IsNil() {
+			v.Set(reflect.MakeMapWithSize(t, len(values)))
+		}
+
+		elem := reflect.New(t.Elem()).Elem()
+		for key, value := range values {
+			elem.SetZero()
+			if err := decode(elem, value, depth+1); err != nil {
+				return err
+			}
+
+			v.SetMapIndex(reflect.ValueOf(key).Convert(t.Key()), elem)
+		}
+
+	case reflect.Slice:
+		values, ok := value.([]any)
+		if !ok {
+			return errUnsupported
+		}
+
+		if v.IsNil() || v.Cap() < len(values) {
+			slice := reflect.MakeSlice(t, len(values), len(values))
+			reflect.Copy(slice, v)
+			v.Set(slice)
+		} else {
+			v.SetLen(len(values))
+		}
+
+		for i, value := range values {
+			if err := decode(v.Index(i), value, depth+1); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Interface:
+		// encoding/json replaces only nil empty interfaces with generic values
+		if v.NumMethod() != 0 || !v.IsNil() {
+			return errUnsupported
+		}
+
+		v.Set(reflect.ValueOf(generic(value)))
+
+	case reflect.Array:
+		values, ok := value.([]any)
+		if !ok {
+			return errUnsupported
+		}
+
+		for i := 0; i < v.Len(); i++ {
+			if i >= len(values) {
+				v.Index(i).SetZero()
+				continue
+			}
+
+			if err := decode(v.Index(i), values[i], depth+1); err != nil {
+				return err
+			}
+		}
+
+	default:
+		return errUnsupported
+	}
+
+	return nil
+}
+
+func decodeField(v reflect.Value, field *field, value any, depth int) error {
+	for _, i := range field.index {
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return errUnsupported
+				}
+
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+
+			v = v.Elem()
+		}
+
+		v = v.Field(i)
+	}
+
+	return decode(v, value, depth+1)
+}
+
+func decodeJSON(v reflect.Value, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	ptr := v.Addr().Interface()
+	if unmarshaler, ok := ptr.(json.Unmarshaler); ok && value != nil && v.Kind() != reflect.Ptr {
+		// this is what json.Unmarshal does for non-null values, but without parsing data
+		return unmarshaler.UnmarshalJSON(data)
+	}
+
+	return json.Unmarshal(data, ptr)
+}
+
+// generic returns the value produced by encode as encoding/json unmarshals it into an empty interface,
+// that is with indexes as float64.
+func generic(value any) any {
+	switch value := value.(type) {
+	case int:
+		return float64(value)
+	case map[string]any:
+		for key, item := range value {
+			value[key] = generic(item)
+		}
+	case []any:
+		for i, item := range value {
+			value[i] = generic(item)
+		}
+	}
+
+	return value
+}
+
+func toNumber(value any) (float64, bool) {
+	switch value := value.(type) {
+	case float64:
+		return value, true
+	case int:
+		return float64(value), true
+	default:
+		return 0, false
+	}
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	default:
+		return false
+	}
+}
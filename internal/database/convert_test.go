@@ -0,0 +1,231 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	lkdr "github.com/jfk9w-go/lkdr-api"
+	tbank "github.com/jfk9w-go/tbank-api"
+
+	"github.com/jfk9w/hoarder/internal/database/databasetest"
+)
+
+type testRegionOut struct {
+	City    string  `json:"city"`
+	Address *string `json:"address,omitempty"`
+}
+
+type testLocationOut struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+type testOperationOut struct {
+	Id          string             `json:"id"`
+	Description string             `json:"description"`
+	Amount      float64            `json:"amount"`
+	Time        tbank.Milliseconds `json:"time"`
+	Region      *testRegionOut     `json:"region,omitempty"`
+	Locations   []testLocationOut  `json:"locations"`
+	Fields      map[string]string  `json:"fields,omitempty"`
+	Tags        []string           `json:"tags"`
+}
+
+type testBase struct {
+	Id          string `json:"id"`
+	Description string `json:"description"`
+}
+
+type testRegion struct {
+	City    string  `json:"city"`
+	Address *string `json:"address,omitempty"`
+}
+
+type testLocation struct {
+	OperationId string `json:"-"`
+	DbIdx       int    `json:"dbIdx"`
+
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+type testOperation struct {
+	testBase
+
+	Amount    Money              `json:"amount"`
+	Time      tbank.Milliseconds `json:"time"`
+	Region    *testRegion        `json:"region,omitempty"`
+	Locations []testLocation     `json:"locations"`
+	Fields    map[string]string  `json:"fields,omitempty"`
+	Tags      []string           `json:"tags"`
+}
+
+// testRawOut has a byte slice, which is marshaled with base64 and is converted with the JSON round-trip.
+type testRawOut struct {
+	Id   string `json:"id"`
+	Data []byte `json:"data"`
+}
+
+type testRaw struct {
+	Id   string `json:"id"`
+	Data string `json:"data"`
+}
+
+func testOperationFixture() testOperationOut {
+	return testOperationOut{
+		Id:          " 1 ",
+		Description: "  ",
+		Amount:      100.005,
+		Time:        tbank.Milliseconds(time.Date(2024, 1, 2, 3, 4, 5, 6e6, time.UTC)),
+		Region:      &testRegionOut{City: " Москва ", Address: new(string)},
+		Locations:   []testLocationOut{{Latitude: 55.75, Longitude: 37.62}, {}},
+		Fields:      map[string]string{"a": " b ", "c": ""},
+		Tags:        []string{" x ", "", "y"},
+	}
+}
+
+// convertFast converts the source without falling back to the JSON round-trip.
+func convertFast[T any](source any) (target T, err error) {
+	value, err := encode(reflect.ValueOf(source), 0)
+	if err != nil {
+		return
+	}
+
+	err = decode(reflect.ValueOf(&target).Elem(), value, 0)
+	return
+}
+
+// assertEquivalent checks that ToViaJSON converts the source the same way as the JSON round-trip does.
+func assertEquivalent[T any](t *testing.T, source any) T {
+	t.Helper()
+	expected, expectedErr := toViaJSON[T](source)
+	actual, actualErr := ToViaJSON[T](source)
+	if (expectedErr == nil) != (actualErr == nil) {
+		t.Fatalf("expected error %v, got %v", expectedErr, actualErr)
+	}
+
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("conversion differs from JSON round-trip\nexpected: %#v\nactual:   %#v", expected, actual)
+	}
+
+	return actual
+}
+
+func TestToViaJSON_Trimming(t *testing.T) {
+	operation := assertEquivalent[testOperation](t, testOperationFixture())
+	if operation.Id != "1" {
+		t.Errorf("expected trimmed id, got %q", operation.Id)
+	}
+
+	if operation.Description != "" {
+		t.Errorf("expected blank description to be dropped, got %q", operation.Description)
+	}
+
+	if operation.Region == nil || operation.Region.City != "Москва" || operation.Region.Address != nil {
+		t.Errorf("expected trimmed city and dropped address, got %#v", operation.Region)
+	}
+
+	if _, ok := operation.Fields["c"]; ok || operation.Fields["a"] != "b" {
+		t.Errorf("expected trimmed map values, got %v", operation.Fields)
+	}
+
+	// empty strings are kept in arrays, since they cannot be dropped from them
+	if expected := []string{"x", "", "y"}; !reflect.DeepEqual(operation.Tags, expected) {
+		t.Errorf("expected tags %q, got %q", expected, operation.Tags)
+	}
+}
+
+func TestToViaJSON_DbIdx(t *testing.T) {
+	operation := assertEquivalent[testOperation](t, testOperationFixture())
+	for i, location := range operation.Locations {
+		if location.DbIdx != i+1 {
+			t.Errorf("expected location %d to have dbIdx %d, got %d", i, i+1, location.DbIdx)
+		}
+	}
+}
+
+func TestToViaJSON_Embedded(t *testing.T) {
+	operation := assertEquivalent[testOperation](t, testOperationFixture())
+	if operation.testBase.Id != "1" {
+		t.Errorf("expected embedded id to be set, got %q", operation.testBase.Id)
+	}
+
+	// fields of embedded structs are promoted into the object
+	value := assertEquivalent[map[string]any](t, operation)
+	if value["id"] != "1" {
+		t.Errorf("expected promoted id, got %v", value)
+	}
+}
+
+func TestToViaJSON_CustomMarshalers(t *testing.T) {
+	source := testOperationFixture()
+	operation := assertEquivalent[testOperation](t, source)
+	if operation.Amount != 10001 {
+		t.Errorf("expected amount to be unmarshaled as money, got %s", operation.Amount)
+	}
+
+	if !time.Time(operation.Time).Equal(time.Time(source.Time)) {
+		t.Errorf("expected time %s, got %s", time.Time(source.Time), time.Time(operation.Time))
+	}
+
+	value := assertEquivalent[map[string]any](t, operation)
+	if value["amount"] != 100.01 {
+		t.Errorf("expected amount to be marshaled as a number, got %v", value["amount"])
+	}
+}
+
+func TestToViaJSON_Fallback(t *testing.T) {
+	source := testRawOut{Id: " 1 ", Data: []byte("data")}
+	if _, err := convertFast[testRaw](source); err == nil {
+		t.Fatal("expected byte slices not to be converted with reflection")
+	}
+
+	raw := assertEquivalent[testRaw](t, source)
+	if raw.Id != "1" || raw.Data != "ZGF0YQ==" {
+		t.Errorf("expected trimmed id and base64 data, got %#v", raw)
+	}
+
+	if _, err := ToViaJSON[testRaw](testRawOut{Data: []byte{}}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func testOutConversion[T any](t *testing.T) {
+	t.Run(reflect.TypeFor[T]().String(), func(t *testing.T) {
+		source := databasetest.Fixture[T]()
+		if _, err := CheckToViaJSON[T](source); err != nil {
+			t.Fatal(err)
+		}
+
+		assertEquivalent[any](t, source)
+	})
+}
+
+func TestToViaJSON_Responses(t *testing.T) {
+	testOutConversion[tbank.OperationsOut](t)
+	testOutConversion[tbank.AccountsLightIbOut](t)
+	testOutConversion[tbank.StatementsOut](t)
+	testOutConversion[tbank.ShoppingReceiptOut](t)
+	testOutConversion[tbank.InvestOperationsOut](t)
+	testOutConversion[lkdr.FiscalDataOut](t)
+}
+
+func BenchmarkToViaJSON(b *testing.B) {
+	source := databasetest.Fixture[tbank.OperationsOut]()
+	b.Run("reflect", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := ToViaJSON[tbank.OperationsOut](source); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("json", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := toViaJSON[tbank.OperationsOut](source); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -4,7 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
-	"reflect"
+	"sync"
 
 	"github.com/jfk9w-go/based"
 	"github.com/pkg/errors"
@@ -14,32 +14,71 @@ import (
 	"gorm.io/gorm/schema"
 )
 
-var namingStrategy = schema.NamingStrategy{
-	IdentifierMaxLength: 64,
-}
+var (
+	namingStrategy = schema.NamingStrategy{
+		IdentifierMaxLength: 64,
+	}
+
+	// schemas caches entity schemas parsed for upserts.
+	schemas sync.Map
+)
 
 type Params struct {
-	Clock    based.Clock  `validate:"required"`
-	Logger   *slog.Logger `validate:"required"`
-	Config   Config       `validate:"required"`
-	Entities []any        `validate:"required"`
+	Clock  based.Clock  `validate:"required"`
+	Logger *slog.Logger `validate:"required"`
+	Config Config       `validate:"required"`
+
+	// Name identifies the job in the schema migrations table, so that several jobs may share a database.
+	Name string `validate:"required"`
+
+	// Migrations are applied on open in the order of versions.
+	Migrations []Migration `validate:"required"`
+
+	// Checks are run by Fsck.
+	Checks []Check
+
+	// Retention lists tables which may be pruned by Prune.
+	Retention []RetentionTarget
 }
 
 type DB struct {
 	*gorm.DB
 }
 
+// Open connects to the database and applies pending migrations.
 func Open(ctx context.Context, params Params) (DB, error) {
-	if err := based.Validate(params); err != nil {
+	db, err := open(params)
+	if err != nil {
 		return DB{}, err
 	}
 
+	if err := migrate(ctx, db, params); err != nil {
+		closeDB(db)
+		return DB{}, errors.Wrap(err, "migrate database")
+	}
+
+	if params.Logger.Enabled(ctx, slog.LevelDebug) {
+		db = db.Debug()
+	}
+
+	return DB{DB: db}, nil
+}
+
+func open(params Params) (*gorm.DB, error) {
+	if err := based.Validate(params); err != nil {
+		return nil, err
+	}
+
+	if params.Config.Driver == "" {
+		return nil, errors.New("database is not configured")
+	}
+
 	driver, ok := drivers[params.Config.Driver]
 	if !ok {
-		return DB{}, errors.Errorf("unsupported driver: %s", params.Config.Driver)
+		return nil, errors.Errorf("unsupported driver: %q", params.Config.Driver)
 	}
 
-	db, err := gorm.Open(driver(params.Config.DSN), &gorm.Config{
+	config := &gorm.Config{
 		NowFunc: params.Clock.Now,
 		Logger: slogLogger{
 			logger: params.Logger,
@@ -47,21 +86,32 @@ func Open(ctx context.Context, params Params) (DB, error) {
 		},
 		FullSaveAssociations: true,
 		NamingStrategy:       namingStrategy,
-	})
+	}
 
+	dialector, err := params.Config.connect(driver, config)
 	if err != nil {
-		return DB{}, errors.Wrap(err, "open database")
+		return nil, err
 	}
 
-	if err := db.WithContext(ctx).AutoMigrate(params.Entities...); err != nil {
-		return DB{}, errors.Wrap(err, "migrate database tables")
+	db, err := gorm.Open(dialector, config)
+	if err != nil {
+		return nil, errors.Wrap(err, "open database")
 	}
 
-	if params.Logger.Enabled(ctx, slog.LevelDebug) {
-		db = db.Debug()
+	if db.ClauseBuilders == nil {
+		db.ClauseBuilders = make(map[string]clause.ClauseBuilder)
 	}
 
-	return DB{DB: db}, nil
+	db.ClauseBuilders["ON CONFLICT"] = onConflictBuilder(db.ClauseBuilders["ON CONFLICT"])
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		closeDB(db)
+		return nil, errors.Wrap(err, "get connection pool")
+	}
+
+	params.Config.Pool.apply(sqlDB)
+	return db, nil
 }
 
 func (db DB) WithContext(ctx context.Context) DB {
@@ -83,54 +133,20 @@ func (db DB) UpsertInBatches(value any, batchSize int) *gorm.DB {
 	return db.Clauses(extractUpsertClause(value)).CreateInBatches(value, batchSize)
 }
 
+// extractUpsertClause returns the clause updating all columns of records conflicting by primary keys.
+// Columns are updated according to upsert policies of entity fields (see UpsertPolicy).
 func extractUpsertClause(entity any) clause.OnConflict {
-	value := reflect.ValueOf(entity)
-loop:
-	for {
-		switch value.Kind() {
-		case reflect.Ptr:
-			value = value.Elem()
-		case reflect.Slice:
-			value = value.Index(0)
-		default:
-			break loop
-		}
-	}
-
-	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Slice {
-		value = value.Elem()
-	}
-
-	if value.Kind() != reflect.Struct {
-		panic(fmt.Sprintf("expected %s, got %s", reflect.Struct, value.Kind()))
+	s, err := schema.Parse(entity, &schemas, namingStrategy)
+	if err != nil {
+		panic(fmt.Sprintf("parse %T: %s", entity, err))
 	}
 
 	cnf := clause.OnConflict{
 		UpdateAll: true,
 	}
 
-	for i := 0; i < value.NumField(); i++ {
-		typeField := value.Type().Field(i)
-		if typeField.Anonymous || !typeField.IsExported() {
-			continue
-		}
-
-		tag := typeField.Tag.Get("gorm")
-		if tag == "" {
-			continue
-		}
-
-		settings := schema.ParseTagSetting(tag, ";")
-		if _, ok := settings["PRIMARYKEY"]; !ok {
-			continue
-		}
-
-		columnName := settings["COLUMN"]
-		if columnName == "" {
-			columnName = namingStrategy.ColumnName("", typeField.Name)
-		}
-
-		cnf.Columns = append(cnf.Columns, clause.Column{Name: columnName})
+	for _, field := range s.PrimaryFields {
+		cnf.Columns = append(cnf.Columns, clause.Column{Name: field.DBName})
 	}
 
 	return cnf
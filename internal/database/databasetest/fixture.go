@@ -0,0 +1,93 @@
+// Package databasetest provides fixtures for tests of conversions of API responses to entities.
+package databasetest
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Fixture returns a value with exported fields set to deterministic values. Every third string is blank
+// and every fourth pointer is nil, so that trimming is covered, and slices and maps get two elements,
+// so that indexes are covered.
+func Fixture[T any]() T {
+	var value T
+	new(filler).fill(reflect.ValueOf(&value).Elem(), 0)
+	return value
+}
+
+type filler struct {
+	n int
+}
+
+func (f *filler) next() int {
+	f.n++
+	return f.n
+}
+
+func (f *filler) fill(v reflect.Value, depth int) {
+	t := v.Type()
+	if depth > 10 {
+		return
+	}
+
+	if t.Kind() == reflect.Struct && t.ConvertibleTo(reflect.TypeFor[time.Time]()) {
+		n := f.next()
+		at := time.Date(2024, time.Month(n%12+1), n%28+1, n%24, n%60, n%60, n%1000*1e6, time.UTC)
+		v.Set(reflect.ValueOf(at).Convert(t))
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(f.next()%2 == 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(int64(f.next() % 100))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(uint64(f.next() % 100))
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(float64(f.next()) + 0.25)
+	case reflect.String:
+		switch n := f.next(); n % 3 {
+		case 0:
+			v.SetString("  ")
+		case 1:
+			v.SetString(fmt.Sprintf(" value %d ", n))
+		default:
+			v.SetString(fmt.Sprintf("value %d", n))
+		}
+	case reflect.Ptr:
+		if f.next()%4 == 0 {
+			return
+		}
+
+		v.Set(reflect.New(t.Elem()))
+		f.fill(v.Elem(), depth+1)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if t.Field(i).IsExported() {
+				f.fill(v.Field(i), depth+1)
+			}
+		}
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(t, 2, 2))
+		for i := 0; i < v.Len(); i++ {
+			f.fill(v.Index(i), depth+1)
+		}
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return
+		}
+
+		v.Set(reflect.MakeMap(t))
+		for i := 0; i < 2; i++ {
+			elem := reflect.New(t.Elem()).Elem()
+			f.fill(elem, depth+1)
+			v.SetMapIndex(reflect.ValueOf(fmt.Sprintf("key%d", f.next())).Convert(t.Key()), elem)
+		}
+	case reflect.Interface:
+		if t.NumMethod() == 0 {
+			v.Set(reflect.ValueOf(fmt.Sprintf(" any %d ", f.next())))
+		}
+	}
+}
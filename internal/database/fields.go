@@ -0,0 +1,271 @@
+package database
+
+import (
+	"cmp"
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	jsonMarshalerType   = reflect.TypeFor[json.Marshaler]()
+	jsonUnmarshalerType = reflect.TypeFor[json.Unmarshaler]()
+	textMarshalerType   = reflect.TypeFor[encoding.TextMarshaler]()
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+	jsonNumberType      = reflect.TypeFor[json.Number]()
+)
+
+var typeInfos sync.Map
+
+// typeInfo describes how values of a type are marshaled to and unmarshaled from JSON by encoding/json.
+type typeInfo struct {
+	// marshaler is set if the type implements json.Marshaler or encoding.TextMarshaler.
+	marshaler bool
+	// addrMarshaler is set if the pointer to the type implements json.Marshaler or encoding.TextMarshaler,
+	// which is used by encoding/json for addressable values.
+	addrMarshaler bool
+	// unmarshaler is set if encoding/json unmarshals values with json.Unmarshaler or encoding.TextUnmarshaler.
+	unmarshaler bool
+	// unsupported is set if values of the type are converted differently from what encode and decode do.
+	unsupported bool
+
+	fields      []field
+	exactNames  map[string]*field
+	foldedNames map[string]*field
+}
+
+type field struct {
+	name      string
+	tagged    bool
+	index     []int
+	omitEmpty bool
+}
+
+func typeInfoOf(t reflect.Type) *typeInfo {
+	if info, ok := typeInfos.Load(t); ok {
+		return info.(*typeInfo)
+	}
+
+	info, _ := typeInfos.LoadOrStore(t, newTypeInfo(t))
+	return info.(*typeInfo)
+}
+
+func newTypeInfo(t reflect.Type) *typeInfo {
+	info := &typeInfo{
+		marshaler: t.Implements(jsonMarshalerType) || t.Implements(textMarshalerType),
+	}
+
+	if t.Kind() == reflect.Ptr {
+		info.unmarshaler = t.Implements(jsonUnmarshalerType) || t.Implements(textUnmarshalerType)
+	} else {
+		ptr := reflect.PointerTo(t)
+		info.addrMarshaler = ptr.Implements(jsonMarshalerType) || ptr.Implements(textMarshalerType)
+		info.unmarshaler = t.Name() != "" && (ptr.Implements(jsonUnmarshalerType) || ptr.Implements(textUnmarshalerType))
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		info.unsupported = t == jsonNumberType
+
+	case reflect.Slice:
+		// byte slices are marshaled with base64
+		info.unsupported = t.Elem().Kind() == reflect.Uint8
+
+	case reflect.Map:
+		key := t.Key()
+		info.unsupported = key.Kind() != reflect.String || reflect.PointerTo(key).Implements(textUnmarshalerType)
+
+	case reflect.Struct:
+		info.fields, info.unsupported = typeFields(t)
+		info.exactNames = make(map[string]*field, len(info.fields))
+		info.foldedNames = make(map[string]*field, len(info.fields))
+		for i := range info.fields {
+			field := &info.fields[i]
+			info.exactNames[field.name] = field
+			folded := foldName(field.name)
+			if _, ok := info.foldedNames[folded]; !ok {
+				info.foldedNames[folded] = field
+			}
+		}
+	}
+
+	return info
+}
+
+// field returns the struct field matching the object key like encoding/json does,
+// preferring an exact match over a case-insensitive one.
+func (info *typeInfo) field(key string) *field {
+	if field, ok := info.exactNames[key]; ok {
+		return field
+	}
+
+	return info.foldedNames[foldName(key)]
+}
+
+// typeFields returns fields of the struct marshaled by encoding/json in the order of their indexes,
+// resolving embedded struct fields the same way.
+// The result is unsupported if any field uses "string" or "omitzero" options.
+func typeFields(t reflect.Type) (fields []field, unsupported bool) {
+	type embedded struct {
+		typ   reflect.Type
+		index []int
+	}
+
+	var current []embedded
+	next := []embedded{{typ: t}}
+	var count, nextCount map[reflect.Type]int
+	visited := make(map[reflect.Type]bool)
+	for len(next) > 0 {
+		current, next = next, current[:0]
+		count, nextCount = nextCount, make(map[reflect.Type]int)
+		for _, e := range current {
+			if visited[e.typ] {
+				continue
+			}
+
+			visited[e.typ] = true
+			for i := 0; i < e.typ.NumField(); i++ {
+				sf := e.typ.Field(i)
+				if sf.Anonymous {
+					t := sf.Type
+					if t.Kind() == reflect.Ptr {
+						t = t.Elem()
+					}
+
+					if !sf.IsExported() && t.Kind() != reflect.Struct {
+						continue
+					}
+				} else if !sf.IsExported() {
+					continue
+				}
+
+				tag := sf.Tag.Get("json")
+				if tag == "-" {
+					continue
+				}
+
+				name, options, _ := strings.Cut(tag, ",")
+				if !isValidTag(name) {
+					name = ""
+				}
+
+				var omitEmpty bool
+				for _, option := range strings.Split(options, ",") {
+					switch option {
+					case "omitempty":
+						omitEmpty = true
+					case "string", "omitzero":
+						unsupported = true
+					}
+				}
+
+				index := append(slices.Clip(e.index), i)
+				ft := sf.Type
+				if ft.Name() == "" && ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+
+				if name != "" || !sf.Anonymous || ft.Kind() != reflect.Struct {
+					tagged := name != ""
+					if name == "" {
+						name = sf.Name
+					}
+
+					fields = append(fields, field{name: name, tagged: tagged, index: index, omitEmpty: omitEmpty})
+					if count[e.typ] > 1 {
+						// duplicate the field, so that it is annihilated below
+						fields = append(fields, fields[len(fields)-1])
+					}
+
+					continue
+				}
+
+				nextCount[ft]++
+				if nextCount[ft] == 1 {
+					next = append(next, embedded{typ: ft, index: index})
+				}
+			}
+		}
+	}
+
+	slices.SortFunc(fields, func(a, b field) int {
+		if c := strings.Compare(a.name, b.name); c != 0 {
+			return c
+		}
+
+		if c := cmp.Compare(len(a.index), len(b.index)); c != 0 {
+			return c
+		}
+
+		if a.tagged != b.tagged {
+			if a.tagged {
+				return -1
+			}
+
+			return 1
+		}
+
+		return slices.Compare(a.index, b.index)
+	})
+
+	// keep only dominant fields among the ones with the same name
+	dominant := fields[:0]
+	for i, advance := 0, 0; i < len(fields); i += advance {
+		advance = 1
+		for i+advance < len(fields) && fields[i+advance].name == fields[i].name {
+			advance++
+		}
+
+		if advance > 1 && len(fields[i].index) == len(fields[i+1].index) && fields[i].tagged == fields[i+1].tagged {
+			continue
+		}
+
+		dominant = append(dominant, fields[i])
+	}
+
+	fields = dominant
+	slices.SortFunc(fields, func(a, b field) int {
+		return slices.Compare(a.index, b.index)
+	})
+
+	return
+}
+
+func isValidTag(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for _, c := range s {
+		switch {
+		case strings.ContainsRune("!#$%&()*+-./:;<=>?@[]^_{|}~ ", c):
+		case !unicode.IsLetter(c) && !unicode.IsDigit(c):
+			return false
+		}
+	}
+
+	return true
+}
+
+// foldName folds the name for case-insensitive matching like encoding/json does.
+func foldName(name string) string {
+	var b strings.Builder
+	b.Grow(len(name))
+	for _, r := range name {
+		switch {
+		case r >= utf8.RuneSelf:
+			r = unicode.ToUpper(unicode.ToLower(r))
+		case 'a' <= r && r <= 'z':
+			r -= 'a' - 'A'
+		}
+
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
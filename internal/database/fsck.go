@@ -0,0 +1,199 @@
+package database
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// Check is a consistency check of the database.
+//
+// Run returns the number of problems found and, if repair is set and the problems are safe to repair,
+// the number of repaired ones. Checks are run in order, each one in a separate transaction.
+type Check struct {
+	Category    string
+	Description string
+	Run         func(tx DB, repair bool) (found, repaired int64, err error)
+
+	// Table is the table repaired by the check, if it is limited to one.
+	Table string
+}
+
+// OrphansCategory is the category of checks returned by OrphanChecks.
+const OrphansCategory = "orphans"
+
+// QueryCheck returns a check counting records selected by find. Found records are repaired
+// with the repair function (for example, deleted), which may be nil if they cannot be repaired automatically.
+func QueryCheck(category, description string, find func(tx DB) *gorm.DB, repair func(query *gorm.DB) *gorm.DB) Check {
+	return Check{
+		Category:    category,
+		Description: description,
+		Run: func(tx DB, fix bool) (found, repaired int64, err error) {
+			if err := find(tx).Count(&found).Error; err != nil {
+				return 0, 0, errors.Wrap(err, "count")
+			}
+
+			if found == 0 || !fix || repair == nil {
+				return
+			}
+
+			result := repair(find(tx))
+			if result.Error != nil {
+				return found, 0, errors.Wrap(result.Error, "repair")
+			}
+
+			return found, result.RowsAffected, nil
+		},
+	}
+}
+
+// Finding is a result of a check.
+type Finding struct {
+	Category    string
+	Description string
+	Found       int64
+	Repaired    int64
+}
+
+// Fsck runs consistency checks of the database and optionally repairs found problems.
+// Only problems found by checks are returned.
+func Fsck(ctx context.Context, params Params, repair bool) ([]Finding, error) {
+	db, err := open(params)
+	if err != nil {
+		return nil, err
+	}
+
+	defer closeDB(db)
+	if err := checkMigrated(DB{DB: db.WithContext(ctx)}, params); err != nil {
+		return nil, err
+	}
+
+	var findings []Finding
+	for _, check := range params.Checks {
+		finding := Finding{Category: check.Category, Description: check.Description}
+		if err := (DB{DB: db.WithContext(ctx)}).Transaction(func(tx DB) (err error) {
+			finding.Found, finding.Repaired, err = check.Run(tx, repair)
+			return
+		}); err != nil {
+			return findings, errors.Wrapf(err, "%s: %s", check.Category, check.Description)
+		}
+
+		if finding.Found > 0 {
+			findings = append(findings, finding)
+		}
+	}
+
+	return findings, nil
+}
+
+// OrphanChecks returns checks for records referencing absent records, according to foreign key constraints
+// declared by the entities. Such records may appear if constraints are not enforced (e.g. in sqlite).
+// Orphans are repaired by deleting them if the constraint cascades deletes, so entities should be listed
+// in the order of references for the checks to find orphans of deleted orphans.
+func OrphanChecks(entities ...any) ([]Check, error) {
+	var (
+		cache  sync.Map
+		seen   = make(map[string]bool)
+		checks []Check
+	)
+
+	for _, entity := range entities {
+		s, err := schema.Parse(entity, &cache, namingStrategy)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse %T", entity)
+		}
+
+		for _, constraint := range constraints(&s.Relationships) {
+			if constraint.Schema.Table == constraint.ReferenceSchema.Table {
+				// self references would need aliases and are not used by entities
+				continue
+			}
+
+			key := constraint.Schema.Table + ":" + constraint.ReferenceSchema.Table
+			for _, field := range constraint.ForeignKeys {
+				key += ":" + field.DBName
+			}
+
+			if seen[key] {
+				continue
+			}
+
+			seen[key] = true
+			checks = append(checks, orphanCheck(constraint))
+		}
+	}
+
+	return checks, nil
+}
+
+func constraints(relationships *schema.Relationships) []*schema.Constraint {
+	var result []*schema.Constraint
+	for _, name := range sortedKeys(relationships.Relations) {
+		rel := relationships.Relations[name]
+		if rel.Type == schema.Many2Many || rel.Polymorphic != nil {
+			continue
+		}
+
+		if constraint := rel.ParseConstraint(); constraint != nil {
+			result = append(result, constraint)
+		}
+	}
+
+	for _, name := range sortedKeys(relationships.EmbeddedRelations) {
+		result = append(result, constraints(relationships.EmbeddedRelations[name])...)
+	}
+
+	return result
+}
+
+func orphanCheck(constraint *schema.Constraint) Check {
+	table, referenceTable := constraint.Schema.Table, constraint.ReferenceSchema.Table
+
+	var (
+		columns []string
+		notNull []string
+		join    []string
+	)
+
+	for i, field := range constraint.ForeignKeys {
+		foreignKey := table + "." + field.DBName
+		columns = append(columns, field.DBName)
+		notNull = append(notNull, foreignKey+" is not null")
+		join = append(join, referenceTable+"."+constraint.References[i].DBName+" = "+foreignKey)
+	}
+
+	condition := strings.Join(notNull, " and ") +
+		" and not exists (select 1 from " + referenceTable + " where " + strings.Join(join, " and ") + ")"
+
+	var repair func(query *gorm.DB) *gorm.DB
+	if strings.EqualFold(constraint.OnDelete, "cascade") {
+		repair = func(query *gorm.DB) *gorm.DB {
+			return query.Delete(reflect.New(constraint.Schema.ModelType).Interface())
+		}
+	}
+
+	check := QueryCheck(OrphansCategory, table+" ("+strings.Join(columns, ", ")+") without "+referenceTable,
+		func(tx DB) *gorm.DB {
+			return tx.Table(table).Where(condition)
+		},
+		repair)
+
+	check.Table = table
+	return check
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,103 @@
+package database
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const legacySuffix = "_legacy"
+
+// MoneyColumns describes model columns converted from legacy float or string amounts to Money.
+//
+// Before the table is migrated, non-integer columns are renamed with the legacy suffix, so that
+// integer columns are created in their place. After the migration, legacy values are converted to minor units
+// and the legacy columns are dropped.
+type MoneyColumns struct {
+	Model   any
+	Columns []string
+}
+
+func (c MoneyColumns) before(db DB) error {
+	migrator := db.Migrator()
+	if !migrator.HasTable(c.Model) {
+		return nil
+	}
+
+	columnTypes, err := migrator.ColumnTypes(c.Model)
+	if err != nil {
+		return errors.Wrap(err, "get column types")
+	}
+
+	for _, columnType := range columnTypes {
+		column := columnType.Name()
+		if !c.has(column) || isIntegerType(columnType.DatabaseTypeName()) {
+			continue
+		}
+
+		if migrator.HasColumn(c.Model, column+legacySuffix) {
+			continue
+		}
+
+		if err := migrator.RenameColumn(c.Model, column, column+legacySuffix); err != nil {
+			return errors.Wrapf(err, "rename %s", column)
+		}
+	}
+
+	return nil
+}
+
+func (c MoneyColumns) after(db DB) error {
+	migrator := db.Migrator()
+	columnTypes, err := migrator.ColumnTypes(c.Model)
+	if err != nil {
+		return errors.Wrap(err, "get column types")
+	}
+
+	for _, columnType := range columnTypes {
+		legacy := columnType.Name()
+		column, ok := strings.CutSuffix(legacy, legacySuffix)
+		if !ok || !c.has(column) {
+			continue
+		}
+
+		var source any = clause.Column{Name: legacy}
+		if isTextType(columnType.DatabaseTypeName()) {
+			source = gorm.Expr("nullif(?, '')", source)
+		}
+
+		if err := db.Model(c.Model).
+			Where("1 = 1").
+			UpdateColumn(column, gorm.Expr("round(cast(? as decimal(20, 2)) * 100)", source)).
+			Error; err != nil {
+			return errors.Wrapf(err, "convert %s", column)
+		}
+
+		if err := migrator.DropColumn(c.Model, legacy); err != nil {
+			return errors.Wrapf(err, "drop %s", legacy)
+		}
+	}
+
+	return nil
+}
+
+func (c MoneyColumns) has(column string) bool {
+	for _, name := range c.Columns {
+		if name == column {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isIntegerType(name string) bool {
+	return strings.Contains(strings.ToLower(name), "int")
+}
+
+func isTextType(name string) bool {
+	name = strings.ToLower(name)
+	return strings.Contains(name, "char") || strings.Contains(name, "text")
+}
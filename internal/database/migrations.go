@@ -0,0 +1,191 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// Migration is a versioned database schema change.
+//
+// Migrations are applied in the order of versions, each one in a separate transaction
+// along with recording its version, so that a failed migration is rolled back as a whole.
+// Note that MySQL commits DDL statements implicitly, so migrations are not atomic there.
+//
+// Migrations use current entity definitions, so steps changing existing tables
+// should check the current state of the schema (see AutoMigrate and ConvertMoney).
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(tx DB) error
+}
+
+// SchemaMigration is a record of an applied migration.
+type SchemaMigration struct {
+	Job         string    `gorm:"primaryKey"`
+	Version     int       `gorm:"primaryKey;autoIncrement:false"`
+	Description string    `gorm:"not null"`
+	AppliedAt   time.Time `gorm:"not null"`
+}
+
+func (m SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// Steps runs migration steps in order.
+func Steps(steps ...func(tx DB) error) func(tx DB) error {
+	return func(tx DB) error {
+		for _, step := range steps {
+			if err := step(tx); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// AutoMigrate creates tables, missing columns and indexes for the entities.
+func AutoMigrate(entities ...any) func(tx DB) error {
+	return func(tx DB) error {
+		return tx.Migrator().AutoMigrate(entities...)
+	}
+}
+
+// Exec runs a raw SQL statement.
+func Exec(sql string, values ...any) func(tx DB) error {
+	return func(tx DB) error {
+		return tx.Exec(sql, values...).Error
+	}
+}
+
+// ConvertMoney converts legacy float or string amount columns to Money around the migrate step,
+// which is expected to create the integer columns.
+func ConvertMoney(columns []MoneyColumns, migrate func(tx DB) error) func(tx DB) error {
+	return func(tx DB) error {
+		for _, columns := range columns {
+			if err := columns.before(tx); err != nil {
+				return errors.Wrap(err, "prepare money columns")
+			}
+		}
+
+		if err := migrate(tx); err != nil {
+			return err
+		}
+
+		for _, columns := range columns {
+			if err := columns.after(tx); err != nil {
+				return errors.Wrap(err, "convert money columns")
+			}
+		}
+
+		return nil
+	}
+}
+
+// Migrate returns migrations which were not applied to the database yet and applies them unless plan is set.
+func Migrate(ctx context.Context, params Params, plan bool) ([]Migration, error) {
+	db, err := open(params)
+	if err != nil {
+		return nil, err
+	}
+
+	defer closeDB(db)
+	migrations, err := pending(DB{DB: db.WithContext(ctx)}, params)
+	if err != nil || plan {
+		return migrations, err
+	}
+
+	return migrations, migrate(ctx, db, params)
+}
+
+func pending(db DB, params Params) ([]Migration, error) {
+	for i := 1; i < len(params.Migrations); i++ {
+		if params.Migrations[i].Version <= params.Migrations[i-1].Version {
+			return nil, errors.Errorf("migration versions are not ordered: %d after %d",
+				params.Migrations[i].Version, params.Migrations[i-1].Version)
+		}
+	}
+
+	var applied []int
+	if db.Migrator().HasTable(new(SchemaMigration)) {
+		if err := db.
+			Model(new(SchemaMigration)).
+			Where("job = ?", params.Name).
+			Pluck("version", &applied).
+			Error; err != nil {
+			return nil, errors.Wrap(err, "select applied migrations")
+		}
+	}
+
+	versions := make(map[int]bool, len(applied))
+	for _, version := range applied {
+		versions[version] = true
+	}
+
+	var result []Migration
+	for _, migration := range params.Migrations {
+		if !versions[migration.Version] {
+			result = append(result, migration)
+		}
+	}
+
+	return result, nil
+}
+
+func migrate(ctx context.Context, db *gorm.DB, params Params) error {
+	if err := db.WithContext(ctx).AutoMigrate(new(SchemaMigration)); err != nil {
+		return errors.Wrap(err, "create schema migrations table")
+	}
+
+	migrations, err := pending(DB{DB: db.WithContext(ctx)}, params)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range migrations {
+		if err := (DB{DB: db.WithContext(ctx)}).Transaction(func(tx DB) error {
+			if err := migration.Up(tx); err != nil {
+				return err
+			}
+
+			return tx.Create(&SchemaMigration{
+				Job:         params.Name,
+				Version:     migration.Version,
+				Description: migration.Description,
+				AppliedAt:   params.Clock.Now(),
+			}).Error
+		}); err != nil {
+			return errors.Wrapf(err, "apply migration %d (%s)", migration.Version, migration.Description)
+		}
+
+		params.Logger.InfoContext(ctx, "applied migration",
+			"version", migration.Version,
+			"description", migration.Description)
+	}
+
+	return nil
+}
+
+// checkMigrated returns an error if the database has pending migrations, so that maintenance
+// does not work with an outdated schema.
+func checkMigrated(db DB, params Params) error {
+	migrations, err := pending(db, params)
+	if err != nil {
+		return err
+	}
+
+	if len(migrations) > 0 {
+		return errors.New("database has pending migrations")
+	}
+
+	return nil
+}
+
+func closeDB(db *gorm.DB) {
+	if sqlDB, err := db.DB(); err == nil {
+		_ = sqlDB.Close()
+	}
+}
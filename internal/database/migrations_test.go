@@ -0,0 +1,200 @@
+package database
+
+import (
+	"context"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jfk9w-go/based"
+	"github.com/pkg/errors"
+)
+
+type testMigrationAccount struct {
+	Id    string `gorm:"primaryKey"`
+	Value Money
+}
+
+func (testMigrationAccount) TableName() string {
+	return "accounts"
+}
+
+type testMigrationOperation struct {
+	Id string `gorm:"primaryKey"`
+}
+
+func (testMigrationOperation) TableName() string {
+	return "operations"
+}
+
+func newMigrationParams(t *testing.T, migrations ...Migration) Params {
+	return Params{
+		Clock:      based.StandardClock,
+		Logger:     slog.Default(),
+		Config:     Config{Driver: "sqlite", DSN: "file:" + filepath.Join(t.TempDir(), "db.sqlite")},
+		Name:       "test",
+		Migrations: migrations,
+	}
+}
+
+func testMigrate(t *testing.T, params Params, plan bool) ([]Migration, error) {
+	migrations, err := Migrate(context.Background(), params, plan)
+	if err != nil && strings.Contains(err.Error(), "cgo") {
+		t.Skipf("sqlite is not available: %v", err)
+	}
+
+	return migrations, err
+}
+
+// testOpen opens the database without applying migrations.
+func testOpen(t *testing.T, params Params) DB {
+	t.Helper()
+	db, err := open(params)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+
+	t.Cleanup(func() { closeDB(db) })
+	return DB{DB: db}
+}
+
+func appliedVersions(t *testing.T, db DB) []int {
+	t.Helper()
+	var versions []int
+	if err := db.Model(new(SchemaMigration)).Where("job = ?", "test").Order("version").Pluck("version", &versions).Error; err != nil {
+		t.Fatalf("select applied versions: %v", err)
+	}
+
+	return versions
+}
+
+func descriptions(migrations []Migration) []string {
+	result := make([]string, len(migrations))
+	for i, migration := range migrations {
+		result[i] = migration.Description
+	}
+
+	return result
+}
+
+func TestMigrate_Apply(t *testing.T) {
+	params := newMigrationParams(t,
+		Migration{Version: 1, Description: "create accounts", Up: AutoMigrate(new(testMigrationAccount))},
+		Migration{Version: 2, Description: "create operations", Up: AutoMigrate(new(testMigrationOperation))},
+	)
+
+	migrations, err := testMigrate(t, params, false)
+	if err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	if actual := strings.Join(descriptions(migrations), ", "); actual != "create accounts, create operations" {
+		t.Errorf("expected both migrations to be applied, got %q", actual)
+	}
+
+	db := testOpen(t, params)
+	if versions := appliedVersions(t, db); len(versions) != 2 || versions[0] != 1 || versions[1] != 2 {
+		t.Errorf("expected versions 1 and 2 to be recorded, got %v", versions)
+	}
+
+	if !db.Migrator().HasTable(new(testMigrationOperation)) {
+		t.Error("expected operations table to be created")
+	}
+
+	// applied migrations are not run again
+	params.Migrations[0].Up = func(tx DB) error { return errors.New("applied again") }
+	if migrations, err := testMigrate(t, params, false); err != nil || len(migrations) != 0 {
+		t.Errorf("expected no pending migrations, got %v (%v)", descriptions(migrations), err)
+	}
+}
+
+func TestMigrate_Plan(t *testing.T) {
+	params := newMigrationParams(t,
+		Migration{Version: 1, Description: "create accounts", Up: AutoMigrate(new(testMigrationAccount))},
+	)
+
+	if _, err := testMigrate(t, params, false); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	params.Migrations = append(params.Migrations,
+		Migration{Version: 2, Description: "create operations", Up: AutoMigrate(new(testMigrationOperation))},
+		Migration{Version: 3, Description: "seed accounts", Up: Exec("insert into accounts (id, value) values ('1', 100)")},
+	)
+
+	migrations, err := testMigrate(t, params, true)
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+
+	if actual := strings.Join(descriptions(migrations), ", "); actual != "create operations, seed accounts" {
+		t.Errorf("expected pending migrations to be planned, got %q", actual)
+	}
+
+	db := testOpen(t, params)
+	if versions := appliedVersions(t, db); len(versions) != 1 {
+		t.Errorf("expected planned migrations not to be recorded, got %v", versions)
+	}
+
+	if db.Migrator().HasTable(new(testMigrationOperation)) {
+		t.Error("expected planned migrations not to be applied")
+	}
+
+	if err := checkMigrated(db, params); err == nil {
+		t.Error("expected pending migrations to be reported")
+	}
+}
+
+func TestMigrate_Rollback(t *testing.T) {
+	params := newMigrationParams(t,
+		Migration{Version: 1, Description: "create accounts", Up: AutoMigrate(new(testMigrationAccount))},
+		Migration{Version: 2, Description: "create operations", Up: Steps(
+			AutoMigrate(new(testMigrationOperation)),
+			Exec("insert into accounts (id, value) values ('1', 100)"),
+			func(tx DB) error { return errors.New("step failed") },
+		)},
+		Migration{Version: 3, Description: "never applied", Up: Exec("insert into accounts (id, value) values ('2', 200)")},
+	)
+
+	_, err := testMigrate(t, params, false)
+	if err == nil || !strings.Contains(err.Error(), "apply migration 2 (create operations): step failed") {
+		t.Fatalf("expected migration 2 to fail, got %v", err)
+	}
+
+	db := testOpen(t, params)
+	if versions := appliedVersions(t, db); len(versions) != 1 || versions[0] != 1 {
+		t.Errorf("expected only version 1 to be recorded, got %v", versions)
+	}
+
+	if db.Migrator().HasTable(new(testMigrationOperation)) {
+		t.Error("expected operations table to be rolled back")
+	}
+
+	var count int64
+	if err := db.Model(new(testMigrationAccount)).Count(&count).Error; err != nil {
+		t.Fatalf("count accounts: %v", err)
+	} else if count != 0 {
+		t.Errorf("expected inserted accounts to be rolled back, got %d", count)
+	}
+
+	migrations, err := testMigrate(t, params, true)
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+
+	if actual := strings.Join(descriptions(migrations), ", "); actual != "create operations, never applied" {
+		t.Errorf("expected failed migrations to remain pending, got %q", actual)
+	}
+}
+
+func TestMigrate_UnorderedVersions(t *testing.T) {
+	params := newMigrationParams(t,
+		Migration{Version: 2, Description: "create operations", Up: AutoMigrate(new(testMigrationOperation))},
+		Migration{Version: 1, Description: "create accounts", Up: AutoMigrate(new(testMigrationAccount))},
+	)
+
+	if _, err := testMigrate(t, params, true); err == nil || !strings.Contains(err.Error(), "not ordered") {
+		t.Errorf("expected unordered versions to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package database
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+)
+
+const moneyScale = 2
+
+// Money is a fixed-point amount with two decimal places.
+// It is stored as an integer number of minor units (kopecks), so that
+// aggregates are exact and portable across all supported drivers.
+type Money int64
+
+func NewMoney(value decimal.Decimal) Money {
+	return Money(value.Round(moneyScale).Shift(moneyScale).IntPart())
+}
+
+func MoneyFromFloat(value float64) Money {
+	return NewMoney(decimal.NewFromFloat(value))
+}
+
+func ParseMoney(value string) (Money, error) {
+	d, err := decimal.NewFromString(value)
+	if err != nil {
+		return 0, errors.Wrapf(err, "parse money %q", value)
+	}
+
+	return NewMoney(d), nil
+}
+
+func (m Money) Decimal() decimal.Decimal {
+	return decimal.New(int64(m), -moneyScale)
+}
+
+func (m Money) String() string {
+	return m.Decimal().StringFixed(moneyScale)
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+
+	value := string(data)
+	if len(data) > 0 && data[0] == '"' {
+		if err := json.Unmarshal(data, &value); err != nil {
+			return err
+		}
+
+		if value == "" {
+			*m = 0
+			return nil
+		}
+	}
+
+	var err error
+	*m, err = ParseMoney(value)
+	return err
+}
+
+func (m Money) Value() (driver.Value, error) {
+	return int64(m), nil
+}
+
+func (m *Money) Scan(value any) error {
+	switch value := value.(type) {
+	case nil:
+		*m = 0
+	case int64:
+		*m = Money(value)
+	case float64:
+		*m = Money(decimal.NewFromFloat(value).Round(0).IntPart())
+	case []byte:
+		return m.scanString(string(value))
+	case string:
+		return m.scanString(value)
+	default:
+		return errors.Errorf("expected int64, got %T", value)
+	}
+
+	return nil
+}
+
+func (m *Money) scanString(value string) error {
+	if v, err := strconv.ParseInt(value, 10, 64); err == nil {
+		*m = Money(v)
+		return nil
+	}
+
+	d, err := decimal.NewFromString(value)
+	if err != nil {
+		return errors.Wrapf(err, "scan money %q", value)
+	}
+
+	*m = Money(d.Round(0).IntPart())
+	return nil
+}
@@ -0,0 +1,327 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// RetentionTarget is a table which records may be pruned by retention rules.
+type RetentionTarget struct {
+	Table string
+
+	// Key lists the primary key columns which are used to delete selected records.
+	Key []string
+
+	// Time is an SQL expression of the record time. It may be a column or a subquery selecting the time
+	// of the parent record, referencing the table by its name.
+	Time string
+
+	// Where is an optional SQL condition limiting records which may be pruned.
+	Where string
+
+	// References lists tables which records reference pruned records without foreign keys.
+	// They are deleted along with pruned records.
+	References []RetentionReference
+
+	// children are deleted along with pruned records, see WithRetentionChildren.
+	children []retentionChild
+}
+
+// RetentionReference is a table which records reference records of a retention target by a key
+// which is not a foreign key, e.g. Firefly mappings keyed by entity names.
+type RetentionReference struct {
+	Table string
+
+	// Columns reference the key columns of the target, in the same order.
+	Columns []string
+
+	// Where is an optional SQL condition limiting referencing records.
+	Where string
+}
+
+// retentionChild is a table which records reference the parent table and are deleted on cascade.
+type retentionChild struct {
+	table      string
+	columns    []string
+	references []string
+	where      string
+	children   []retentionChild
+}
+
+// WithRetentionChildren returns targets with records referencing them on cascade delete, according to
+// foreign key constraints declared by the entities. These records are deleted in the same transaction
+// as the pruned ones, since foreign keys may not be enforced by the database.
+func WithRetentionChildren(targets []RetentionTarget, entities ...any) ([]RetentionTarget, error) {
+	var (
+		cache      sync.Map
+		seen       = make(map[string]bool)
+		references = make(map[string][]*schema.Constraint)
+	)
+
+	for _, entity := range entities {
+		s, err := schema.Parse(entity, &cache, namingStrategy)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse %T", entity)
+		}
+
+		for _, constraint := range constraints(&s.Relationships) {
+			if constraint.Schema.Table == constraint.ReferenceSchema.Table ||
+				!strings.EqualFold(constraint.OnDelete, "cascade") {
+				continue
+			}
+
+			key := constraint.Schema.Table + ":" + constraint.ReferenceSchema.Table
+			for _, field := range constraint.ForeignKeys {
+				key += ":" + field.DBName
+			}
+
+			if seen[key] {
+				continue
+			}
+
+			seen[key] = true
+			references[constraint.ReferenceSchema.Table] = append(references[constraint.ReferenceSchema.Table], constraint)
+		}
+	}
+
+	result := slices.Clone(targets)
+	for i := range result {
+		result[i].children = retentionChildren(references, result[i].Table, map[string]bool{result[i].Table: true})
+	}
+
+	return result, nil
+}
+
+func retentionChildren(references map[string][]*schema.Constraint, table string, path map[string]bool) []retentionChild {
+	var children []retentionChild
+	for _, constraint := range references[table] {
+		child := retentionChild{table: constraint.Schema.Table}
+		if path[child.table] {
+			continue
+		}
+
+		for i, field := range constraint.ForeignKeys {
+			child.columns = append(child.columns, field.DBName)
+			child.references = append(child.references, constraint.References[i].DBName)
+		}
+
+		path[child.table] = true
+		child.children = retentionChildren(references, child.table, path)
+		delete(path, child.table)
+		children = append(children, child)
+	}
+
+	return children
+}
+
+// RetentionRule limits records stored in a table. A record is pruned if it is older than MaxAge
+// and is not one of KeepLatest latest records. Zero limits are not applied.
+type RetentionRule struct {
+	MaxAge     time.Duration
+	KeepLatest int
+}
+
+// Pruned is the number of records deleted from a table.
+type Pruned struct {
+	Table string
+	Rows  int64
+}
+
+// RetentionTarget returns the target for the table if it may be pruned.
+func (p Params) RetentionTarget(table string) (RetentionTarget, bool) {
+	i := slices.IndexFunc(p.Retention, func(target RetentionTarget) bool { return target.Table == table })
+	if i < 0 {
+		return RetentionTarget{}, false
+	}
+
+	return p.Retention[i], true
+}
+
+// Prune deletes records according to rules keyed by table names. Records are deleted in batches,
+// each batch in a separate transaction along with records referencing them (see WithRetentionChildren
+// and RetentionTarget.References),
+// so that the database is not locked for long. Other orphans are left to Fsck.
+func Prune(ctx context.Context, params Params, rules map[string]RetentionRule, batchSize int) ([]Pruned, error) {
+	targets := make(map[string]RetentionTarget, len(rules))
+	for table, rule := range rules {
+		target, ok := params.RetentionTarget(table)
+		if !ok {
+			return nil, errors.Errorf("retention is not supported for %s", table)
+		}
+
+		if rule.MaxAge <= 0 && rule.KeepLatest <= 0 {
+			return nil, errors.Errorf("retention rule for %s has no limits", table)
+		}
+
+		targets[table] = target
+	}
+
+	db, err := open(params)
+	if err != nil {
+		return nil, err
+	}
+
+	defer closeDB(db)
+	if err := checkMigrated(DB{DB: db.WithContext(ctx)}, params); err != nil {
+		return nil, err
+	}
+
+	var (
+		pruned = make(map[string]int64)
+		now    = params.Clock.Now()
+	)
+
+	for _, table := range sortedKeys(rules) {
+		if err := prune(DB{DB: db.WithContext(ctx)}, targets[table], rules[table], now, batchSize, pruned); err != nil {
+			return prunedTables(pruned), errors.Wrapf(err, "prune %s", table)
+		}
+	}
+
+	return prunedTables(pruned), nil
+}
+
+func prunedTables(pruned map[string]int64) []Pruned {
+	var result []Pruned
+	for _, table := range sortedKeys(pruned) {
+		if rows := pruned[table]; rows > 0 {
+			result = append(result, Pruned{Table: table, Rows: rows})
+		}
+	}
+
+	return result
+}
+
+// prune deletes records of the target in batches and adds the numbers of deleted records by tables to pruned.
+func prune(db DB, target RetentionTarget, rule RetentionRule, now time.Time, batchSize int, pruned map[string]int64) error {
+	query := func() *gorm.DB {
+		query := db.Table(target.Table)
+		if target.Where != "" {
+			query = query.Where(target.Where)
+		}
+
+		return query
+	}
+
+	find := func() *gorm.DB {
+		find := query().Select(target.Key)
+		if rule.MaxAge > 0 {
+			find = find.Where(target.Time+" < ?", now.Add(-rule.MaxAge))
+		}
+
+		if rule.KeepLatest > 0 {
+			// the time of the last kept record is null if there are not enough records, so nothing is pruned
+			latest := query().
+				Select(target.Time).
+				Order(target.Time + " desc").
+				Offset(rule.KeepLatest - 1).
+				Limit(1)
+			find = find.Where(target.Time+" < (?)", latest)
+		}
+
+		return find
+	}
+
+	children := slices.Clone(target.children)
+	for _, reference := range target.References {
+		children = append(children, retentionChild{
+			table:      reference.Table,
+			columns:    reference.Columns,
+			references: target.Key,
+			where:      reference.Where,
+		})
+	}
+
+	// selected records are referenced by children via the subquery
+	selected := "select %s from " + target.Table + " where " + tuple(target.Key) + " in ?"
+	for {
+		var records []map[string]any
+		if err := find().Limit(batchSize).Find(&records).Error; err != nil {
+			return errors.Wrap(err, "select records")
+		}
+
+		if len(records) == 0 {
+			return nil
+		}
+
+		keys := make([]any, len(records))
+		for i, record := range records {
+			if len(target.Key) == 1 {
+				keys[i] = record[target.Key[0]]
+				continue
+			}
+
+			values := make([]any, len(target.Key))
+			for j, column := range target.Key {
+				values[j] = record[column]
+			}
+
+			keys[i] = values
+		}
+
+		deleted := make(map[string]int64)
+		if err := db.Transaction(func(tx DB) error {
+			if err := pruneChildren(tx, children, selected, keys, deleted); err != nil {
+				return err
+			}
+
+			result := tx.Exec("delete from "+target.Table+" where "+tuple(target.Key)+" in ?", keys)
+			if result.Error != nil {
+				return errors.Wrap(result.Error, "delete records")
+			}
+
+			deleted[target.Table] += result.RowsAffected
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		for table, rows := range deleted {
+			pruned[table] += rows
+		}
+
+		if len(records) < batchSize || deleted[target.Table] == 0 {
+			return nil
+		}
+	}
+}
+
+// pruneChildren deletes records of children (and their children first) which reference the parent records
+// selected by the parent query. The query has a placeholder for the list of selected columns.
+func pruneChildren(tx DB, children []retentionChild, parent string, keys []any, deleted map[string]int64) error {
+	for _, child := range children {
+		condition := tuple(child.columns) + " in (" + fmt.Sprintf(parent, strings.Join(child.references, ", ")) + ")"
+		if child.where != "" {
+			condition += " and (" + child.where + ")"
+		}
+
+		if err := pruneChildren(tx, child.children, "select %s from "+child.table+" where "+condition, keys, deleted); err != nil {
+			return err
+		}
+
+		result := tx.Exec("delete from "+child.table+" where "+condition, keys)
+		if result.Error != nil {
+			return errors.Wrapf(result.Error, "delete %s", child.table)
+		}
+
+		deleted[child.table] += result.RowsAffected
+	}
+
+	return nil
+}
+
+// tuple returns the column or the row value of columns for use in IN conditions.
+func tuple(columns []string) string {
+	if len(columns) == 1 {
+		return columns[0]
+	}
+
+	return "(" + strings.Join(columns, ", ") + ")"
+}
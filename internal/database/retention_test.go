@@ -0,0 +1,110 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testRetentionOperation struct {
+	Id           string `gorm:"primaryKey"`
+	Time         time.Time
+	DebitingTime *time.Time
+
+	Locations []testRetentionLocation `gorm:"foreignKey:OperationId;constraint:OnDelete:CASCADE"`
+}
+
+func (testRetentionOperation) TableName() string {
+	return "operations"
+}
+
+type testRetentionLocation struct {
+	OperationId string `gorm:"primaryKey"`
+	DbIdx       int    `gorm:"primaryKey;autoIncrement:false"`
+}
+
+func (testRetentionLocation) TableName() string {
+	return "locations"
+}
+
+type testRetentionMapping struct {
+	Entity    string `gorm:"primaryKey"`
+	Key       string `gorm:"primaryKey;column:entity_key"`
+	FireflyId string
+}
+
+func (testRetentionMapping) TableName() string {
+	return "mappings"
+}
+
+func TestPrune_References(t *testing.T) {
+	ctx := context.Background()
+	params := newMigrationParams(t, Migration{
+		Version:     1,
+		Description: "create tables",
+		Up:          AutoMigrate(new(testRetentionOperation), new(testRetentionLocation), new(testRetentionMapping)),
+	})
+
+	retention, err := WithRetentionChildren([]RetentionTarget{{
+		Table: "operations",
+		Key:   []string{"id"},
+		Time:  "time",
+		Where: "debiting_time is null",
+		References: []RetentionReference{
+			{Table: "mappings", Columns: []string{"entity_key"}, Where: "entity = 'operations'"},
+		},
+	}}, new(testRetentionOperation), new(testRetentionLocation))
+	if err != nil {
+		t.Fatalf("create retention targets: %v", err)
+	}
+
+	params.Retention = retention
+	if _, err := testMigrate(t, params, false); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	var (
+		now = time.Now()
+		old = now.Add(-48 * time.Hour)
+		db  = testOpen(t, params)
+	)
+
+	for _, value := range []any{
+		&testRetentionOperation{Id: "pruned", Time: old, Locations: []testRetentionLocation{{DbIdx: 1}}},
+		&testRetentionOperation{Id: "debited", Time: old, DebitingTime: &old},
+		&testRetentionOperation{Id: "recent", Time: now},
+		&testRetentionMapping{Entity: "operations", Key: "pruned", FireflyId: "1"},
+		&testRetentionMapping{Entity: "operations", Key: "debited", FireflyId: "2"},
+		&testRetentionMapping{Entity: "operations", Key: "recent", FireflyId: "3"},
+		&testRetentionMapping{Entity: "statements", Key: "pruned", FireflyId: "4"},
+	} {
+		if err := db.Create(value).Error; err != nil {
+			t.Fatalf("create %T: %v", value, err)
+		}
+	}
+
+	pruned, err := Prune(ctx, params, map[string]RetentionRule{"operations": {MaxAge: 24 * time.Hour}}, 10)
+	if err != nil {
+		t.Fatalf("prune: %v", err)
+	}
+
+	var actual []string
+	for _, p := range pruned {
+		actual = append(actual, fmt.Sprintf("%s=%d", p.Table, p.Rows))
+	}
+
+	if actual := strings.Join(actual, ", "); actual != "locations=1, mappings=1, operations=1" {
+		t.Errorf("expected pruned operation with its location and mapping, got %q", actual)
+	}
+
+	var ids []string
+	if err := db.Model(new(testRetentionMapping)).Order("firefly_id").Pluck("firefly_id", &ids).Error; err != nil {
+		t.Fatalf("select mappings: %v", err)
+	}
+
+	if actual := strings.Join(ids, ", "); actual != "2, 3, 4" {
+		t.Errorf("expected mappings of kept operations and other entities to be kept, got %q", actual)
+	}
+}
@@ -0,0 +1,113 @@
+package database
+
+import (
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// UpsertPolicy defines how a column of an existing record is updated on upsert.
+// Policies are declared with the upsert tag of entity fields, for example:
+//
+//	DebitingTime *time.Time `upsert:"coalesce"`
+//
+// Policies apply to all upserts of the entity, including the ones of associations saved along with other entities.
+type UpsertPolicy string
+
+const (
+	// UpsertOverwrite replaces the stored value with the new one. This is the default policy.
+	UpsertOverwrite UpsertPolicy = "overwrite"
+
+	// UpsertCoalesce keeps the stored value if the new one is null, e.g. if it was omitted in an API response.
+	UpsertCoalesce UpsertPolicy = "coalesce"
+
+	// UpsertMax keeps the greatest of the stored and the new values. Nulls are ignored.
+	UpsertMax UpsertPolicy = "max"
+
+	// UpsertKeep never updates the stored value, e.g. if it may be edited manually.
+	UpsertKeep UpsertPolicy = "keep"
+)
+
+const (
+	upsertTag = "upsert"
+
+	// excludedTable refers to the record proposed for insertion in ON CONFLICT clauses.
+	excludedTable = "excluded"
+)
+
+// excludedColumn refers to the value of the column proposed for insertion.
+// MySQL does not support the excluded table in expressions, so VALUES() is used instead.
+type excludedColumn string
+
+func (c excludedColumn) Build(builder clause.Builder) {
+	if stmt, ok := builder.(*gorm.Statement); ok && stmt.Dialector.Name() == "mysql" {
+		_, _ = builder.WriteString("VALUES(")
+		builder.WriteQuoted(string(c))
+		_ = builder.WriteByte(')')
+		return
+	}
+
+	builder.WriteQuoted(clause.Column{Table: excludedTable, Name: string(c)})
+}
+
+// onConflictBuilder wraps the ON CONFLICT clause builder of the dialect (which may be nil) to apply upsert policies.
+// Policies are applied when the clause is built, since until then UpdateAll clauses do not list the updated columns.
+func onConflictBuilder(build clause.ClauseBuilder) clause.ClauseBuilder {
+	return func(c clause.Clause, builder clause.Builder) {
+		if stmt, ok := builder.(*gorm.Statement); ok && stmt.Schema != nil {
+			if onConflict, ok := c.Expression.(clause.OnConflict); ok && !onConflict.DoNothing && len(onConflict.DoUpdates) > 0 {
+				c.Expression = applyUpsertPolicies(stmt, onConflict)
+			}
+		}
+
+		if build != nil {
+			build(c, builder)
+		} else {
+			c.Build(builder)
+		}
+	}
+}
+
+// applyUpsertPolicies replaces assignments of new values to columns according to policies of their fields.
+// Other assignments are left as is.
+func applyUpsertPolicies(stmt *gorm.Statement, onConflict clause.OnConflict) clause.OnConflict {
+	updates := make(clause.Set, 0, len(onConflict.DoUpdates))
+	for _, assignment := range onConflict.DoUpdates {
+		value, ok := assignment.Value.(clause.Column)
+		field := stmt.Schema.LookUpField(assignment.Column.Name)
+		if !ok || value.Table != excludedTable || value.Name != assignment.Column.Name || field == nil {
+			updates = append(updates, assignment)
+			continue
+		}
+
+		var (
+			excluded = excludedColumn(field.DBName)
+			current  = clause.Column{Table: clause.CurrentTable, Name: field.DBName}
+		)
+
+		switch policy := UpsertPolicy(field.Tag.Get(upsertTag)); policy {
+		case "", UpsertOverwrite:
+		case UpsertCoalesce:
+			assignment.Value = clause.Expr{SQL: "coalesce(?, ?)", Vars: []any{excluded, current}}
+		case UpsertMax:
+			assignment.Value = clause.Expr{
+				SQL:  "case when ? is null or ? > ? then ? else ? end",
+				Vars: []any{excluded, current, excluded, current, excluded},
+			}
+		case UpsertKeep:
+			continue
+		default:
+			_ = stmt.AddError(errors.Errorf("unsupported upsert policy %q of %s.%s", policy, stmt.Schema.Table, field.DBName))
+		}
+
+		updates = append(updates, assignment)
+	}
+
+	if len(updates) == 0 {
+		// all columns are kept
+		onConflict.DoNothing = true
+	}
+
+	onConflict.DoUpdates = updates
+	return onConflict
+}
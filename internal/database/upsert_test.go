@@ -0,0 +1,215 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/AlekSi/pointer"
+	"github.com/jfk9w-go/based"
+)
+
+type testUpsertParent struct {
+	Id        string  `gorm:"primaryKey"`
+	Name      string  `upsert:"overwrite"`
+	Note      *string `upsert:"keep"`
+	Authority *string `upsert:"coalesce"`
+	Statement *int64  `upsert:"max"`
+
+	Children []testUpsertChild `gorm:"foreignKey:ParentId;constraint:OnDelete:CASCADE"`
+}
+
+func (testUpsertParent) TableName() string {
+	return "upsert_parents"
+}
+
+type testUpsertChild struct {
+	ParentId  string  `gorm:"primaryKey"`
+	DbIdx     int     `gorm:"primaryKey;autoIncrement:false"`
+	Name      string  `upsert:"overwrite"`
+	Note      *string `upsert:"keep"`
+	Authority *string `upsert:"coalesce"`
+	Statement *int64  `upsert:"max"`
+}
+
+func (testUpsertChild) TableName() string {
+	return "upsert_children"
+}
+
+// testUpsertKept has only kept columns besides the primary key, so that conflicts are ignored.
+type testUpsertKept struct {
+	Id   string `gorm:"primaryKey"`
+	Note string `upsert:"keep"`
+}
+
+func (testUpsertKept) TableName() string {
+	return "upsert_kept"
+}
+
+type testUpsertInvalid struct {
+	Id   string `gorm:"primaryKey"`
+	Name string `upsert:"unknown"`
+}
+
+func (testUpsertInvalid) TableName() string {
+	return "upsert_invalid"
+}
+
+func newUpsertTest(t *testing.T) DB {
+	ctx := context.Background()
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", strings.ReplaceAll(t.Name(), "/", "_"))
+	db, err := Open(ctx, Params{
+		Clock:  based.StandardClock,
+		Logger: slog.Default(),
+		Config: Config{Driver: "sqlite", DSN: dsn},
+		Name:   "test",
+		Migrations: []Migration{
+			{
+				Version:     1,
+				Description: "create tables",
+				Up:          AutoMigrate(new(testUpsertParent), new(testUpsertChild), new(testUpsertKept), new(testUpsertInvalid)),
+			},
+		},
+	})
+
+	if err != nil && strings.Contains(err.Error(), "cgo") {
+		t.Skipf("sqlite is not available: %v", err)
+	}
+
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB.DB(); err == nil {
+			_ = sqlDB.Close()
+		}
+	})
+
+	return db
+}
+
+type upsertValues struct {
+	name      string
+	note      *string
+	authority *string
+	statement *int64
+}
+
+func (v upsertValues) parent() *testUpsertParent {
+	return &testUpsertParent{
+		Id:        "1",
+		Name:      v.name,
+		Note:      v.note,
+		Authority: v.authority,
+		Statement: v.statement,
+		Children: []testUpsertChild{{
+			DbIdx:     1,
+			Name:      v.name,
+			Note:      v.note,
+			Authority: v.authority,
+			Statement: v.statement,
+		}},
+	}
+}
+
+func assertUpserted(t *testing.T, entity string, actual, expected upsertValues) {
+	t.Helper()
+	if actual.name != expected.name {
+		t.Errorf("%s: expected overwritten name %q, got %q", entity, expected.name, actual.name)
+	}
+
+	if pointer.Get(actual.note) != pointer.Get(expected.note) {
+		t.Errorf("%s: expected kept note %q, got %q", entity, pointer.Get(expected.note), pointer.Get(actual.note))
+	}
+
+	if pointer.Get(actual.authority) != pointer.Get(expected.authority) {
+		t.Errorf("%s: expected authority %q, got %q", entity, pointer.Get(expected.authority), pointer.Get(actual.authority))
+	}
+
+	if pointer.Get(actual.statement) != pointer.Get(expected.statement) {
+		t.Errorf("%s: expected statement %d, got %d", entity, pointer.Get(expected.statement), pointer.Get(actual.statement))
+	}
+}
+
+func testUpsert(t *testing.T, initial, update, expected upsertValues) {
+	t.Helper()
+	db := newUpsertTest(t)
+	for _, values := range []upsertValues{initial, update} {
+		if err := db.Upsert(values.parent()).Error; err != nil {
+			t.Fatalf("upsert: %v", err)
+		}
+	}
+
+	var parent testUpsertParent
+	if err := db.Preload("Children").Take(&parent).Error; err != nil {
+		t.Fatalf("select parent: %v", err)
+	}
+
+	assertUpserted(t, "parent", upsertValues{parent.Name, parent.Note, parent.Authority, parent.Statement}, expected)
+	if len(parent.Children) != 1 {
+		t.Fatalf("expected 1 child, got %d", len(parent.Children))
+	}
+
+	child := parent.Children[0]
+	assertUpserted(t, "child", upsertValues{child.Name, child.Note, child.Authority, child.Statement}, expected)
+}
+
+func TestUpsert_NewValues(t *testing.T) {
+	testUpsert(t,
+		upsertValues{name: "old", note: pointer.To("manual"), authority: pointer.To("old"), statement: pointer.To[int64](1)},
+		upsertValues{name: "new", note: pointer.To("loaded"), authority: pointer.To("new"), statement: pointer.To[int64](2)},
+		upsertValues{name: "new", note: pointer.To("manual"), authority: pointer.To("new"), statement: pointer.To[int64](2)},
+	)
+}
+
+func TestUpsert_NullValues(t *testing.T) {
+	testUpsert(t,
+		upsertValues{name: "old", note: pointer.To("manual"), authority: pointer.To("old"), statement: pointer.To[int64](2)},
+		upsertValues{name: ""},
+		upsertValues{name: "", note: pointer.To("manual"), authority: pointer.To("old"), statement: pointer.To[int64](2)},
+	)
+}
+
+func TestUpsert_LesserValues(t *testing.T) {
+	testUpsert(t,
+		upsertValues{name: "old", statement: pointer.To[int64](2)},
+		upsertValues{name: "new", statement: pointer.To[int64](1)},
+		upsertValues{name: "new", statement: pointer.To[int64](2)},
+	)
+}
+
+func TestUpsert_StoredNulls(t *testing.T) {
+	testUpsert(t,
+		upsertValues{name: "old"},
+		upsertValues{name: "new", note: pointer.To("loaded"), authority: pointer.To("new"), statement: pointer.To[int64](1)},
+		upsertValues{name: "new", authority: pointer.To("new"), statement: pointer.To[int64](1)},
+	)
+}
+
+func TestUpsert_AllKept(t *testing.T) {
+	db := newUpsertTest(t)
+	for _, note := range []string{"manual", "loaded"} {
+		if err := db.Upsert(&testUpsertKept{Id: "1", Note: note}).Error; err != nil {
+			t.Fatalf("upsert: %v", err)
+		}
+	}
+
+	var kept testUpsertKept
+	if err := db.Take(&kept).Error; err != nil {
+		t.Fatalf("select: %v", err)
+	}
+
+	if kept.Note != "manual" {
+		t.Errorf("expected kept note, got %q", kept.Note)
+	}
+}
+
+func TestUpsert_UnsupportedPolicy(t *testing.T) {
+	db := newUpsertTest(t)
+	if err := db.Upsert(&testUpsertInvalid{Id: "1"}).Error; err == nil || !strings.Contains(err.Error(), `unsupported upsert policy "unknown"`) {
+		t.Errorf("expected unsupported policy error, got %v", err)
+	}
+}
@@ -0,0 +1,190 @@
+package events
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/jfk9w-go/based"
+	"github.com/pkg/errors"
+
+	"github.com/jfk9w/hoarder/internal/database"
+	"github.com/jfk9w/hoarder/internal/logs"
+)
+
+type Config struct {
+	Interval  time.Duration  `yaml:"interval,omitempty" default:"1m" doc:"Интервал проверки новых событий."`
+	Delay     time.Duration  `yaml:"delay,omitempty" default:"1m" doc:"Задержка доставки событий.\n\nСобытия доставляются не раньше, чем через указанное время после записи, чтобы не пропустить события из транзакций, завершившихся позже транзакций с более новыми событиями."`
+	BatchSize int            `yaml:"batchSize,omitempty" default:"100" doc:"Максимальное количество событий в одной доставке."`
+	Webhook   *WebhookConfig `yaml:"webhook,omitempty" doc:"Доставка событий POST-запросами."`
+	File      *FileConfig    `yaml:"file,omitempty" doc:"Запись событий в файл."`
+}
+
+// Subscriber receives events from the outbox.
+type Subscriber interface {
+	// ID identifies the subscriber in stored offsets, so it should not change between runs.
+	ID() string
+
+	// Handle processes events of a source in the order they were written.
+	// Events are delivered at least once: if handling fails, they are delivered again later.
+	Handle(ctx context.Context, events []Event) error
+}
+
+type DispatcherParams struct {
+	Clock  based.Clock  `validate:"required"`
+	Logger *slog.Logger `validate:"required"`
+	Config Config       `validate:"required"`
+}
+
+type source struct {
+	name string
+	db   database.DB
+}
+
+// Dispatcher periodically delivers events from outboxes of job databases to subscribers.
+// Each subscriber has its own offset in every database, which is advanced after the events are handled.
+type Dispatcher struct {
+	clock       based.Clock
+	log         *slog.Logger
+	interval    time.Duration
+	delay       time.Duration
+	batchSize   int
+	sources     []source
+	subscribers []Subscriber
+	mu          sync.RWMutex
+}
+
+func NewDispatcher(params DispatcherParams) (*Dispatcher, error) {
+	if err := based.Validate(params); err != nil {
+		return nil, err
+	}
+
+	d := &Dispatcher{
+		clock:     params.Clock,
+		log:       params.Logger,
+		interval:  params.Config.Interval,
+		delay:     params.Config.Delay,
+		batchSize: params.Config.BatchSize,
+	}
+
+	if config := params.Config.Webhook; config != nil {
+		d.Subscribe(newWebhookSink(params.Clock, *config))
+	}
+
+	if config := params.Config.File; config != nil {
+		d.Subscribe(fileSink{path: config.Path})
+	}
+
+	return d, nil
+}
+
+// Register adds the database of the job to the sources of events.
+func (d *Dispatcher) Register(name string, db database.DB) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.sources = append(d.sources, source{name: name, db: db})
+}
+
+// Outbox returns the outbox for jobs. It is disabled if there is no dispatcher or it has no subscribers,
+// since events would never be delivered otherwise.
+func (d *Dispatcher) Outbox() Outbox {
+	if d == nil {
+		return Outbox{}
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return Outbox{enabled: len(d.subscribers) > 0}
+}
+
+func (d *Dispatcher) Subscribe(subscriber Subscriber) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.subscribers = append(d.subscribers, subscriber)
+}
+
+// Run delivers events until the context is canceled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		d.Dispatch(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Dispatch delivers pending events of all sources to all subscribers.
+// Failed deliveries are logged and retried on the next call.
+func (d *Dispatcher) Dispatch(ctx context.Context) {
+	d.mu.RLock()
+	sources, subscribers := slices.Clone(d.sources), slices.Clone(d.subscribers)
+	d.mu.RUnlock()
+
+	for _, source := range sources {
+		for _, subscriber := range subscribers {
+			log := d.log.With("source", source.name, "consumer", subscriber.ID())
+			delivered, err := d.deliver(ctx, source, subscriber)
+			if delivered > 0 {
+				log.Debug("delivered events", "count", delivered)
+			}
+
+			if err != nil {
+				log.Error("failed to deliver events", logs.Error(err))
+			}
+		}
+	}
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, source source, subscriber Subscriber) (delivered int, err error) {
+	db := source.db.WithContext(ctx)
+	offset := Offset{Consumer: subscriber.ID()}
+	if err := db.
+		Where("consumer = ?", offset.Consumer).
+		Limit(1).
+		Find(&offset).
+		Error; err != nil {
+		return 0, errors.Wrap(err, "select offset")
+	}
+
+	until := d.clock.Now().Add(-d.delay)
+	for {
+		var events []Event
+		if err := db.
+			Where("id > ? and created_at <= ?", offset.EventId, until).
+			Order("id").
+			Limit(d.batchSize).
+			Find(&events).
+			Error; err != nil {
+			return delivered, errors.Wrap(err, "select events")
+		}
+
+		if len(events) == 0 {
+			return delivered, nil
+		}
+
+		for i := range events {
+			events[i].Source = source.name
+		}
+
+		if err := subscriber.Handle(ctx, events); err != nil {
+			return delivered, errors.Wrap(err, "handle events")
+		}
+
+		offset.EventId = events[len(events)-1].Id
+		if err := db.Upsert(&offset).Error; err != nil {
+			return delivered, errors.Wrap(err, "save offset")
+		}
+
+		delivered += len(events)
+		if len(events) < d.batchSize {
+			return delivered, nil
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package events
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/jfk9w/hoarder/internal/database"
+)
+
+// Types of events written by loaders.
+const (
+	OperationCreated       = "operation.created"
+	OperationDebited       = "operation.debited"
+	OperationAmountChanged = "operation.amount_changed"
+	AccountCreated         = "account.created"
+	AccountClosed          = "account.closed"
+	ReceiptFetched         = "receipt.fetched"
+)
+
+// Event is a domain event stored in the outbox table of a job database.
+// Events are written in the same transaction as the changes they describe, so that they are never lost
+// or emitted for rolled back changes.
+type Event struct {
+	Id        uint64          `json:"id" gorm:"primaryKey;autoIncrement"`
+	Type      string          `json:"type" gorm:"index;not null"`
+	Key       string          `json:"key" gorm:"column:entity_key;not null"`
+	Data      json.RawMessage `json:"data" gorm:"not null"`
+	CreatedAt time.Time       `json:"createdAt" gorm:"index;not null"`
+
+	// Source is the ID of the job which database the event is stored in. It is set on delivery.
+	Source string `json:"source" gorm:"-"`
+}
+
+func (Event) TableName() string {
+	return "outbox_events"
+}
+
+// Offset is the id of the last event delivered to a consumer.
+type Offset struct {
+	Consumer string `gorm:"primaryKey"`
+	EventId  uint64 `gorm:"not null"`
+}
+
+func (Offset) TableName() string {
+	return "outbox_offsets"
+}
+
+// Entities are created by job database migrations.
+var Entities = []any{
+	new(Event),
+	new(Offset),
+}
+
+// RetentionTarget allows pruning of old events, including the ones which were not delivered.
+var RetentionTarget = database.RetentionTarget{
+	Table: new(Event).TableName(),
+	Key:   []string{"id"},
+	Time:  "created_at",
+}
+
+// New creates an event of the type for the entity with the key. Data is marshaled to JSON.
+func New(eventType, key string, data any) (Event, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return Event{}, errors.Wrapf(err, "marshal %s data", eventType)
+	}
+
+	return Event{
+		Type: eventType,
+		Key:  key,
+		Data: payload,
+	}, nil
+}
+
+// Outbox writes events of a job. The zero value is disabled and discards events,
+// so that they do not pile up in the database when they are not delivered (see Dispatcher.Outbox).
+type Outbox struct {
+	enabled bool
+}
+
+// Enabled reports whether events are written, so that loaders may skip creating them.
+func (o Outbox) Enabled() bool {
+	return o.enabled
+}
+
+// Write stores events in the outbox. It should be called in the transaction which changes the data.
+func (o Outbox) Write(tx database.DB, events []Event) error {
+	if !o.enabled || len(events) == 0 {
+		return nil
+	}
+
+	return errors.Wrap(tx.CreateInBatches(&events, 100).Error, "write events")
+}
@@ -0,0 +1,120 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha3"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jfk9w-go/based"
+	"github.com/pkg/errors"
+)
+
+type WebhookConfig struct {
+	URL     string        `yaml:"url" doc:"URL, на который отправляются события в виде JSON-массива."`
+	Secret  string        `yaml:"secret,omitempty" doc:"Секрет для подписи запросов.\n\nПодпись передается в заголовке Signature в виде t=<время>,v1=<подпись>, где подпись – HMAC-SHA3-256 строки <время>.<тело запроса> в hex."`
+	Timeout time.Duration `yaml:"timeout,omitempty" default:"30s" doc:"Таймаут запроса."`
+}
+
+type FileConfig struct {
+	Path string `yaml:"path" doc:"Путь к файлу, в конец которого дописываются события, по одному JSON-объекту на строку (NDJSON)."`
+}
+
+// webhookSink posts events to a URL. Non-2xx responses are considered failures.
+type webhookSink struct {
+	clock  based.Clock
+	url    string
+	secret string
+	client *http.Client
+}
+
+func newWebhookSink(clock based.Clock, config WebhookConfig) *webhookSink {
+	return &webhookSink{
+		clock:  clock,
+		url:    config.URL,
+		secret: config.Secret,
+		client: &http.Client{Timeout: config.Timeout},
+	}
+}
+
+func (s *webhookSink) ID() string {
+	return "webhook"
+}
+
+func (s *webhookSink) Handle(ctx context.Context, events []Event) error {
+	body, err := json.Marshal(events)
+	if err != nil {
+		return errors.Wrap(err, "marshal events")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "create request")
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if s.secret != "" {
+		req.Header.Set("Signature", s.sign(body))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "send request")
+	}
+
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return errors.Errorf("unexpected response status: %s", resp.Status)
+	}
+
+	return nil
+}
+
+// sign returns the signature header in the format accepted by the Firefly III webhook server (see webhook.Server).
+func (s *webhookSink) sign(body []byte) string {
+	timestamp := strconv.FormatInt(s.clock.Now().Unix(), 10)
+	mac := hmac.New(func() hash.Hash { return sha3.New256() }, []byte(s.secret))
+	_, _ = io.WriteString(mac, timestamp+".")
+	_, _ = mac.Write(body)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// fileSink appends events to a file as newline-delimited JSON.
+type fileSink struct {
+	path string
+}
+
+func (s fileSink) ID() string {
+	return "file"
+}
+
+func (s fileSink) Handle(_ context.Context, events []Event) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			return errors.Wrap(err, "marshal event")
+		}
+	}
+
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return errors.Wrap(err, "open file")
+	}
+
+	defer file.Close()
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		return errors.Wrap(err, "write events")
+	}
+
+	return errors.Wrap(file.Sync(), "sync file")
+}
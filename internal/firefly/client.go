@@ -2,15 +2,125 @@ package firefly
 
 import (
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 
 	ht "github.com/ogen-go/ogen/http"
 )
 
+const (
+	retryBaseDelay = time.Second
+	retryMaxDelay  = time.Minute
+)
+
 type httpClient struct {
-	client ht.Client
+	client  ht.Client
+	limiter *limiter
+	retries int
 }
 
 func (c httpClient) Do(req *http.Request) (*http.Response, error) {
 	req.Header.Set("Accept", "application/json")
-	return c.client.Do(req)
+	for attempt := 0; ; attempt++ {
+		if err := c.limiter.wait(req); err != nil {
+			return nil, err
+		}
+
+		resp, err := c.client.Do(req)
+		if err != nil || attempt >= c.retries || !retryable(req, resp) {
+			return resp, err
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return resp, nil
+			}
+
+			req.Body = body
+		} else if req.Body != nil && req.Body != http.NoBody {
+			return resp, nil
+		}
+
+		delay := retryDelay(resp, attempt)
+		_ = resp.Body.Close()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// retryable checks if the request should be retried.
+// Requests creating objects are retried only if they were rejected by the rate limit, since on server
+// and gateway errors the object may have been created anyway. Such objects are found by their external ids
+// on the next sync instead.
+func retryable(req *http.Request, resp *http.Response) bool {
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return true
+	case req.Method == http.MethodPost:
+		return false
+	default:
+		return resp.StatusCode >= 500
+	}
+}
+
+// retryDelay respects the Retry-After header if present, otherwise it backs off exponentially.
+func retryDelay(resp *http.Response, attempt int) time.Duration {
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+		return min(time.Duration(seconds)*time.Second, retryMaxDelay)
+	}
+
+	return min(retryBaseDelay<<attempt, retryMaxDelay)
+}
+
+// limiter spaces requests evenly according to the rate limit.
+type limiter struct {
+	interval time.Duration
+	next     time.Time
+	mu       sync.Mutex
+}
+
+func newLimiter(rateLimit float64) *limiter {
+	if rateLimit <= 0 {
+		return nil
+	}
+
+	return &limiter{interval: time.Duration(float64(time.Second) / rateLimit)}
+}
+
+func (l *limiter) wait(req *http.Request) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+
+	l.next = at.Add(l.interval)
+	l.mu.Unlock()
+
+	delay := at.Sub(now)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-req.Context().Done():
+		return req.Context().Err()
+	case <-timer.C:
+		return nil
+	}
 }
@@ -5,8 +5,11 @@ import (
 )
 
 type Config struct {
-	ServerURL   string `yaml:"serverUrl" doc:"URL сервера Firefly III."`
-	AccessToken string `yaml:"accessToken" doc:"Персональный токен доступа."`
+	ServerURL   string  `yaml:"serverUrl" doc:"URL сервера Firefly III."`
+	AccessToken string  `yaml:"accessToken" doc:"Персональный токен доступа."`
+	Concurrency int     `yaml:"concurrency,omitempty" default:"4" doc:"Максимальное количество одновременных запросов к Firefly III при синхронизации транзакций."`
+	RateLimit   float64 `yaml:"rateLimit,omitempty" default:"10" doc:"Максимальное количество запросов к Firefly III в секунду.\n\nНулевое значение отключает ограничение."`
+	Retries     int     `yaml:"retries,omitempty" default:"3" doc:"Количество повторов запросов при ответах 429 и 5xx.\n\nЗапросы создания объектов повторяются только при ответе 429."`
 }
 
 func (c Config) FireflyIiiAuth(_ context.Context, _ string) (FireflyIiiAuth, error) {
@@ -0,0 +1,17 @@
+package firefly
+
+import (
+	"errors"
+	"fmt"
+)
+
+type Exception interface {
+	GetMessage() OptString
+	GetException() OptString
+}
+
+func ExceptionError(e Exception) error {
+	return errors.New(e.GetMessage().
+		Or(e.GetException().
+			Or(fmt.Sprintf("%T", e))))
+}
@@ -10,15 +10,22 @@ type ClientParams struct {
 	Config Config `validate:"required"`
 }
 
-func wrapClient(cfg *clientConfig) {
-	cfg.Client = httpClient{cfg.Client}
+func wrapClient(config Config) func(cfg *clientConfig) {
+	return func(cfg *clientConfig) {
+		cfg.Client = httpClient{
+			client:  cfg.Client,
+			limiter: newLimiter(config.RateLimit),
+			retries: config.Retries,
+		}
+	}
 }
 
+// NewDefaultClient creates a client which limits the rate of requests and retries them on 429 and 5xx responses.
 func NewDefaultClient(params ClientParams) (Invoker, error) {
 	if err := based.Validate(params); err != nil {
 		return nil, err
 	}
 
-	wrapClient := optionFunc[clientConfig](wrapClient)
+	wrapClient := optionFunc[clientConfig](wrapClient(params.Config))
 	return NewClient(params.Config.ServerURL, params.Config, wrapClient)
 }
@@ -0,0 +1,82 @@
+package firefly
+
+import (
+	"github.com/pkg/errors"
+)
+
+// DefaultInstance is the ID of the Firefly III instance used by users without a dedicated configuration.
+const DefaultInstance = "default"
+
+// Instance is a client for a Firefly III instance.
+// ID identifies the instance in stored Firefly ids mappings.
+// Concurrency limits the number of simultaneous requests made by syncs.
+type Instance struct {
+	Invoker
+	ID          string
+	Concurrency int
+}
+
+type InstancesParams struct {
+	Config Config
+	Users  map[string]Config
+}
+
+// Instances holds Firefly III clients for users.
+type Instances struct {
+	fallback *Instance
+	users    map[string]Instance
+}
+
+func NewInstances(params InstancesParams) (*Instances, error) {
+	instances := &Instances{users: make(map[string]Instance)}
+	if params.Config.ServerURL != "" {
+		instance, err := newInstance(DefaultInstance, params.Config)
+		if err != nil {
+			return nil, errors.Wrap(err, "create default client")
+		}
+
+		instances.fallback = &instance
+	}
+
+	for user, cfg := range params.Users {
+		instance, err := newInstance(user, cfg)
+		if err != nil {
+			return nil, errors.Wrapf(err, "create client for %s", user)
+		}
+
+		instances.users[user] = instance
+	}
+
+	return instances, nil
+}
+
+// Get returns the Firefly III instance configured for the user.
+// If there is no dedicated configuration for the user, the default instance is returned.
+func (i *Instances) Get(userID string) (Instance, bool) {
+	if i == nil {
+		return Instance{}, false
+	}
+
+	if instance, ok := i.users[userID]; ok {
+		return instance, true
+	}
+
+	if i.fallback != nil {
+		return *i.fallback, true
+	}
+
+	return Instance{}, false
+}
+
+func newInstance(id string, cfg Config) (Instance, error) {
+	client, err := NewDefaultClient(ClientParams{Config: cfg})
+	if err != nil {
+		return Instance{}, err
+	}
+
+	return Instance{
+		Invoker:     client,
+		ID:          id,
+		Concurrency: max(cfg.Concurrency, 1),
+	}, nil
+}
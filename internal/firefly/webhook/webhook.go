@@ -0,0 +1,235 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha3"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jfk9w-go/based"
+	"github.com/pkg/errors"
+
+	"github.com/jfk9w/hoarder/internal/logs"
+)
+
+const (
+	TriggerUpdateTransaction  = "UPDATE_TRANSACTION"
+	TriggerDestroyTransaction = "DESTROY_TRANSACTION"
+
+	maxBodySize = 1 << 20
+)
+
+type Config struct {
+	Address   string            `yaml:"address,omitempty" default:":8080" doc:"Адрес для приема вебхуков.\n\nВебхуки принимаются по пути /firefly/<инстанс>, где инстанс – default для общего инстанса Firefly III или имя пользователя для отдельного."`
+	Secrets   map[string]string `yaml:"secrets" doc:"Секреты вебхуков Firefly III по инстансам."`
+	Tolerance time.Duration     `yaml:"tolerance,omitempty" default:"5m" doc:"Максимальный возраст подписи вебхука."`
+}
+
+// Split is a part of a Firefly III transaction.
+type Split struct {
+	Type          string `json:"type"`
+	Description   string `json:"description"`
+	CategoryID    ID     `json:"category_id"`
+	SourceID      ID     `json:"source_id"`
+	DestinationID ID     `json:"destination_id"`
+}
+
+// Transaction is a Firefly III transaction group.
+type Transaction struct {
+	ID     ID      `json:"id"`
+	Splits []Split `json:"transactions"`
+}
+
+// Event is a Firefly III webhook message.
+type Event struct {
+	Instance    string      `json:"-"`
+	UUID        string      `json:"uuid"`
+	Trigger     string      `json:"trigger"`
+	Transaction Transaction `json:"content"`
+}
+
+// ID is a Firefly III object id which may be encoded either as a string or as a number.
+type ID string
+
+func (id *ID) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		*id = ""
+		return nil
+	}
+
+	if len(data) > 0 && data[0] == '"' {
+		var value string
+		if err := json.Unmarshal(data, &value); err != nil {
+			return err
+		}
+
+		*id = ID(value)
+		return nil
+	}
+
+	var value json.Number
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	*id = ID(value.String())
+	return nil
+}
+
+// Receiver handles webhook events.
+type Receiver interface {
+	ReceiveFireflyEvent(ctx context.Context, event Event) error
+}
+
+type ServerParams struct {
+	Clock  based.Clock  `validate:"required"`
+	Logger *slog.Logger `validate:"required"`
+	Config Config       `validate:"required"`
+}
+
+// Server receives Firefly III webhooks, verifies their signatures and passes events to receivers.
+type Server struct {
+	clock     based.Clock
+	log       *slog.Logger
+	config    Config
+	receivers []Receiver
+}
+
+func NewServer(params ServerParams) (*Server, error) {
+	if err := based.Validate(params); err != nil {
+		return nil, err
+	}
+
+	return &Server{
+		clock:  params.Clock,
+		log:    params.Logger,
+		config: params.Config,
+	}, nil
+}
+
+func (s *Server) Register(receiver Receiver) {
+	s.receivers = append(s.receivers, receiver)
+}
+
+// Run serves webhooks until the context is canceled.
+func (s *Server) Run(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.Handle("POST /firefly/{instance}", s)
+
+	server := &http.Server{
+		Addr:              s.config.Address,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	instance := r.PathValue("instance")
+	log := s.log.With("instance", instance)
+	secret, ok := s.config.Secrets[instance]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+	if err != nil {
+		log.Error("failed to read webhook body", logs.Error(err))
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.verify(r.Header.Get("Signature"), body, secret); err != nil {
+		log.Warn("invalid webhook signature", logs.Error(err))
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	var event Event
+	if err := json.Unmarshal(body, &event); err != nil {
+		log.Error("failed to parse webhook", logs.Error(err))
+		http.Error(w, "failed to parse body", http.StatusBadRequest)
+		return
+	}
+
+	event.Instance = instance
+	log = log.With("uuid", event.UUID, "trigger", event.Trigger, "firefly_id", event.Transaction.ID)
+	for _, receiver := range s.receivers {
+		if err := receiver.ReceiveFireflyEvent(r.Context(), event); err != nil {
+			log.Error("failed to receive webhook", logs.Error(err))
+			http.Error(w, "failed to receive webhook", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	log.Debug("received webhook")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// verify checks the signature header which has the form of "t=<timestamp>,v1=<signature>",
+// where the signature is HMAC-SHA3-256 of "<timestamp>.<body>" keyed with the webhook secret.
+func (s *Server) verify(header string, body []byte, secret string) error {
+	var timestamp, signature string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signature = value
+		}
+	}
+
+	if timestamp == "" || signature == "" {
+		return errors.New("missing timestamp or signature")
+	}
+
+	if s.config.Tolerance > 0 {
+		seconds, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return errors.Wrap(err, "parse timestamp")
+		}
+
+		if age := s.clock.Now().Sub(time.Unix(seconds, 0)); age > s.config.Tolerance || age < -s.config.Tolerance {
+			return errors.Errorf("signature timestamp is out of tolerance: %s", age)
+		}
+	}
+
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return errors.Wrap(err, "decode signature")
+	}
+
+	mac := hmac.New(func() hash.Hash { return sha3.New256() }, []byte(secret))
+	_, _ = io.WriteString(mac, timestamp+".")
+	_, _ = mac.Write(body)
+	if !hmac.Equal(mac.Sum(nil), expected) {
+		return errors.New("signature mismatch")
+	}
+
+	return nil
+}
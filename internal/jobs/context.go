@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log/slog"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/jfk9w/hoarder/internal/logs"
@@ -31,11 +32,24 @@ func (p contextPath) String() string {
 
 type AskFunc func(ctx context.Context, text string) (string, error)
 
+// report collects lines of the job run result.
+type report struct {
+	lines []string
+	mu    sync.Mutex
+}
+
+func (r *report) add(line string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lines = append(r.lines, line)
+}
+
 type Context struct {
-	std   context.Context
-	log   *slog.Logger
-	path  contextPath
-	askFn AskFunc
+	std    context.Context
+	log    *slog.Logger
+	path   contextPath
+	askFn  AskFunc
+	report *report
 }
 
 func NewContext(ctx context.Context, log *slog.Logger) Context {
@@ -65,6 +79,19 @@ func (ctx Context) withLog(key string, value any) Context {
 	return ctx
 }
 
+func (ctx Context) withReport(report *report) Context {
+	ctx.report = report
+	return ctx
+}
+
+// Report adds a line to the result of the job run, which is shown by triggers along with the job status.
+// Reports are ignored if the job is not run by the registry.
+func (ctx Context) Report(format string, args ...any) {
+	if ctx.report != nil {
+		ctx.report.add(ctx.path.String() + fmt.Sprintf(format, args...))
+	}
+}
+
 func (ctx Context) WithAskFn(askFn AskFunc) Context {
 	ctx.askFn = askFn
 	return ctx
@@ -20,11 +20,15 @@ type Interface interface {
 type Info struct {
 	ID          string
 	Description string
+
+	// Manual jobs are not run as a part of All.
+	Manual bool
 }
 
 type Result struct {
-	JobID string
-	Error error
+	JobID  string
+	Error  error
+	Report []string
 }
 
 type exclusiveJob struct {
@@ -68,10 +72,20 @@ func (r *Registry) Info() []Info {
 	return infos
 }
 
+func (r *Registry) manual(id string) bool {
+	for i := range r.jobs {
+		if info := r.jobs[i].Info(); info.ID == id {
+			return info.Manual
+		}
+	}
+
+	return false
+}
+
 func (r *Registry) Run(ctx Context, now time.Time, userID string, jobIDs []string) []Result {
 	var filter func(id string) bool
 	if len(jobIDs) == 0 || jobIDs[0] == All {
-		filter = func(_ string) bool { return true }
+		filter = func(id string) bool { return !r.manual(id) }
 	} else {
 		uniqueJobIDs := make(map[string]bool)
 		for _, jobID := range jobIDs {
@@ -98,7 +112,8 @@ func (r *Registry) Run(ctx Context, now time.Time, userID string, jobIDs []strin
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			err := job.Run(ctx.withLog("job", jobID), now, userID)
+			report := new(report)
+			err := job.Run(ctx.withLog("job", jobID).withReport(report), now, userID)
 			jobConfigured := !errors.Is(err, ErrJobUnconfigured)
 
 			mu.Lock()
@@ -109,7 +124,7 @@ func (r *Registry) Run(ctx Context, now time.Time, userID string, jobIDs []strin
 			}
 
 			if jobConfigured == configured {
-				results = append(results, Result{JobID: jobID, Error: err})
+				results = append(results, Result{JobID: jobID, Error: err, Report: report.lines})
 			}
 		}()
 	}
@@ -12,9 +12,17 @@ type Credential struct {
 	UserAgent string `yaml:"userAgent,omitempty" doc:"Используется для авторизации и обновления токена доступа.\n\nМожно подсмотреть в браузере при попытке авторизации." default:"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/114.0.0.0 Safari/537.36"`
 }
 
+type FireflyConfig struct {
+	CashAccount    string        `yaml:"cashAccount,omitempty" default:"Наличные" doc:"Счет в Firefly III для чеков, оплаченных наличными."`
+	UnknownAccount string        `yaml:"unknownAccount,omitempty" default:"Прочие карты" doc:"Счет в Firefly III для чеков, оплаченных картами, операции по которым не найдены в Firefly III."`
+	ItemSplits     bool          `yaml:"itemSplits,omitempty" doc:"Создавать отдельную часть транзакции для каждой позиции чека.\n\nПо умолчанию позиции чека перечисляются в заметках к транзакции."`
+	MatchWindow    time.Duration `yaml:"matchWindow,omitempty" default:"24h" doc:"Допустимое расхождение по времени между чеком и банковской операцией при поиске соответствия."`
+}
+
 type Config struct {
-	Database  database.Config         `yaml:"database" doc:"Настройки подключения к БД."`
+	Database  database.Config         `yaml:"database,omitempty" doc:"Настройки подключения к БД.\n\nЕсли драйвер не задан, используется общая БД (database) со схемой по идентификатору джобы."`
 	BatchSize int                     `yaml:"batchSize,omitempty" default:"1000" doc:"Количество чеков в одном запросе и количество фискальных данных за одно обновление."`
 	Timeout   time.Duration           `yaml:"timeout,omitempty" default:"5m" doc:"Таймаут для запросов."`
 	Users     map[string][]Credential `yaml:"users" doc:"Пользователи и их авторизационные данные."`
+	Firefly   FireflyConfig           `yaml:"firefly,omitempty" doc:"Настройки синхронизации чеков с Firefly III."`
 }
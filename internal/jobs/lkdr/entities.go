@@ -1,7 +1,16 @@
 package lkdr
 
 import (
+	"log/slog"
+
+	"github.com/jfk9w-go/based"
+	"github.com/pkg/errors"
+
+	"github.com/jfk9w/hoarder/internal/database"
+	"github.com/jfk9w/hoarder/internal/events"
 	. "github.com/jfk9w/hoarder/internal/jobs/lkdr/internal/entities"
+	fireflySync "github.com/jfk9w/hoarder/internal/jobs/lkdr/internal/sync/firefly"
+	"github.com/jfk9w/hoarder/internal/logs"
 )
 
 var entities = []any{
@@ -11,4 +20,65 @@ var entities = []any{
 	new(Receipt),
 	new(FiscalData),
 	new(FiscalDataItem),
+	new(FireflyMapping),
+}
+
+var money = []database.MoneyColumns{
+	{Model: new(Receipt), Columns: []string{"total_sum"}},
+	{Model: new(FiscalData), Columns: []string{"cash_total_sum", "credit_sum", "ecash_total_sum", "nds10", "nds18", "prepaid_sum", "provision_sum", "total_sum"}},
+	{Model: new(FiscalDataItem), Columns: []string{"price", "sum"}},
+}
+
+// getMigrations returns migrations of the job database. The initial migration is idempotent,
+// so that it may be applied to databases created before migrations were versioned.
+func getMigrations(cipher *database.Cipher) []database.Migration {
+	return []database.Migration{
+		{
+			Version:     1,
+			Description: "create tables",
+			Up:          database.ConvertMoney(money, database.Steps(database.AutoMigrate(entities...), migrateReceiptFireflyIds)),
+		},
+		{
+			Version:     2,
+			Description: "encrypt secrets",
+			Up: func(tx database.DB) error {
+				return (&storage{db: tx, cipher: cipher}).encryptSecrets()
+			},
+		},
+		{
+			Version:     3,
+			Description: "create outbox tables",
+			Up:          database.AutoMigrate(events.Entities...),
+		},
+	}
+}
+
+type MigrateParams struct {
+	Clock  based.Clock  `validate:"required"`
+	Logger *slog.Logger `validate:"required"`
+	Config Config       `validate:"required"`
+}
+
+// DatabaseParams returns parameters of the job database, which are used to migrate, back up, check and restore it
+// without creating the job.
+func DatabaseParams(params MigrateParams) (database.Params, error) {
+	cipher, err := database.NewCipher(params.Config.Database.Encryption)
+	if err != nil {
+		return database.Params{}, errors.Wrap(err, "create cipher")
+	}
+
+	checks, err := database.OrphanChecks(entities...)
+	if err != nil {
+		return database.Params{}, errors.Wrap(err, "create orphan checks")
+	}
+
+	return database.Params{
+		Clock:      params.Clock,
+		Logger:     params.Logger.With(logs.Database(JobID)),
+		Config:     params.Config.Database,
+		Name:       JobID,
+		Migrations: getMigrations(cipher),
+		Checks:     append(checks, fireflySync.Checks()...),
+		Retention:  []database.RetentionTarget{events.RetentionTarget},
+	}, nil
 }
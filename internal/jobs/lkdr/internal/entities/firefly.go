@@ -0,0 +1,15 @@
+package entities
+
+// FireflyMapping links a record to an object in a Firefly III instance.
+// Users syncing into different instances match receipts with different transactions, so Firefly ids are not stored in records themselves.
+type FireflyMapping struct {
+	Instance string `json:"instance" gorm:"primaryKey"`
+	Entity   string `json:"entity" gorm:"primaryKey"`
+	Key      string `json:"key" gorm:"primaryKey;column:entity_key"`
+
+	FireflyId string `json:"fireflyId" gorm:"index"`
+}
+
+func (m FireflyMapping) TableName() string {
+	return "firefly_mappings"
+}
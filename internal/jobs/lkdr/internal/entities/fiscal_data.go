@@ -1,17 +1,19 @@
 package entities
 
+import "github.com/jfk9w/hoarder/internal/database"
+
 type FiscalDataItem struct {
 	ReceiptKey string `json:"-" gorm:"primaryKey"`
 	DbIdx      int    `json:"dbIdx" gorm:"primaryKey"`
 
-	Name        string  `json:"name"`
-	Nds         int     `json:"nds"`
-	PaymentType int     `json:"paymentType"`
-	Price       float64 `json:"price"`
-	ProductType int     `json:"productType"`
-	ProviderInn *string `json:"providerInn"`
-	Quantity    float64 `json:"quantity"`
-	Sum         float64 `json:"sum"`
+	Name        string         `json:"name"`
+	Nds         int            `json:"nds"`
+	PaymentType int            `json:"paymentType"`
+	Price       database.Money `json:"price"`
+	ProductType int            `json:"productType"`
+	ProviderInn *string        `json:"providerInn"`
+	Quantity    float64        `json:"quantity"`
+	Sum         database.Money `json:"sum"`
 }
 
 type FiscalData struct {
@@ -19,10 +21,10 @@ type FiscalData struct {
 	Receipt    Receipt `json:"-" gorm:"constraint:OnDelete:CASCADE"`
 
 	BuyerAddress            string           `json:"buyerAddress"`
-	CashTotalSum            float64          `json:"cashTotalSum"`
-	CreditSum               float64          `json:"creditSum"`
+	CashTotalSum            database.Money   `json:"cashTotalSum"`
+	CreditSum               database.Money   `json:"creditSum"`
 	DateTime                DateTime         `json:"dateTime"`
-	EcashTotalSum           float64          `json:"ecashTotalSum"`
+	EcashTotalSum           database.Money   `json:"ecashTotalSum"`
 	FiscalDocumentFormatVer string           `json:"fiscalDocumentFormatVer"`
 	FiscalDocumentNumber    int64            `json:"fiscalDocumentNumber"`
 	FiscalDriveNumber       string           `json:"fiscalDriveNumber"`
@@ -31,18 +33,18 @@ type FiscalData struct {
 	Items                   []FiscalDataItem `json:"items" gorm:"constraint:OnDelete:CASCADE;foreignKey:ReceiptKey"`
 	KktRegId                string           `json:"kktRegId"`
 	MachineNumber           *string          `json:"machineNumber"`
-	Nds10                   *float64         `json:"nds10"`
-	Nds18                   *float64         `json:"nds18"`
+	Nds10                   *database.Money  `json:"nds10"`
+	Nds18                   *database.Money  `json:"nds18"`
 	OperationType           int              `json:"operationType"`
 	Operator                *string          `json:"operator"`
-	PrepaidSum              float64          `json:"prepaidSum"`
-	ProvisionSum            float64          `json:"provisionSum"`
+	PrepaidSum              database.Money   `json:"prepaidSum"`
+	ProvisionSum            database.Money   `json:"provisionSum"`
 	RequestNumber           int64            `json:"requestNumber"`
 	RetailPlace             string           `json:"retailPlace"`
 	RetailPlaceAddress      *string          `json:"retailPlaceAddress"`
 	ShiftNumber             int64            `json:"shiftNumber"`
 	TaxationType            int              `json:"taxationType"`
-	TotalSum                float64          `json:"totalSum"`
+	TotalSum                database.Money   `json:"totalSum"`
 	User                    *string          `json:"user"`
 	UserInn                 string           `json:"userInn"`
 }
@@ -1,5 +1,7 @@
 package entities
 
+import "github.com/jfk9w/hoarder/internal/database"
+
 type Brand struct {
 	Description string  `json:"description"`
 	Id          int64   `json:"id" gorm:"primaryKey;autoIncrement:false"`
@@ -14,16 +16,18 @@ type Receipt struct {
 	BrandId *int64 `json:"brandId" gorm:"index"`
 	Brand   *Brand `json:"-" gorm:"constraint:OnDelete:CASCADE"`
 
-	Buyer                string   `json:"buyer"`
-	BuyerType            string   `json:"buyerType"`
-	CreatedDate          DateTime `json:"createdDate" gorm:"index"`
-	FiscalDocumentNumber string   `json:"fiscalDocumentNumber"`
-	FiscalDriveNumber    string   `json:"fiscalDriveNumber"`
-	Key                  string   `json:"key" gorm:"primaryKey"`
-	KktOwner             string   `json:"kktOwner"`
-	KktOwnerInn          string   `json:"kktOwnerInn"`
-	ReceiveDate          DateTime `json:"receiveDate" gorm:"index"`
-	TotalSum             string   `json:"totalSum"`
+	Buyer                string         `json:"buyer"`
+	BuyerType            string         `json:"buyerType"`
+	CreatedDate          DateTime       `json:"createdDate" gorm:"index"`
+	FiscalDocumentNumber string         `json:"fiscalDocumentNumber"`
+	FiscalDriveNumber    string         `json:"fiscalDriveNumber"`
+	Key                  string         `json:"key" gorm:"primaryKey"`
+	KktOwner             string         `json:"kktOwner"`
+	KktOwnerInn          string         `json:"kktOwnerInn"`
+	ReceiveDate          DateTime       `json:"receiveDate" gorm:"index"`
+	TotalSum             database.Money `json:"totalSum"`
+
+	FireflyId *string `json:"-" gorm:"->;-:migration"`
 }
 
 func (r Receipt) TableName() string {
@@ -0,0 +1,26 @@
+package loaders
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jfk9w-go/lkdr-api"
+
+	"github.com/jfk9w/hoarder/internal/database"
+	"github.com/jfk9w/hoarder/internal/database/databasetest"
+	"github.com/jfk9w/hoarder/internal/jobs/lkdr/internal/entities"
+)
+
+func testConversion[T any](t *testing.T, source any) {
+	t.Run(reflect.TypeFor[T]().String(), func(t *testing.T) {
+		if _, err := database.CheckToViaJSON[T](source); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// TestConversions checks that API responses are converted to entities with reflection the same way as with the JSON round-trip.
+func TestConversions(t *testing.T) {
+	testConversion[receiptEntities](t, databasetest.Fixture[lkdr.ReceiptOut]())
+	testConversion[entities.FiscalData](t, databasetest.Fixture[lkdr.FiscalDataOut]())
+}
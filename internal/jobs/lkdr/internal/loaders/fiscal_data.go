@@ -7,6 +7,7 @@ import (
 	"github.com/jfk9w-go/lkdr-api"
 
 	"github.com/jfk9w/hoarder/internal/database"
+	"github.com/jfk9w/hoarder/internal/events"
 	"github.com/jfk9w/hoarder/internal/jobs"
 	"github.com/jfk9w/hoarder/internal/jobs/lkdr/internal/entities"
 	"github.com/jfk9w/hoarder/internal/logs"
@@ -15,6 +16,7 @@ import (
 type FiscalData struct {
 	Phone     string
 	BatchSize int
+	Outbox    events.Outbox
 }
 
 func (l FiscalData) TableName() string {
@@ -29,13 +31,20 @@ func (l FiscalData) Load(ctx jobs.Context, client Client, db database.DB) (_ []I
 		phone:  l.Phone,
 		client: client,
 		db:     db,
+		outbox: l.Outbox,
 	}.load)
 }
 
+type fiscalDataEvent struct {
+	ReceiptKey string               `json:"receiptKey"`
+	FiscalData *entities.FiscalData `json:"fiscalData"`
+}
+
 type fiscalDataBatch struct {
 	phone  string
 	client Client
 	db     database.DB
+	outbox events.Outbox
 }
 
 func (l fiscalDataBatch) load(ctx jobs.Context, offset, limit int) (nextOffset *int, errs error) {
@@ -89,9 +98,22 @@ func (l fiscalDataBatch) load(ctx jobs.Context, offset, limit int) (nextOffset *
 
 		entity.ReceiptKey = key
 
-		if err := l.db.WithContext(ctx).
-			Upsert(&entity).
-			Error; ctx.Error(&errs, err, "failed to update entities in db") {
+		event, err := events.New(events.ReceiptFetched, key, fiscalDataEvent{ReceiptKey: key, FiscalData: &entity})
+		if ctx.Error(&errs, err, "failed to create event") {
+			return
+		}
+
+		if errs = l.db.WithContext(ctx).Transaction(func(tx database.DB) (errs error) {
+			if err := tx.Upsert(&entity).Error; ctx.Error(&errs, err, "failed to update entities in db") {
+				return
+			}
+
+			if err := l.outbox.Write(tx, []events.Event{event}); ctx.Error(&errs, err, "failed to write event") {
+				return
+			}
+
+			return
+		}); errs != nil {
 			return
 		}
 
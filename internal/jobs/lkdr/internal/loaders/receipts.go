@@ -13,6 +13,12 @@ import (
 	"github.com/jfk9w/hoarder/internal/logs"
 )
 
+// receiptEntities holds entities converted from a receipts page.
+type receiptEntities struct {
+	Brands   []entities.Brand   `json:"brands"`
+	Receipts []entities.Receipt `json:"receipts"`
+}
+
 type Receipts struct {
 	Phone     string
 	BatchSize int
@@ -78,12 +84,7 @@ func (l receiptsBatch) load(ctx jobs.Context, offset int, limit int) (nextOffset
 		return
 	}
 
-	type Entities struct {
-		Brands   []entities.Brand   `json:"brands"`
-		Receipts []entities.Receipt `json:"receipts"`
-	}
-
-	entities, err := database.ToViaJSON[Entities](out)
+	entities, err := database.ToViaJSON[receiptEntities](out)
 	if ctx.Error(&errs, err, "entity conversion failed") {
 		return
 	}
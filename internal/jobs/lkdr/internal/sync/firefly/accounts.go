@@ -0,0 +1,71 @@
+package firefly
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/jfk9w/hoarder/internal/firefly"
+)
+
+func ensureAccount(ctx context.Context, client firefly.Invoker, name string, role firefly.AccountRoleProperty) (string, error) {
+	id, err := findAccount(ctx, client, name)
+	if err != nil {
+		return "", errors.Wrap(err, "find account")
+	}
+
+	if id != "" {
+		return id, nil
+	}
+
+	in := &firefly.AccountStore{
+		Name:         name,
+		Type:         firefly.ShortAccountTypePropertyAsset,
+		AccountRole:  firefly.NewOptNilAccountRoleProperty(role),
+		CurrencyCode: firefly.NewOptString(currencyCode),
+		Active:       firefly.NewOptBool(true),
+	}
+
+	out, err := client.StoreAccount(ctx, in, firefly.StoreAccountParams{})
+	if err != nil {
+		return "", err
+	}
+
+	switch out := out.(type) {
+	case *firefly.AccountSingle:
+		return out.Data.ID, nil
+	case firefly.Exception:
+		return "", firefly.ExceptionError(out)
+	default:
+		return "", errors.Errorf("%s", out)
+	}
+}
+
+func findAccount(ctx context.Context, client firefly.Invoker, name string) (string, error) {
+	out, err := client.SearchAccounts(ctx, firefly.SearchAccountsParams{
+		Query: name,
+		Type:  firefly.NewOptAccountTypeFilter(firefly.AccountTypeFilterAsset),
+		Field: firefly.AccountSearchFieldFilterName,
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	switch out := out.(type) {
+	case *firefly.AccountArray:
+		for _, account := range out.Data {
+			if account.Attributes.Name == name {
+				return account.ID, nil
+			}
+		}
+
+		return "", nil
+	case *firefly.NotFound:
+		return "", nil
+	case firefly.Exception:
+		return "", firefly.ExceptionError(out)
+	default:
+		return "", errors.Errorf("%s", out)
+	}
+}
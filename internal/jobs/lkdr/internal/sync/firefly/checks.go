@@ -0,0 +1,40 @@
+package firefly
+
+import (
+	"gorm.io/gorm"
+
+	"github.com/jfk9w/hoarder/internal/database"
+	. "github.com/jfk9w/hoarder/internal/jobs/lkdr/internal/entities"
+)
+
+// Checks returns consistency checks of Firefly ids stored in the database.
+func Checks() []database.Check {
+	receipts := new(Receipt).TableName()
+	return []database.Check{
+		database.QueryCheck("empty-firefly-ids", "firefly_mappings with empty firefly ids",
+			func(tx database.DB) *gorm.DB {
+				return tx.Model(new(FireflyMapping)).Where("firefly_id = ''")
+			},
+			func(query *gorm.DB) *gorm.DB {
+				return query.Delete(new(FireflyMapping))
+			}),
+		database.QueryCheck("stale-firefly-ids", "firefly_mappings for absent "+receipts,
+			func(tx database.DB) *gorm.DB {
+				return tx.Model(new(FireflyMapping)).
+					Where("entity = ?", receipts).
+					Where("not exists (select 1 from " + receipts + " where " + receipts + ".key = firefly_mappings.entity_key)")
+			},
+			func(query *gorm.DB) *gorm.DB {
+				return query.Delete(new(FireflyMapping))
+			}),
+		database.QueryCheck("shared-firefly-ids", "receipts sharing firefly ids",
+			func(tx database.DB) *gorm.DB {
+				return tx.Model(new(FireflyMapping)).
+					Where("entity = ?", receipts).
+					Where("exists (select 1 from firefly_mappings as fm2 where fm2.instance = firefly_mappings.instance and " +
+						"fm2.entity = firefly_mappings.entity and fm2.firefly_id = firefly_mappings.firefly_id and " +
+						"fm2.entity_key <> firefly_mappings.entity_key)")
+			},
+			nil),
+	}
+}
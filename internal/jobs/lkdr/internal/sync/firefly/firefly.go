@@ -0,0 +1,14 @@
+package firefly
+
+import (
+	"gorm.io/gorm/schema"
+
+	"github.com/jfk9w/hoarder/internal/database"
+	"github.com/jfk9w/hoarder/internal/firefly"
+	"github.com/jfk9w/hoarder/internal/jobs"
+)
+
+type Interface interface {
+	schema.Tabler
+	Sync(ctx jobs.Context, db database.DB, client firefly.Instance) ([]Interface, error)
+}
@@ -0,0 +1,22 @@
+package firefly
+
+import (
+	"github.com/jfk9w/hoarder/internal/database"
+	"github.com/jfk9w/hoarder/internal/jobs"
+	. "github.com/jfk9w/hoarder/internal/jobs/lkdr/internal/entities"
+)
+
+// Reset deletes Firefly ids mappings in the instance for receipts belonging to the users, so that they are matched or stored again on the next sync.
+// Receipts are matched with transactions of other jobs, so it should be called when Firefly ids of those are reset.
+func Reset(ctx jobs.Context, db database.DB, instance string, phones []string) (errs error) {
+	ctx = ctx.With("entity", new(Receipt).TableName())
+	result := db.WithContext(ctx).
+		Where("instance = ? and entity = ?", instance, new(Receipt).TableName()).
+		Where("entity_key in (?)", db.Model(new(Receipt)).Select("key").Where("user_phone in ?", phones)).
+		Delete(new(FireflyMapping))
+	if !ctx.Error(&errs, result.Error, "failed to reset firefly ids") {
+		ctx.Info("reset firefly ids", "count", result.RowsAffected)
+	}
+
+	return
+}
@@ -0,0 +1,49 @@
+package firefly
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+
+	"github.com/jfk9w/hoarder/internal/database"
+	. "github.com/jfk9w/hoarder/internal/jobs/lkdr/internal/entities"
+)
+
+// mappings reads and writes Firefly ids of records in a Firefly III instance.
+// Entities are named after the tables of the records.
+type mappings struct {
+	db       database.DB
+	instance string
+}
+
+// mapped returns a scope selecting records of the table along with their Firefly ids.
+// key is the column of the table which identifies a record.
+func (m mappings) mapped(table, key string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Select(table+".*, fm.firefly_id as firefly_id").
+			Joins("left join "+new(FireflyMapping).TableName()+" as fm on "+
+				"fm.instance = ? and fm.entity = ? and fm.entity_key = "+table+"."+key,
+				m.instance, table)
+	}
+}
+
+// claimed returns Firefly ids of the entity records among ids.
+func (m mappings) claimed(ctx context.Context, entity string, ids []string) ([]string, error) {
+	var claimed []string
+	return claimed, m.db.WithContext(ctx).
+		Model(new(FireflyMapping)).
+		Where("instance = ? and entity = ? and firefly_id in ?", m.instance, entity, ids).
+		Pluck("firefly_id", &claimed).
+		Error
+}
+
+func (m mappings) save(ctx context.Context, entity, key, fireflyId string) error {
+	return m.db.WithContext(ctx).
+		Upsert(&FireflyMapping{
+			Instance:  m.instance,
+			Entity:    entity,
+			Key:       key,
+			FireflyId: fireflyId,
+		}).
+		Error
+}
@@ -0,0 +1,369 @@
+package firefly
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/AlekSi/pointer"
+	"github.com/pkg/errors"
+
+	"github.com/jfk9w/hoarder/internal/database"
+	"github.com/jfk9w/hoarder/internal/firefly"
+	"github.com/jfk9w/hoarder/internal/jobs"
+	. "github.com/jfk9w/hoarder/internal/jobs/lkdr/internal/entities"
+)
+
+const (
+	currencyCode = "RUB"
+
+	// operationTypeRefund is the fiscal document operation type for "возврат прихода".
+	operationTypeRefund = 2
+
+	// externalIdPrefix is prepended to receipt keys in external ids of Firefly III transactions.
+	externalIdPrefix = "lkdr:receipts:"
+)
+
+type Receipts struct {
+	Phone          string
+	BatchSize      int
+	CashAccount    string
+	UnknownAccount string
+	ItemSplits     bool
+	MatchWindow    time.Duration
+}
+
+func (s Receipts) TableName() string {
+	return new(Receipt).TableName()
+}
+
+func (s Receipts) Sync(ctx jobs.Context, db database.DB, client firefly.Instance) (_ []Interface, errs error) {
+	ctx = ctx.With("phone", s.Phone)
+
+	cashAccountId, err := ensureAccount(ctx, client, s.CashAccount, firefly.AccountRolePropertyCashWalletAsset)
+	if ctx.Error(&errs, err, "failed to ensure cash account") {
+		return
+	}
+
+	unknownAccountId, err := ensureAccount(ctx, client, s.UnknownAccount, firefly.AccountRolePropertyDefaultAsset)
+	if ctx.Error(&errs, err, "failed to ensure unknown account") {
+		return
+	}
+
+	return nil, jobs.Batch[string]{
+		Key:  "after",
+		Size: s.BatchSize,
+	}.Run(ctx, receiptsBatch{
+		Receipts:         s,
+		db:               db,
+		client:           client,
+		mappings:         mappings{db: db, instance: client.ID},
+		cashAccountId:    cashAccountId,
+		unknownAccountId: unknownAccountId,
+	}.sync)
+}
+
+type receiptsBatch struct {
+	Receipts
+	db               database.DB
+	client           firefly.Invoker
+	mappings         mappings
+	cashAccountId    string
+	unknownAccountId string
+}
+
+func (s receiptsBatch) sync(ctx jobs.Context, after string, limit int) (nextAfter *string, errs error) {
+	var receipts []Receipt
+	if err := s.db.WithContext(ctx).
+		Scopes(s.mappings.mapped(s.TableName(), "key")).
+		Where("receipts.user_phone = ? and fm.firefly_id is null and receipts.key > ?", s.Phone, after).
+		Order("receipts.key").
+		Limit(limit).
+		Find(&receipts).
+		Error; ctx.Error(&errs, err, "failed to select pending records") {
+		return
+	}
+
+	if len(receipts) == 0 {
+		return
+	}
+
+	keys := make([]string, len(receipts))
+	for i, receipt := range receipts {
+		keys[i] = receipt.Key
+	}
+
+	var fiscalData []FiscalData
+	if err := s.db.WithContext(ctx).
+		Preload("Items").
+		Where("receipt_key in ?", keys).
+		Find(&fiscalData).
+		Error; ctx.Error(&errs, err, "failed to select fiscal data") {
+		return
+	}
+
+	fiscalDataByKey := make(map[string]*FiscalData, len(fiscalData))
+	for i := range fiscalData {
+		fiscalDataByKey[fiscalData[i].ReceiptKey] = &fiscalData[i]
+	}
+
+	for _, receipt := range receipts {
+		ctx := ctx.With("key", receipt.Key)
+		fireflyId, err := s.syncReceipt(ctx, receipt, fiscalDataByKey[receipt.Key])
+		if ctx.Error(&errs, err, "failed to sync receipt") || fireflyId == "" {
+			continue
+		}
+
+		if err := s.mappings.save(ctx, s.TableName(), receipt.Key, fireflyId); ctx.Error(&errs, err, "failed to update firefly id in db") {
+			continue
+		}
+	}
+
+	if len(receipts) == limit {
+		nextAfter = pointer.To(receipts[len(receipts)-1].Key)
+	}
+
+	return
+}
+
+func (s receiptsBatch) syncReceipt(ctx jobs.Context, receipt Receipt, fiscalData *FiscalData) (string, error) {
+	amount := receipt.TotalSum
+	date := receipt.CreatedDate.Time()
+	counterparty := receipt.KktOwner
+	cash := false
+	refund := false
+	var items []FiscalDataItem
+	if fiscalData != nil {
+		amount = fiscalData.TotalSum
+		date = fiscalData.DateTime.Time()
+		if name := strings.TrimSpace(fiscalData.RetailPlace); name != "" {
+			counterparty = name
+		} else if name := strings.TrimSpace(pointer.Get(fiscalData.User)); name != "" {
+			counterparty = name
+		}
+
+		cash = fiscalData.EcashTotalSum == 0 && fiscalData.CashTotalSum > 0
+		refund = fiscalData.OperationType == operationTypeRefund
+		items = fiscalData.Items
+	}
+
+	if amount <= 0 {
+		ctx.Debug("skipping receipt with empty amount")
+		return "", nil
+	}
+
+	notes := getReceiptNotes(items)
+	if !cash && !refund {
+		match, err := s.findMatch(ctx, amount, date)
+		if err != nil {
+			return "", errors.Wrap(err, "find matching transaction")
+		}
+
+		if match != nil {
+			ctx.Debug("found matching transaction", "firefly_id", match.ID)
+			if err := attachNotes(ctx, s.client, match, notes); err != nil {
+				return "", errors.Wrap(err, "attach notes")
+			}
+
+			return match.ID, nil
+		}
+	}
+
+	externalId := externalIdPrefix + receipt.Key
+	existing, err := findTransaction(ctx, s.client, externalId)
+	if err != nil {
+		return "", errors.Wrap(err, "find transaction")
+	}
+
+	if existing != "" {
+		ctx.Debug("found stored transaction", "firefly_id", existing)
+		return existing, nil
+	}
+
+	accountId := s.unknownAccountId
+	if cash {
+		accountId = s.cashAccountId
+	}
+
+	in := &firefly.TransactionStore{
+		GroupTitle: firefly.NewOptNilString(counterparty),
+	}
+
+	newSplit := func(description string, amount database.Money) firefly.TransactionSplitStore {
+		split := firefly.TransactionSplitStore{
+			Type:         firefly.TransactionTypePropertyWithdrawal,
+			Date:         date,
+			Amount:       amount.String(),
+			Description:  description,
+			CurrencyCode: firefly.NewOptNilString(currencyCode),
+			ExternalID:   firefly.NewOptNilString(externalId),
+		}
+
+		if refund {
+			split.Type = firefly.TransactionTypePropertyDeposit
+			split.SourceName = firefly.NewOptNilString(counterparty)
+			split.DestinationID = firefly.NewOptNilString(accountId)
+		} else {
+			split.SourceID = firefly.NewOptNilString(accountId)
+			split.DestinationName = firefly.NewOptNilString(counterparty)
+		}
+
+		return split
+	}
+
+	if s.ItemSplits && len(items) > 1 && sumItems(items) == amount {
+		for _, item := range items {
+			in.Transactions = append(in.Transactions, newSplit(item.Name, item.Sum))
+		}
+	} else {
+		split := newSplit(counterparty, amount)
+		if notes != "" {
+			split.Notes = firefly.NewOptNilString(notes)
+		}
+
+		in.Transactions = append(in.Transactions, split)
+	}
+
+	out, err := s.client.StoreTransaction(ctx, in, firefly.StoreTransactionParams{})
+	if err != nil {
+		return "", err
+	}
+
+	switch out := out.(type) {
+	case *firefly.TransactionSingle:
+		return out.Data.ID, nil
+	case firefly.Exception:
+		return "", firefly.ExceptionError(out)
+	default:
+		return "", errors.Errorf("%s", out)
+	}
+}
+
+func (s receiptsBatch) findMatch(ctx context.Context, amount database.Money, date time.Time) (*firefly.TransactionRead, error) {
+	const dateLayout = "2006-01-02"
+	query := fmt.Sprintf("type:withdrawal amount:%s date_after:%s date_before:%s",
+		amount, date.Add(-s.MatchWindow).Format(dateLayout), date.Add(s.MatchWindow).Format(dateLayout))
+
+	out, err := s.client.SearchTransactions(ctx, firefly.SearchTransactionsParams{Query: query})
+	if err != nil {
+		return nil, err
+	}
+
+	switch out := out.(type) {
+	case *firefly.TransactionArray:
+		ids := make([]string, len(out.Data))
+		for i, transaction := range out.Data {
+			ids[i] = transaction.ID
+		}
+
+		// transactions already matched with other receipts in the instance
+		claimed, err := s.mappings.claimed(ctx, s.TableName(), ids)
+		if err != nil {
+			return nil, errors.Wrap(err, "select matched transactions")
+		}
+
+		for i := range out.Data {
+			transaction := &out.Data[i]
+			splits := transaction.Attributes.Transactions
+			if len(splits) != 1 || slices.Contains(claimed, transaction.ID) {
+				continue
+			}
+
+			if source := splits[0].SourceID; !source.Null && (source.Value == s.cashAccountId || source.Value == s.unknownAccountId) {
+				continue
+			}
+
+			if strings.HasPrefix(splits[0].ExternalID.Or(""), externalIdPrefix) {
+				// stored for another receipt
+				continue
+			}
+
+			return transaction, nil
+		}
+
+		return nil, nil
+	case *firefly.NotFound:
+		return nil, nil
+	case firefly.Exception:
+		return nil, firefly.ExceptionError(out)
+	default:
+		return nil, errors.Errorf("%s", out)
+	}
+}
+
+// findTransaction searches for the transaction by external id and returns an empty string if it does not exist.
+func findTransaction(ctx context.Context, client firefly.Invoker, externalId string) (string, error) {
+	out, err := client.SearchTransactions(ctx, firefly.SearchTransactionsParams{
+		Query: fmt.Sprintf("external_id_is:%q", externalId),
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	switch out := out.(type) {
+	case *firefly.TransactionArray:
+		for _, transaction := range out.Data {
+			for _, split := range transaction.Attributes.Transactions {
+				if split.ExternalID.Or("") == externalId {
+					return transaction.ID, nil
+				}
+			}
+		}
+
+		return "", nil
+	case *firefly.NotFound:
+		return "", nil
+	case firefly.Exception:
+		return "", firefly.ExceptionError(out)
+	default:
+		return "", errors.Errorf("%s", out)
+	}
+}
+
+func attachNotes(ctx context.Context, client firefly.Invoker, transaction *firefly.TransactionRead, notes string) error {
+	split := transaction.Attributes.Transactions[0]
+	if notes == "" || split.Notes.Value != "" {
+		return nil
+	}
+
+	in := &firefly.TransactionUpdate{
+		Transactions: []firefly.TransactionSplitUpdate{{
+			TransactionJournalID: split.TransactionJournalID,
+			Notes:                firefly.NewOptNilString(notes),
+		}},
+	}
+
+	out, err := client.UpdateTransaction(ctx, in, firefly.UpdateTransactionParams{ID: transaction.ID})
+	if err != nil {
+		return err
+	}
+
+	switch out := out.(type) {
+	case *firefly.TransactionSingle:
+		return nil
+	case firefly.Exception:
+		return firefly.ExceptionError(out)
+	default:
+		return errors.Errorf("%s", out)
+	}
+}
+
+func getReceiptNotes(items []FiscalDataItem) string {
+	var b strings.Builder
+	for _, item := range items {
+		b.WriteString(fmt.Sprintf("- %s: %v × %s = %s\n", item.Name, item.Quantity, item.Price, item.Sum))
+	}
+
+	return b.String()
+}
+
+func sumItems(items []FiscalDataItem) (sum database.Money) {
+	for _, item := range items {
+		sum += item.Sum
+	}
+
+	return
+}
@@ -0,0 +1,198 @@
+package firefly
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jfk9w-go/based"
+	lkdr "github.com/jfk9w-go/lkdr-api"
+	"gorm.io/gorm/clause"
+
+	"github.com/jfk9w/hoarder/internal/database"
+	"github.com/jfk9w/hoarder/internal/firefly"
+	"github.com/jfk9w/hoarder/internal/jobs"
+	. "github.com/jfk9w/hoarder/internal/jobs/lkdr/internal/entities"
+)
+
+// fakeInvoker serves a single bank withdrawal with the id of the transaction and stores transactions in memory.
+// Methods which are not overridden panic.
+type fakeInvoker struct {
+	firefly.Invoker
+
+	withdrawal firefly.TransactionRead
+
+	mu     sync.Mutex
+	stored []firefly.TransactionSplitStore
+}
+
+func newFakeInvoker(id string, amount database.Money) *fakeInvoker {
+	return &fakeInvoker{
+		withdrawal: firefly.TransactionRead{
+			ID: id,
+			Attributes: firefly.Transaction{
+				Transactions: []firefly.TransactionSplit{{
+					Type:     firefly.TransactionTypePropertyWithdrawal,
+					Amount:   amount.String(),
+					SourceID: firefly.NilString{Value: "bank"},
+				}},
+			},
+		},
+	}
+}
+
+func (f *fakeInvoker) SearchAccounts(_ context.Context, params firefly.SearchAccountsParams) (firefly.SearchAccountsRes, error) {
+	return &firefly.AccountArray{Data: []firefly.AccountRead{{ID: params.Query, Attributes: firefly.Account{Name: params.Query}}}}, nil
+}
+
+func (f *fakeInvoker) SearchTransactions(_ context.Context, params firefly.SearchTransactionsParams) (firefly.SearchTransactionsRes, error) {
+	if strings.HasPrefix(params.Query, "external_id_is:") {
+		return new(firefly.TransactionArray), nil
+	}
+
+	return &firefly.TransactionArray{Data: []firefly.TransactionRead{f.withdrawal}}, nil
+}
+
+func (f *fakeInvoker) StoreTransaction(_ context.Context, in *firefly.TransactionStore, _ firefly.StoreTransactionParams) (firefly.StoreTransactionRes, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stored = append(f.stored, in.Transactions...)
+	return &firefly.TransactionSingle{Data: firefly.TransactionRead{ID: fmt.Sprintf("stored-%d", len(f.stored))}}, nil
+}
+
+func newReceiptsTest(t *testing.T) (jobs.Context, database.DB) {
+	ctx := context.Background()
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", strings.ReplaceAll(t.Name(), "/", "_"))
+	db, err := database.Open(ctx, database.Params{
+		Clock:  based.StandardClock,
+		Logger: slog.Default(),
+		Config: database.Config{Driver: "sqlite", DSN: dsn},
+		Name:   "lkdr",
+		Migrations: []database.Migration{
+			{
+				Version:     1,
+				Description: "create tables",
+				Up: database.AutoMigrate(new(User), new(Brand), new(Receipt), new(FiscalData), new(FiscalDataItem),
+					new(FireflyMapping)),
+			},
+		},
+	})
+
+	if err != nil && strings.Contains(err.Error(), "cgo") {
+		t.Skipf("sqlite is not available: %v", err)
+	}
+
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB.DB(); err == nil {
+			_ = sqlDB.Close()
+		}
+	})
+
+	return jobs.NewContext(ctx, slog.Default()), db
+}
+
+func createReceipt(t *testing.T, db database.DB, phone, key string, amount database.Money, at time.Time) {
+	t.Helper()
+	for _, value := range []any{
+		&User{Phone: phone},
+		&Receipt{UserPhone: phone, Key: key, TotalSum: amount, CreatedDate: DateTime{DateTime: lkdr.DateTime(at)}},
+	} {
+		if err := db.Clauses(clause.OnConflict{DoNothing: true}).Omit(clause.Associations).Create(value).Error; err != nil {
+			t.Fatalf("create %T: %v", value, err)
+		}
+	}
+}
+
+func syncReceipts(t *testing.T, ctx jobs.Context, db database.DB, client firefly.Instance, phone string) {
+	t.Helper()
+	s := Receipts{
+		Phone:          phone,
+		BatchSize:      10,
+		CashAccount:    "cash",
+		UnknownAccount: "unknown",
+		MatchWindow:    24 * time.Hour,
+	}
+
+	if _, err := s.Sync(ctx, db, client); err != nil {
+		t.Fatalf("sync %s: %v", phone, err)
+	}
+}
+
+func receiptFireflyId(t *testing.T, db database.DB, instance, key string) string {
+	t.Helper()
+	var receipt Receipt
+	if err := db.
+		Scopes(mappings{db: db, instance: instance}.mapped(receipt.TableName(), "key")).
+		Where("receipts.key = ?", key).
+		Take(&receipt).
+		Error; err != nil {
+		t.Fatalf("select receipt %s: %v", key, err)
+	}
+
+	if receipt.FireflyId == nil {
+		return ""
+	}
+
+	return *receipt.FireflyId
+}
+
+func TestReceipts_MatchOverlappingIds(t *testing.T) {
+	ctx, db := newReceiptsTest(t)
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	amount := database.MoneyFromFloat(100)
+
+	// Firefly ids are assigned by each instance, so different transactions in different instances share them
+	first := firefly.Instance{Invoker: newFakeInvoker("1", amount), ID: "first", Concurrency: 1}
+	second := firefly.Instance{Invoker: newFakeInvoker("1", amount), ID: "second", Concurrency: 1}
+
+	createReceipt(t, db, "+71", "a", amount, at)
+	createReceipt(t, db, "+72", "b", amount, at)
+
+	syncReceipts(t, ctx, db, first, "+71")
+	syncReceipts(t, ctx, db, second, "+72")
+
+	if id := receiptFireflyId(t, db, "first", "a"); id != "1" {
+		t.Errorf("expected receipt a to be matched in the first instance, got %q", id)
+	}
+
+	if id := receiptFireflyId(t, db, "second", "b"); id != "1" {
+		t.Errorf("expected receipt b to be matched in the second instance, got %q", id)
+	}
+
+	if id := receiptFireflyId(t, db, "second", "a"); id != "" {
+		t.Errorf("expected receipt a not to be mapped in the second instance, got %q", id)
+	}
+}
+
+func TestReceipts_SkipClaimedTransaction(t *testing.T) {
+	ctx, db := newReceiptsTest(t)
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	amount := database.MoneyFromFloat(100)
+	invoker := newFakeInvoker("1", amount)
+	client := firefly.Instance{Invoker: invoker, ID: "first", Concurrency: 1}
+
+	createReceipt(t, db, "+71", "a", amount, at)
+	syncReceipts(t, ctx, db, client, "+71")
+	createReceipt(t, db, "+71", "b", amount, at)
+	syncReceipts(t, ctx, db, client, "+71")
+
+	if id := receiptFireflyId(t, db, "first", "a"); id != "1" {
+		t.Errorf("expected receipt a to be matched, got %q", id)
+	}
+
+	if len(invoker.stored) != 1 || invoker.stored[0].ExternalID.Value != externalIdPrefix+"b" {
+		t.Fatalf("expected receipt b to be stored, got %v", invoker.stored)
+	}
+
+	if id := receiptFireflyId(t, db, "first", "b"); id != "stored-1" {
+		t.Errorf("expected receipt b to be mapped to the stored transaction, got %q", id)
+	}
+}
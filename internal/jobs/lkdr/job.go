@@ -15,9 +15,12 @@ import (
 	"github.com/jfk9w/hoarder/internal/captcha"
 	"github.com/jfk9w/hoarder/internal/common"
 	"github.com/jfk9w/hoarder/internal/database"
+	"github.com/jfk9w/hoarder/internal/events"
+	"github.com/jfk9w/hoarder/internal/firefly"
 	"github.com/jfk9w/hoarder/internal/jobs"
 	. "github.com/jfk9w/hoarder/internal/jobs/lkdr/internal/entities"
 	"github.com/jfk9w/hoarder/internal/jobs/lkdr/internal/loaders"
+	fireflySync "github.com/jfk9w/hoarder/internal/jobs/lkdr/internal/sync/firefly"
 	"github.com/jfk9w/hoarder/internal/logs"
 )
 
@@ -29,13 +32,18 @@ type JobParams struct {
 	Logger        *slog.Logger `validate:"required"`
 	ClientFactory ClientFactory
 	CaptchaSolver captcha.TokenProvider
+	Firefly       *firefly.Instances
+	Events        *events.Dispatcher
 }
 
 type Job struct {
 	users         map[string]map[string]Client
 	batchSize     int
+	outbox        events.Outbox
 	captchaSolver captcha.TokenProvider
 	db            database.DB
+	firefly       *firefly.Instances
+	fireflyConfig FireflyConfig
 }
 
 func NewJob(ctx context.Context, params JobParams) (*Job, error) {
@@ -47,18 +55,32 @@ func NewJob(ctx context.Context, params JobParams) (*Job, error) {
 		params.ClientFactory = defaultClientFactory
 	}
 
+	cipher, err := database.NewCipher(params.Config.Database.Encryption)
+	if err != nil {
+		return nil, errors.Wrap(err, "create cipher")
+	}
+
 	db, err := database.Open(ctx, database.Params{
-		Clock:    params.Clock,
-		Logger:   params.Logger.With(logs.Database(JobID)),
-		Config:   params.Config.Database,
-		Entities: entities,
+		Clock:      params.Clock,
+		Logger:     params.Logger.With(logs.Database(JobID)),
+		Config:     params.Config.Database,
+		Name:       JobID,
+		Migrations: getMigrations(cipher),
 	})
 
 	if err != nil {
 		return nil, err
 	}
 
-	storage := &storage{db: db}
+	if params.Events != nil {
+		params.Events.Register(JobID, db)
+	}
+
+	storage := &storage{db: db, cipher: cipher}
+	if err := storage.encryptSecrets(); err != nil {
+		return nil, errors.Wrap(err, "encrypt secrets")
+	}
+
 	users := make(map[string]map[string]Client)
 	for user, credentials := range params.Config.Users {
 		phones := make(map[string]Client)
@@ -95,8 +117,11 @@ func NewJob(ctx context.Context, params JobParams) (*Job, error) {
 	return &Job{
 		users:         users,
 		batchSize:     params.Config.BatchSize,
+		outbox:        params.Events.Outbox(),
 		captchaSolver: params.CaptchaSolver,
 		db:            db,
+		firefly:       params.Firefly,
+		fireflyConfig: params.Config.Firefly,
 	}, nil
 }
 
@@ -120,6 +145,10 @@ func (j *Job) Run(ctx jobs.Context, _ time.Time, userID string) (errs error) {
 		_ = multierr.AppendInto(&errs, err)
 	}
 
+	if err := j.executeFireflySync(ctx, userID); err != nil {
+		_ = multierr.AppendInto(&errs, err)
+	}
+
 	return
 }
 
@@ -133,7 +162,7 @@ func (j *Job) executeLoaders(ctx jobs.Context, userID, phone string, client Clie
 	var stack common.Stack[loaders.Interface]
 	stack.Push(
 		loaders.Receipts{Phone: phone, BatchSize: j.batchSize},
-		loaders.FiscalData{Phone: phone, BatchSize: j.batchSize},
+		loaders.FiscalData{Phone: phone, BatchSize: j.batchSize, Outbox: j.outbox},
 	)
 
 	for {
@@ -152,6 +181,60 @@ func (j *Job) executeLoaders(ctx jobs.Context, userID, phone string, client Clie
 	return
 }
 
+// ResetFirefly clears Firefly III ids of receipts of the user in their instance, so that they are matched with transactions again
+// after Firefly III ids of T-Bank data are reset.
+func (j *Job) ResetFirefly(ctx jobs.Context, userID string) error {
+	client, ok := j.firefly.Get(userID)
+	if !ok {
+		return nil
+	}
+
+	phones := make([]string, 0, len(j.users[userID]))
+	for phone := range j.users[userID] {
+		phones = append(phones, phone)
+	}
+
+	if len(phones) == 0 {
+		return nil
+	}
+
+	return fireflySync.Reset(ctx, j.db, client.ID, phones)
+}
+
+func (j *Job) executeFireflySync(ctx jobs.Context, userID string) (errs error) {
+	client, ok := j.firefly.Get(userID)
+	if !ok {
+		return
+	}
+
+	var stack common.Stack[fireflySync.Interface]
+	for phone := range j.users[userID] {
+		stack.Push(fireflySync.Receipts{
+			Phone:          phone,
+			BatchSize:      j.batchSize,
+			CashAccount:    j.fireflyConfig.CashAccount,
+			UnknownAccount: j.fireflyConfig.UnknownAccount,
+			ItemSplits:     j.fireflyConfig.ItemSplits,
+			MatchWindow:    j.fireflyConfig.MatchWindow,
+		})
+	}
+
+	for {
+		sync, ok := stack.Pop()
+		if !ok {
+			break
+		}
+
+		ctx := ctx.With("entity", sync.TableName())
+		syncs, err := sync.Sync(ctx, j.db, client)
+		if !multierr.AppendInto(&errs, err) {
+			stack.Push(syncs...)
+		}
+	}
+
+	return
+}
+
 func generateDeviceID(userAgent, phone string) (string, error) {
 	hash := fnv.New64()
 	if _, err := hash.Write([]byte(userAgent)); err != nil {
@@ -0,0 +1,57 @@
+package lkdr
+
+import (
+	"github.com/pkg/errors"
+	"gorm.io/gorm/clause"
+
+	"github.com/jfk9w/hoarder/internal/database"
+	"github.com/jfk9w/hoarder/internal/firefly"
+	. "github.com/jfk9w/hoarder/internal/jobs/lkdr/internal/entities"
+)
+
+// legacyReceiptFireflyIdIndex is the index of the column which stored Firefly ids of the default instance in receipts.
+const legacyReceiptFireflyIdIndex = "idx_receipts_firefly_id"
+
+// migrateReceiptFireflyIds moves Firefly ids from receipts to mappings of the default instance.
+func migrateReceiptFireflyIds(db database.DB) error {
+	migrator := db.Migrator()
+	if !migrator.HasColumn(new(Receipt), "firefly_id") {
+		return nil
+	}
+
+	var mappings []FireflyMapping
+	if err := db.
+		Table(new(Receipt).TableName()).
+		Select("receipts.key as entity_key, firefly_id").
+		Where("firefly_id is not null").
+		Find(&mappings).
+		Error; err != nil {
+		return errors.Wrap(err, "select firefly ids")
+	}
+
+	for i := range mappings {
+		mappings[i].Instance = firefly.DefaultInstance
+		mappings[i].Entity = new(Receipt).TableName()
+	}
+
+	if len(mappings) > 0 {
+		if err := db.
+			Clauses(clause.OnConflict{DoNothing: true}).
+			CreateInBatches(&mappings, 1000).
+			Error; err != nil {
+			return errors.Wrap(err, "save firefly mappings")
+		}
+	}
+
+	if migrator.HasIndex(new(Receipt), legacyReceiptFireflyIdIndex) {
+		if err := migrator.DropIndex(new(Receipt), legacyReceiptFireflyIdIndex); err != nil {
+			return errors.Wrap(err, "drop index")
+		}
+	}
+
+	if err := migrator.DropColumn(new(Receipt), "firefly_id"); err != nil {
+		return errors.Wrap(err, "drop firefly id")
+	}
+
+	return nil
+}
@@ -12,7 +12,8 @@ import (
 )
 
 type storage struct {
-	db database.DB
+	db     database.DB
+	cipher *database.Cipher
 }
 
 func (s *storage) LoadTokens(ctx context.Context, phone string) (*lkdr.Tokens, error) {
@@ -25,6 +26,10 @@ func (s *storage) LoadTokens(ctx context.Context, phone string) (*lkdr.Tokens, e
 		return nil, errors.Wrap(err, "get tokens from db")
 	}
 
+	if err := s.decrypt(&entity); err != nil {
+		return nil, errors.Wrap(err, "decrypt tokens")
+	}
+
 	return database.ToViaJSON[*lkdr.Tokens](entity)
 }
 
@@ -39,6 +44,9 @@ func (s *storage) UpdateTokens(ctx context.Context, phone string, tokens *lkdr.T
 	}
 
 	entity.UserPhone = phone
+	if err := s.encrypt(entity); err != nil {
+		return errors.Wrap(err, "encrypt tokens")
+	}
 
 	if err := s.db.Upsert(entity).Error; err != nil {
 		return errors.Wrap(err, "save tokens in db")
@@ -46,3 +54,61 @@ func (s *storage) UpdateTokens(ctx context.Context, phone string, tokens *lkdr.T
 
 	return nil
 }
+
+// encryptSecrets encrypts stored tokens with the current key.
+// Tokens stored in plain text or encrypted with other keys are re-encrypted.
+func (s *storage) encryptSecrets() error {
+	var tokens []Tokens
+	if err := s.db.Find(&tokens).Error; err != nil {
+		return errors.Wrap(err, "select tokens")
+	}
+
+	for _, entity := range tokens {
+		if s.cipher.Current(entity.Token) && s.cipher.Current(entity.RefreshToken) {
+			continue
+		}
+
+		if err := s.decrypt(&entity); err != nil {
+			return errors.Wrapf(err, "decrypt tokens for %s", entity.UserPhone)
+		}
+
+		if err := s.encrypt(&entity); err != nil {
+			return errors.Wrapf(err, "encrypt tokens for %s", entity.UserPhone)
+		}
+
+		if err := s.db.
+			Model(new(Tokens)).
+			Where("user_phone = ?", entity.UserPhone).
+			Updates(map[string]any{
+				"token":         entity.Token,
+				"refresh_token": entity.RefreshToken,
+			}).
+			Error; err != nil {
+			return errors.Wrapf(err, "update tokens for %s", entity.UserPhone)
+		}
+	}
+
+	return nil
+}
+
+func (s *storage) encrypt(entity *Tokens) (err error) {
+	if entity.Token, err = s.cipher.Encrypt(entity.Token, tokenAssociatedData(entity.UserPhone, "token")); err != nil {
+		return err
+	}
+
+	entity.RefreshToken, err = s.cipher.Encrypt(entity.RefreshToken, tokenAssociatedData(entity.UserPhone, "refresh_token"))
+	return
+}
+
+func (s *storage) decrypt(entity *Tokens) (err error) {
+	if entity.Token, err = s.cipher.Decrypt(entity.Token, tokenAssociatedData(entity.UserPhone, "token")); err != nil {
+		return err
+	}
+
+	entity.RefreshToken, err = s.cipher.Decrypt(entity.RefreshToken, tokenAssociatedData(entity.UserPhone, "refresh_token"))
+	return
+}
+
+func tokenAssociatedData(phone, column string) string {
+	return "tokens." + column + ":" + phone
+}
@@ -0,0 +1,157 @@
+package tbank
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/jfk9w-go/based"
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
+	"gorm.io/gorm"
+
+	"github.com/jfk9w/hoarder/internal/database"
+	"github.com/jfk9w/hoarder/internal/jobs"
+	. "github.com/jfk9w/hoarder/internal/jobs/tbank/internal/entities"
+	"github.com/jfk9w/hoarder/internal/jobs/tbank/internal/loaders"
+	"github.com/jfk9w/hoarder/internal/logs"
+)
+
+// archiveTransport stores raw bodies of successful responses to endpoints used by loaders.
+// Archiving failures are logged and do not affect requests.
+type archiveTransport struct {
+	http.RoundTripper
+	clock based.Clock
+	db    database.DB
+	log   *slog.Logger
+	phone string
+}
+
+func (t *archiveTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK || !loaders.Archived(req.URL.Path) {
+		return resp, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err := t.archive(req, body); err != nil {
+		t.log.WarnContext(req.Context(), "failed to archive response", "endpoint", req.URL.Path, logs.Error(err))
+	}
+
+	return resp, nil
+}
+
+func (t *archiveTransport) archive(req *http.Request, body []byte) error {
+	params := req.URL.Query()
+	for key := range params {
+		if strings.EqualFold(key, "sessionid") {
+			params.Del(key)
+		}
+	}
+
+	data, err := compress(body)
+	if err != nil {
+		return errors.Wrap(err, "compress body")
+	}
+
+	return t.db.WithContext(req.Context()).
+		Create(&RawResponse{
+			UserPhone: t.phone,
+			Endpoint:  req.URL.Path,
+			Params:    params.Encode(),
+			FetchedAt: t.clock.Now(),
+			Data:      data,
+		}).
+		Error
+}
+
+// Reprocess rebuilds entities of the user from archived API responses in the order they were received,
+// without calling the API.
+func (j *Job) Reprocess(ctx context.Context, userID string) error {
+	phones := j.getPhones(userID)
+	if len(phones) == 0 {
+		return errors.Errorf("user %s not found", userID)
+	}
+
+	jobCtx := jobs.NewContext(ctx, j.log.With("job", JobID)).With("user", userID)
+
+	var (
+		responses []RawResponse
+		count     int
+		errs      error
+	)
+
+	if err := j.db.WithContext(jobCtx).
+		Where("user_phone in ?", phones).
+		Order("id").
+		FindInBatches(&responses, j.batchSize, func(*gorm.DB, int) error {
+			for _, response := range responses {
+				ctx := jobCtx.With("phone", response.UserPhone)
+				body, err := decompress(response.Data)
+				if ctx.Error(&errs, err, "failed to decompress response body") {
+					continue
+				}
+
+				if err := loaders.Reprocess(ctx, j.db, j.batchSize, j.outbox, response, body); !multierr.AppendInto(&errs, err) {
+					count++
+				}
+			}
+
+			return nil
+		}).
+		Error; err != nil {
+		return errors.Wrap(err, "select archived responses")
+	}
+
+	jobCtx.Info("reprocessed archived responses", "count", count)
+	return errs
+}
+
+func compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// newArchiveTransport returns an HTTP transport archiving responses for the phone, or nil if archiving is disabled.
+func newArchiveTransport(params JobParams, db database.DB, phone string) http.RoundTripper {
+	if !params.Config.Archive {
+		return nil
+	}
+
+	return &archiveTransport{
+		RoundTripper: http.DefaultTransport,
+		clock:        params.Clock,
+		db:           db,
+		log:          params.Logger.With("job", JobID, "phone", phone),
+		phone:        phone,
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/jfk9w/hoarder/internal/database"
+	fireflySync "github.com/jfk9w/hoarder/internal/jobs/tbank/internal/sync/firefly"
 )
 
 type Credential struct {
@@ -11,10 +12,51 @@ type Credential struct {
 	Password string `yaml:"password" doc:"Пароль от аккаунта Тинькофф."`
 }
 
+type CounterpartyRule struct {
+	Pattern string `yaml:"pattern" doc:"Регулярное выражение для названия контрагента."`
+	Name    string `yaml:"name" doc:"Название счета контрагента в Firefly III."`
+}
+
+type TagRule struct {
+	Mcc            []uint   `yaml:"mcc,omitempty" doc:"MCC операции."`
+	Cards          []string `yaml:"cards,omitempty" doc:"Идентификаторы или маскированные номера карт."`
+	CardPresent    *bool    `yaml:"cardPresent,omitempty" doc:"Оплата с физическим присутствием карты."`
+	IsExternalCard *bool    `yaml:"isExternalCard,omitempty" doc:"Операция по карте другого банка."`
+	HasCashback    *bool    `yaml:"hasCashback,omitempty" doc:"По операции начислен кэшбэк."`
+	HasLoyalty     *bool    `yaml:"hasLoyalty,omitempty" doc:"По операции есть начисления или списания бонусов программ лояльности."`
+	HasLocation    *bool    `yaml:"hasLocation,omitempty" doc:"Для операции известно местоположение."`
+	Tags           []string `yaml:"tags,omitempty" doc:"Теги, добавляемые к транзакции."`
+	Notes          string   `yaml:"notes,omitempty" doc:"Шаблон text/template для заметок к транзакции.\n\nВ шаблоне доступны поля операции, например, {{.Mcc}} или {{.Cashback}}."`
+}
+
+type ReconciliationConfig struct {
+	Enabled bool   `yaml:"enabled,omitempty" doc:"Включить сверку балансов."`
+	Account string `yaml:"account,omitempty" doc:"Контрагент в Firefly III для корректирующих транзакций.\n\nЕсли не задан, корректирующие транзакции не создаются."`
+}
+
+type CashbackConfig struct {
+	Enabled        bool   `yaml:"enabled,omitempty" doc:"Включить отражение кэшбэка."`
+	Account        string `yaml:"account,omitempty" doc:"Счет активов в Firefly III для зачисления кэшбэка.\n\nСчет должен существовать в Firefly III. Если не задан, кэшбэк зачисляется на счет карты."`
+	RevenueAccount string `yaml:"revenueAccount,omitempty" default:"Кэшбэк" doc:"Счет доходов в Firefly III, с которого зачисляется кэшбэк."`
+	Category       string `yaml:"category,omitempty" default:"Кэшбэк" doc:"Категория транзакций с кэшбэком."`
+}
+
+type FireflyConfig struct {
+	InvestOperations   map[string]fireflySync.InvestOperationKind `yaml:"investOperations,omitempty" doc:"Переопределение способа отражения инвестиционных операций по их типу.\n\npayIn и payOut сопоставляются с операциями по банковским счетам и отражаются как переводы, income и expense – как доходы и расходы брокерского счета. Пустое значение отключает синхронизацию операций данного типа."`
+	RevaluationAccount string                                     `yaml:"revaluationAccount,omitempty" default:"Переоценка брокерского счета" doc:"Контрагент в Firefly III для корректировки баланса брокерского счета по стоимости портфеля."`
+	Counterparties     []CounterpartyRule                         `yaml:"counterparties,omitempty" doc:"Правила нормализации названий контрагентов.\n\nКонтрагенты определяются по бренду, мерчанту, провайдеру платежа или отправителю операции. Применяется первое правило, шаблон которого совпал с названием, например, \"(?i)^(pyaterochka|пят[её]рочка)\" → \"Пятёрочка\"."`
+	Rules              []TagRule                                  `yaml:"rules,omitempty" doc:"Правила тегов и заметок для транзакций.\n\nИзменения правил применяются к транзакциям за период overlap при следующей синхронизации, а ко всем уже синхронизированным транзакциям – с помощью --retag."`
+	Bills              bool                                       `yaml:"bills,omitempty" doc:"Создавать счета к оплате (bills) в Firefly III по выпискам кредитных карт.\n\nСумма счета – от минимального платежа до задолженности по выписке, дата – крайний срок оплаты. Переводы на кредитную карту с других синхронизируемых счетов между датой выписки и крайним сроком оплаты привязываются к счету."`
+	Cashback           *CashbackConfig                            `yaml:"cashback,omitempty" doc:"Отражение начисленного кэшбэка как доходов после закрытия выписки.\n\nИспользуется кэшбэк из выписки, а если он не указан – сумма кэшбэка (или бонусов программ лояльности) по операциям за период выписки. Списанные за период бонусы вычитаются. Кэшбэк, выплаченный операцией по счету, уже учтен как доход и пропускается."`
+	Reconciliation     *ReconciliationConfig                      `yaml:"reconciliation,omitempty" doc:"Сверка балансов счетов Т-Банка и Firefly III после синхронизации.\n\nРасхождения сохраняются в таблицу balance_mismatches и выводятся в результате запуска джобы."`
+}
+
 type Config struct {
-	Database     database.Config         `yaml:"database" doc:"Настройки подключения к БД."`
+	Database     database.Config         `yaml:"database,omitempty" doc:"Настройки подключения к БД.\n\nЕсли драйвер не задан, используется общая БД (database) со схемой по идентификатору джобы."`
 	BatchSize    int                     `yaml:"batchSize,omitempty" doc:"Максимальный размер батчей." default:"100"`
-	Overlap      time.Duration           `yaml:"overlap,omitempty" doc:"Продолжительность \"нахлеста\" при обновлении операций." default:"168h"`
+	Overlap      time.Duration           `yaml:"overlap,omitempty" doc:"Продолжительность \"нахлеста\" при обновлении операций.\n\nВ Firefly III повторно синхронизируются только операции за этот период и еще не синхронизированные операции." default:"168h"`
 	WithReceipts bool                    `yaml:"withReceipts,omitempty" doc:"Включить синхронизацию чеков." default:"true"`
+	Archive      bool                    `yaml:"archive,omitempty" doc:"Сохранять исходные ответы API в сжатом виде в таблицу raw_responses.\n\nПозволяет заново построить таблицы из сохраненных ответов без обращения к Т-Банку с помощью --reprocess, например, после исправления ошибок преобразования данных."`
 	Users        map[string][]Credential `yaml:"users" doc:"Пользователи и их авторизационные данные."`
+	Firefly      FireflyConfig           `yaml:"firefly,omitempty" doc:"Настройки синхронизации с Firefly III."`
 }
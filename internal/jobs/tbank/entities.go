@@ -1,7 +1,16 @@
 package tbank
 
 import (
+	"log/slog"
+
+	"github.com/jfk9w-go/based"
+	"github.com/pkg/errors"
+
+	"github.com/jfk9w/hoarder/internal/database"
+	"github.com/jfk9w/hoarder/internal/events"
 	. "github.com/jfk9w/hoarder/internal/jobs/tbank/internal/entities"
+	fireflySync "github.com/jfk9w/hoarder/internal/jobs/tbank/internal/sync/firefly"
+	"github.com/jfk9w/hoarder/internal/logs"
 )
 
 var entities = []any{
@@ -34,4 +43,117 @@ var entities = []any{
 	new(ClientOfferAccount),
 	new(ClientOfferEssence),
 	new(ClientOfferEssenceMccCode),
+	new(FireflyMapping),
+	new(FireflyOverride),
+	new(BalanceMismatch),
+}
+
+var money = []database.MoneyColumns{
+	{Model: new(Account), Columns: []string{"credit_limit_value", "money_amount_value", "debt_balance_value", "current_minimal_payment_value", "past_due_debt_value", "debt_amount_value"}},
+	{Model: new(Statement), Columns: []string{"overdraft_fee_value", "expense_value", "over_limit_debt_value", "period_end_balance_value", "arrest_amount_value", "other_bonus_value", "credit_limit_value", "tranches_monthly_payment_value", "billed_debt_value", "cashback_value", "balance_value", "high_cashback_value", "period_start_balance_value", "low_cashback_value", "available_limit_value", "interest_bonus_value", "interest_value", "income_value", "credit_bonus_value", "other_cashback_value", "minimal_payment_amount_value", "past_due_debt_value"}},
+	{Model: new(Operation), Columns: []string{"cashback_value", "cashback", "value", "account_value"}},
+	{Model: new(Payment), Columns: []string{"fee_amount_value"}},
+	{Model: new(Receipt), Columns: []string{"credit_sum", "provision_sum", "cash_total_sum", "total_sum", "ecash_total_sum", "nds10", "nds18", "prepaid_sum"}},
+	{Model: new(ReceiptItem), Columns: []string{"price", "sum", "nds10", "nds18"}},
+	{Model: new(InvestAccount), Columns: []string{"total_amount_value"}},
+	{Model: new(InvestOperation), Columns: []string{"payment_rub_value"}},
+}
+
+// retention lists tables which grow without bound and are of little value after a while.
+// Locations and loyalty payments are pruned by the time of their operations.
+var retention = []database.RetentionTarget{
+	{
+		Table: "locations",
+		Key:   []string{"operation_id", "db_idx"},
+		Time:  "(select operation_time from operations where operations.id = locations.operation_id)",
+	},
+	{
+		Table: "loyalty_payments",
+		Key:   []string{"operation_id", "db_idx"},
+		Time:  "(select operation_time from operations where operations.id = loyalty_payments.operation_id)",
+	},
+	{
+		// non-debited operations are mostly declined or cancelled authorizations
+		Table: new(Operation).TableName(),
+		Key:   []string{"id"},
+		Time:  "operation_time",
+		Where: "debiting_time is null",
+		References: []database.RetentionReference{
+			{
+				Table:   new(FireflyMapping).TableName(),
+				Columns: []string{"entity_key"},
+				Where:   "entity = 'operations'",
+			},
+		},
+	},
+	{
+		Table: new(RawResponse).TableName(),
+		Key:   []string{"id"},
+		Time:  "fetched_at",
+	},
+	events.RetentionTarget,
+}
+
+// getMigrations returns migrations of the job database. The initial migration is idempotent,
+// so that it may be applied to databases created before migrations were versioned.
+func getMigrations(cipher *database.Cipher) []database.Migration {
+	return []database.Migration{
+		{
+			Version:     1,
+			Description: "create tables",
+			Up:          database.ConvertMoney(money, database.Steps(prepareFireflyMappings, database.AutoMigrate(entities...))),
+		},
+		{
+			Version:     2,
+			Description: "encrypt secrets",
+			Up: func(tx database.DB) error {
+				return (&storage{db: tx, cipher: cipher}).encryptSecrets()
+			},
+		},
+		{
+			Version:     3,
+			Description: "create raw responses table",
+			Up:          database.AutoMigrate(new(RawResponse)),
+		},
+		{
+			Version:     4,
+			Description: "create outbox tables",
+			Up:          database.AutoMigrate(events.Entities...),
+		},
+	}
+}
+
+type MigrateParams struct {
+	Clock  based.Clock  `validate:"required"`
+	Logger *slog.Logger `validate:"required"`
+	Config Config       `validate:"required"`
+}
+
+// DatabaseParams returns parameters of the job database, which are used to migrate, back up, check and restore it
+// without creating the job.
+func DatabaseParams(params MigrateParams) (database.Params, error) {
+	cipher, err := database.NewCipher(params.Config.Database.Encryption)
+	if err != nil {
+		return database.Params{}, errors.Wrap(err, "create cipher")
+	}
+
+	checks, err := database.OrphanChecks(append(entities, new(RawResponse))...)
+	if err != nil {
+		return database.Params{}, errors.Wrap(err, "create orphan checks")
+	}
+
+	retention, err := database.WithRetentionChildren(retention, append(entities, new(RawResponse))...)
+	if err != nil {
+		return database.Params{}, errors.Wrap(err, "create retention targets")
+	}
+
+	return database.Params{
+		Clock:      params.Clock,
+		Logger:     params.Logger.With(logs.Database(JobID)),
+		Config:     params.Config.Database,
+		Name:       JobID,
+		Migrations: getMigrations(cipher),
+		Checks:     append(checks, fireflySync.Checks()...),
+		Retention:  retention,
+	}, nil
 }
@@ -0,0 +1,102 @@
+package tbank
+
+import (
+	"time"
+
+	"go.uber.org/multierr"
+
+	"github.com/jfk9w/hoarder/internal/firefly"
+	"github.com/jfk9w/hoarder/internal/jobs"
+	fireflySync "github.com/jfk9w/hoarder/internal/jobs/tbank/internal/sync/firefly"
+)
+
+type FireflyMode string
+
+const (
+	// FireflyIncremental stores new and changed records in Firefly III.
+	FireflyIncremental FireflyMode = "firefly"
+
+	// FireflyResync verifies that every stored Firefly id still exists and runs an incremental sync,
+	// looking up transactions by external ids before storing them.
+	FireflyResync FireflyMode = "firefly-resync"
+
+	// FireflyReset clears Firefly ids, so that everything is stored again on the next sync.
+	FireflyReset FireflyMode = "firefly-reset"
+)
+
+// FireflyResetter resets Firefly III ids of other jobs data which refers to T-Bank transactions,
+// e.g. of receipts matched with them.
+type FireflyResetter interface {
+	ResetFirefly(ctx jobs.Context, userID string) error
+}
+
+// FireflyJob runs Firefly III sync for already loaded data without requesting T-Bank.
+type FireflyJob struct {
+	job  *Job
+	mode FireflyMode
+}
+
+// FireflyJobs returns Firefly III sync jobs for all modes, or nil if Firefly III is not configured.
+func (j *Job) FireflyJobs() []jobs.Interface {
+	if j.firefly == nil {
+		return nil
+	}
+
+	return []jobs.Interface{
+		&FireflyJob{job: j, mode: FireflyIncremental},
+		&FireflyJob{job: j, mode: FireflyResync},
+		&FireflyJob{job: j, mode: FireflyReset},
+	}
+}
+
+func (j *FireflyJob) Info() jobs.Info {
+	info := jobs.Info{ID: JobID + "-" + string(j.mode)}
+	switch j.mode {
+	case FireflyIncremental:
+		// the main job syncs data after loading, so this one is only run on demand
+		info.Description = "Синхронизация загруженных данных Т-Банка с Firefly III"
+		info.Manual = true
+	case FireflyResync:
+		info.Description = "Проверка наличия синхронизированных записей в Firefly III и повторная синхронизация"
+		info.Manual = true
+	case FireflyReset:
+		info.Description = "Сброс идентификаторов Firefly III для данных Т-Банка"
+		info.Manual = true
+	}
+
+	return info
+}
+
+func (j *FireflyJob) Run(ctx jobs.Context, now time.Time, userID string) error {
+	phones := j.job.getPhones(userID)
+	client, ok := j.job.firefly.Get(userID)
+	if len(phones) == 0 || !ok {
+		return jobs.ErrJobUnconfigured
+	}
+
+	switch j.mode {
+	case FireflyReset:
+		j.job.fireflyMu.Lock()
+		defer j.job.fireflyMu.Unlock()
+		errs := fireflySync.Reset(ctx, j.job.db, client.ID, phones)
+		for _, resetter := range j.job.fireflyResetters {
+			_ = multierr.AppendInto(&errs, resetter.ResetFirefly(ctx, userID))
+		}
+
+		return errs
+	case FireflyResync:
+		if err := j.verify(ctx, client, phones); err != nil {
+			return err
+		}
+
+		return j.job.executeFireflySync(ctx, now, userID, true)
+	}
+
+	return j.job.executeFireflySync(ctx, now, userID, false)
+}
+
+func (j *FireflyJob) verify(ctx jobs.Context, client firefly.Instance, phones []string) error {
+	j.job.fireflyMu.Lock()
+	defer j.job.fireflyMu.Unlock()
+	return fireflySync.Verify(ctx, j.job.db, client, phones)
+}
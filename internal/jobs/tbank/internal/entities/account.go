@@ -1,5 +1,11 @@
 package entities
 
+import (
+	"time"
+
+	"github.com/jfk9w/hoarder/internal/database"
+)
+
 type MultiCardCluster struct {
 	Id string `json:"id"`
 }
@@ -53,42 +59,42 @@ type AccountCreditLimit struct {
 	CreditLimitCurrencyCode uint     `json:"-" gorm:"index"`
 	CreditLimitCurrency     Currency `json:"currency" gorm:"constraint:OnDelete:CASCADE"`
 
-	CreditLimitValue float64 `json:"value"`
+	CreditLimitValue database.Money `json:"value"`
 }
 
 type AccountMoneyAmount struct {
 	MoneyAmountCurrencyCode uint     `json:"-" gorm:"index"`
 	MoneyAmountCurrency     Currency `json:"currency" gorm:"constraint:OnDelete:CASCADE"`
 
-	MoneyAmountValue float64 `json:"value"`
+	MoneyAmountValue database.Money `json:"value"`
 }
 
 type AccountDebtBalance struct {
 	DebtBalanceCurrencyCode uint     `json:"-" gorm:"index"`
 	DebtBalanceCurrency     Currency `json:"currency" gorm:"constraint:OnDelete:CASCADE"`
 
-	DebtBalanceValue float64 `json:"value"`
+	DebtBalanceValue database.Money `json:"value"`
 }
 
 type AccountCurrentMinimalPayment struct {
 	CurrentMinimalPaymentCurrencyCode uint     `json:"-" gorm:"index"`
 	CurrentMinimalPaymentCurrency     Currency `json:"currency" gorm:"constraint:OnDelete:CASCADE"`
 
-	CurrentMinimalPaymentValue float64 `json:"value"`
+	CurrentMinimalPaymentValue database.Money `json:"value"`
 }
 
 type AccountPastDueDebt struct {
 	PastDueDebtCurrencyCode uint     `json:"-" gorm:"index"`
 	PastDueDebtCurrency     Currency `json:"currency" gorm:"constraint:OnDelete:CASCADE"`
 
-	PastDueDebtValue float64 `json:"value"`
+	PastDueDebtValue database.Money `json:"value"`
 }
 
 type AccountDebtAmount struct {
 	DebtAmountCurrencyCode uint     `json:"-" gorm:"index"`
 	DebtAmountCurrency     Currency `json:"currency" gorm:"constraint:OnDelete:CASCADE"`
 
-	DebtAmountValue float64 `json:"value"`
+	DebtAmountValue database.Money `json:"value"`
 }
 
 type Account struct {
@@ -124,16 +130,16 @@ type Account struct {
 	Hidden                bool                          `json:"hidden"`
 	SharedByMeFlag        *bool                         `json:"sharedByMeFlag,omitempty"`
 	Loyalty               *Loyalty                      `json:"loyalty,omitempty" gorm:"embedded;embeddedPrefix:loyalty_"`
-	CreationDate          *Milliseconds                 `json:"creationDate,omitempty"`
+	CreationDate          *Milliseconds                 `json:"creationDate,omitempty" upsert:"coalesce"`
 	DebtAmount            *AccountDebtAmount            `json:"debtAmount,omitempty" gorm:"embedded"`
-	LastStatementDate     *Milliseconds                 `json:"lastStatementDate,omitempty"`
+	LastStatementDate     *Milliseconds                 `json:"lastStatementDate,omitempty" upsert:"max"`
 	DueColor              *int                          `json:"dueColor,omitempty"`
 	LinkedAccountNumber   *string                       `json:"linkedAccountNumber,omitempty"`
 	IsKidsSaving          *bool                         `json:"isKidsSaving,omitempty"`
 	IsCrowdfunding        *bool                         `json:"isCrowdfunding,omitempty"`
 	//Shared                *AccountShared    `json:"shared,omitempty"`
 
-	FireflyId *string `json:"-" gorm:"<-:false;index"`
+	FireflyId *string `json:"-" gorm:"->;-:migration"`
 }
 
 func (a Account) TableName() string {
@@ -160,3 +166,21 @@ type AccountRequisites struct {
 func (ar AccountRequisites) TableName() string {
 	return "account_requisites"
 }
+
+// BalanceMismatch records a difference between the account balance in T-Bank and in a Firefly III instance.
+type BalanceMismatch struct {
+	Instance  string  `json:"instance" gorm:"primaryKey"`
+	AccountId string  `json:"-" gorm:"primaryKey"`
+	Account   Account `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+
+	CheckedAt  time.Time      `json:"checkedAt" gorm:"primaryKey"`
+	Expected   database.Money `json:"expected"`
+	Actual     database.Money `json:"actual"`
+	Difference database.Money `json:"difference"`
+
+	FireflyId *string `json:"-" gorm:"<-:false;index"`
+}
+
+func (m BalanceMismatch) TableName() string {
+	return "balance_mismatches"
+}
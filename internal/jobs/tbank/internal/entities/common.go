@@ -5,7 +5,7 @@ type Currency struct {
 	Name    string `json:"name" gorm:"index"`
 	StrCode string `json:"strCode"`
 
-	FireflyId *string `json:"-" gorm:"<-:false;index"`
+	FireflyId *string `json:"-" gorm:"->;-:migration"`
 }
 
 func (c Currency) TableName() string {
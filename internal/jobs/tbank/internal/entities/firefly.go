@@ -0,0 +1,37 @@
+package entities
+
+// FireflyMapping links a record to an object in a Firefly III instance.
+// Records may be shared by users syncing into different instances, so Firefly ids are not stored in records themselves.
+type FireflyMapping struct {
+	Instance string `json:"instance" gorm:"primaryKey"`
+	Entity   string `json:"entity" gorm:"primaryKey"`
+	Key      string `json:"key" gorm:"primaryKey;column:entity_key"`
+
+	FireflyId string  `json:"fireflyId" gorm:"index"`
+	Hash      *string `json:"hash,omitempty"`
+}
+
+func (m FireflyMapping) TableName() string {
+	return "firefly_mappings"
+}
+
+// FireflyOverride holds changes made by the user to a transaction in a Firefly III instance.
+// Overridden fields are respected by later syncs. Sent fields hold the values last sent by the sync,
+// so that webhooks caused by the sync itself are not taken for user changes.
+type FireflyOverride struct {
+	Instance  string `json:"instance" gorm:"primaryKey"`
+	FireflyId string `json:"fireflyId" gorm:"primaryKey"`
+
+	Description    *string `json:"description,omitempty"`
+	CategoryId     *string `json:"categoryId,omitempty"`
+	CounterpartyId *string `json:"counterpartyId,omitempty"`
+	Deleted        bool    `json:"deleted"`
+
+	SentDescription    string `json:"-"`
+	SentCategoryId     string `json:"-"`
+	SentCounterpartyId string `json:"-"`
+}
+
+func (o FireflyOverride) TableName() string {
+	return "firefly_overrides"
+}
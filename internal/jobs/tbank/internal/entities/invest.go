@@ -1,5 +1,9 @@
 package entities
 
+import (
+	"github.com/jfk9w/hoarder/internal/database"
+)
+
 type InvestOperationType struct {
 	Deleted bool `json:"-" gorm:"index"`
 
@@ -17,14 +21,21 @@ type InvestAmount struct {
 	Value    float64 `json:"value"`
 }
 
+// InvestMoneyAmount is an amount which is booked in Firefly III.
+// Prices and yields are kept as InvestAmount, since they may be more precise than minor units.
+type InvestMoneyAmount struct {
+	Currency string         `json:"currency"`
+	Value    database.Money `json:"value"`
+}
+
 type InvestTotals struct {
-	ExpectedYield                InvestAmount `json:"expectedYield" gorm:"embedded;embeddedPrefix:expected_yield_"`
-	ExpectedYieldRelative        float64      `json:"expectedYieldRelative"`
-	ExpectedYieldPerDay          InvestAmount `json:"expectedYieldPerDay" gorm:"embedded;embeddedPrefix:expected_yield_per_day_"`
-	ExpectedYieldPerDayRelative  float64      `json:"expectedYieldPerDayRelative"`
-	ExpectedAverageYield         InvestAmount `json:"expectedAverageYield" gorm:"embedded;embeddedPrefix:expected_average_yield_"`
-	ExpectedAverageYieldRelative float64      `json:"expectedAverageYieldRelative"`
-	TotalAmount                  InvestAmount `json:"totalAmount" gorm:"embedded;embeddedPrefix:total_amount_"`
+	ExpectedYield                InvestAmount      `json:"expectedYield" gorm:"embedded;embeddedPrefix:expected_yield_"`
+	ExpectedYieldRelative        float64           `json:"expectedYieldRelative"`
+	ExpectedYieldPerDay          InvestAmount      `json:"expectedYieldPerDay" gorm:"embedded;embeddedPrefix:expected_yield_per_day_"`
+	ExpectedYieldPerDayRelative  float64           `json:"expectedYieldPerDayRelative"`
+	ExpectedAverageYield         InvestAmount      `json:"expectedAverageYield" gorm:"embedded;embeddedPrefix:expected_average_yield_"`
+	ExpectedAverageYieldRelative float64           `json:"expectedAverageYieldRelative"`
+	TotalAmount                  InvestMoneyAmount `json:"totalAmount" gorm:"embedded;embeddedPrefix:total_amount_"`
 }
 
 type InvestAccount struct {
@@ -46,6 +57,8 @@ type InvestAccount struct {
 	AutoApp       bool   `json:"autoApp"`
 
 	InvestTotals `gorm:"embedded"`
+
+	FireflyId *string `json:"-" gorm:"->;-:migration"`
 }
 
 func (a InvestAccount) TableName() string {
@@ -105,7 +118,7 @@ type InvestOperation struct {
 	Name                          *string                `json:"name,omitempty"`
 	Payment                       InvestAmount           `json:"payment" gorm:"embedded;embeddedPrefix:payment_"`
 	PaymentEur                    InvestAmount           `json:"paymentEur" gorm:"embedded;embeddedPrefix:payment_eur_"`
-	PaymentRub                    InvestAmount           `json:"paymentRub" gorm:"embedded;embeddedPrefix:payment_rub_"`
+	PaymentRub                    InvestMoneyAmount      `json:"paymentRub" gorm:"embedded;embeddedPrefix:payment_rub_"`
 	PaymentUsd                    InvestAmount           `json:"paymentUsd" gorm:"embedded;embeddedPrefix:payment_usd_"`
 	PositionUid                   *string                `json:"positionUid,omitempty"`
 	ShortDescription              *string                `json:"shortDescription,omitempty"`
@@ -127,6 +140,8 @@ type InvestOperation struct {
 	CancelReason                  *string                `json:"cancelReason,omitempty"`
 	QuantityRest                  *int                   `json:"quantityRest,omitempty"`
 	WithdrawDateTime              *DateTime              `json:"withdrawDateTime,omitempty"`
+
+	FireflyId *string `json:"-" gorm:"->;-:migration"`
 }
 
 func (o InvestOperation) TableName() string {
@@ -1,6 +1,12 @@
 package entities
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+
+	"github.com/jfk9w/hoarder/internal/database"
+)
 
 type Category struct {
 	Id   string `json:"id" gorm:"primaryKey"`
@@ -55,7 +61,7 @@ type SpendingCategory struct {
 	Id   string `json:"id" gorm:"primaryKey"`
 	Name string `json:"name" gorm:"index"`
 
-	FireflyId *string `json:"-" gorm:"<-:false;index"`
+	FireflyId *string `json:"-" gorm:"->;-:migration"`
 }
 
 func (sc SpendingCategory) TableName() string {
@@ -104,7 +110,7 @@ type PaymentFeeAmount struct {
 	FeeAmountCurrencyCode uint     `json:"-" gorm:"index"`
 	FeeAmountCurrency     Currency `json:"currency" gorm:"constraint:OnDelete:CASCADE"`
 
-	FeeAmountValue float64 `json:"value"`
+	FeeAmountValue database.Money `json:"value"`
 }
 
 type PaymentFieldValue struct {
@@ -130,10 +136,10 @@ func (fvs *PaymentFieldsValues) UnmarshalJSON(data []byte) error {
 		return err
 	}
 
-	for key, value := range values {
+	for _, key := range slices.Sorted(maps.Keys(values)) {
 		*fvs = append(*fvs, PaymentFieldValue{
 			Key:   key,
-			Value: value,
+			Value: values[key],
 		})
 	}
 
@@ -167,21 +173,21 @@ type OperationCashbackAmount struct {
 	CashbackCurrencyCode uint     `json:"-" gorm:"index"`
 	CashbackCurrency     Currency `json:"currency" gorm:"constraint:OnDelete:CASCADE"`
 
-	CashbackValue float64 `json:"value"`
+	CashbackValue database.Money `json:"value"`
 }
 
 type OperationAmount struct {
 	CurrencyCode uint     `json:"-" gorm:"index"`
 	Currency     Currency `json:"currency" gorm:"constraint:OnDelete:CASCADE"`
 
-	Value float64 `json:"value"`
+	Value database.Money `json:"value"`
 }
 
 type OperationAccountAmount struct {
 	AccountCurrencyCode uint     `json:"-" gorm:"index"`
 	AccountCurrency     Currency `json:"currency" gorm:"constraint:OnDelete:CASCADE"`
 
-	AccountValue float64 `json:"value"`
+	AccountValue database.Money `json:"value"`
 }
 
 type Operation struct {
@@ -207,7 +213,7 @@ type Operation struct {
 	IsOffline              bool                    `json:"isOffline"`
 	HasStatement           bool                    `json:"hasStatement"`
 	IsSuspicious           bool                    `json:"isSuspicious"`
-	AuthorizationId        *string                 `json:"authorizationId,omitempty"`
+	AuthorizationId        *string                 `json:"authorizationId,omitempty" upsert:"coalesce"`
 	IsInner                bool                    `json:"isInner" gorm:"index"`
 	Id                     string                  `json:"id" gorm:"primaryKey"`
 	Status                 string                  `json:"status" gorm:"index"`
@@ -221,7 +227,7 @@ type Operation struct {
 	AuthMessage            *string                 `json:"authMessage,omitempty"`
 	Description            string                  `json:"description"`
 	IsTemplatable          bool                    `json:"isTemplatable"`
-	Cashback               float64                 `json:"cashback"`
+	Cashback               database.Money          `json:"cashback"`
 	Amount                 OperationAmount         `json:"amount" gorm:"embedded"`
 	OperationTime          Milliseconds            `json:"operationTime" gorm:"index"`
 	IsHce                  bool                    `json:"isHce"`
@@ -242,7 +248,7 @@ type Operation struct {
 	LoyaltyBonusSummary    *LoyaltyBonusSummary    `json:"loyaltyBonusSummary,omitempty" gorm:"embedded;embeddedPrefix:loyalty_bonus_summary_"`
 	TypeSerno              *uint                   `json:"typeSerno"`
 	OperationPaymentType   *string                 `json:"operationPaymentType,omitempty"`
-	DebitingTime           *Milliseconds           `json:"debitingTime,omitempty" gorm:"index"`
+	DebitingTime           *Milliseconds           `json:"debitingTime,omitempty" gorm:"index" upsert:"coalesce"`
 	PosId                  *string                 `json:"posId,omitempty"`
 	Subcategory            *string                 `json:"subcategory,omitempty" gorm:"index"`
 	SenderAgreement        *string                 `json:"senderAgreement,omitempty"`
@@ -255,7 +261,8 @@ type Operation struct {
 	Message                *string                 `json:"message,omitempty"`
 	TrancheId              *string                 `json:"trancheId,omitempty"`
 
-	FireflyId *string `json:"-" gorm:"<-:false;index"`
+	FireflyId   *string `json:"-" gorm:"->;-:migration"`
+	FireflyHash *string `json:"-" gorm:"->;-:migration"`
 }
 
 func (o Operation) TableName() string {
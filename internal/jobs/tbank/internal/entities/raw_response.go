@@ -0,0 +1,22 @@
+package entities
+
+import "time"
+
+// RawResponse is an archived API response, which allows to rebuild entities without calling the API,
+// for example, after a conversion bug is fixed or new fields are mapped.
+type RawResponse struct {
+	Id        uint64 `gorm:"primaryKey;autoIncrement"`
+	UserPhone string `gorm:"index;not null"`
+	User      User   `gorm:"constraint:OnDelete:CASCADE"`
+
+	Endpoint  string    `gorm:"index;not null"`
+	Params    string    `gorm:"not null"`
+	FetchedAt time.Time `gorm:"index;not null"`
+
+	// Data is the gzip-compressed response body.
+	Data []byte `gorm:"not null"`
+}
+
+func (r RawResponse) TableName() string {
+	return "raw_responses"
+}
@@ -1,19 +1,21 @@
 package entities
 
+import "github.com/jfk9w/hoarder/internal/database"
+
 type ReceiptItem struct {
 	OperationId string `json:"-" gorm:"primaryKey"`
 	DbIdx       int    `json:"dbIdx" gorm:"primaryKey"`
 
-	Name     string   `json:"name" gorm:"index"`
-	Price    float64  `json:"price"`
-	Sum      float64  `json:"sum"`
-	Quantity float64  `json:"quantity"`
-	NdsRate  *uint8   `json:"ndsRate"`
-	Nds      *uint8   `json:"nds"`
-	Nds10    *float64 `json:"nds10,omitempty"`
-	Nds18    *float64 `json:"nds18,omitempty"`
-	BrandId  *uint64  `json:"brand_id,omitempty"`
-	GoodId   *uint64  `json:"good_id,omitempty"`
+	Name     string          `json:"name" gorm:"index"`
+	Price    database.Money  `json:"price"`
+	Sum      database.Money  `json:"sum"`
+	Quantity float64         `json:"quantity"`
+	NdsRate  *uint8          `json:"ndsRate"`
+	Nds      *uint8          `json:"nds"`
+	Nds10    *database.Money `json:"nds10,omitempty"`
+	Nds18    *database.Money `json:"nds18,omitempty"`
+	BrandId  *uint64         `json:"brand_id,omitempty"`
+	GoodId   *uint64         `json:"good_id,omitempty"`
 }
 
 type Receipt struct {
@@ -22,22 +24,22 @@ type Receipt struct {
 
 	RetailPlace             *string         `json:"retailPlace,omitempty"`
 	RetailPlaceAddress      *string         `json:"retailPlaceAddress,omitempty"`
-	CreditSum               *float64        `json:"creditSum,omitempty"`
-	ProvisionSum            *float64        `json:"provisionSum,omitempty"`
+	CreditSum               *database.Money `json:"creditSum,omitempty"`
+	ProvisionSum            *database.Money `json:"provisionSum,omitempty"`
 	FiscalDriveNumber       *uint64         `json:"fiscalDriveNumber,omitempty"`
 	OperationType           uint8           `json:"operationType"`
-	CashTotalSum            float64         `json:"cashTotalSum"`
+	CashTotalSum            database.Money  `json:"cashTotalSum"`
 	ShiftNumber             uint            `json:"shiftNumber"`
 	KktRegId                string          `json:"kktRegId"`
 	Items                   []ReceiptItem   `json:"items" gorm:"constraint:OnDelete:CASCADE;foreignKey:OperationId"`
-	TotalSum                float64         `json:"totalSum"`
-	EcashTotalSum           float64         `json:"ecashTotalSum"`
-	Nds10                   *float64        `json:"nds10,omitempty"`
-	Nds18                   *float64        `json:"nds18,omitempty"`
+	TotalSum                database.Money  `json:"totalSum"`
+	EcashTotalSum           database.Money  `json:"ecashTotalSum"`
+	Nds10                   *database.Money `json:"nds10,omitempty"`
+	Nds18                   *database.Money `json:"nds18,omitempty"`
 	UserInn                 string          `json:"userInn"`
 	DateTime                ReceiptDateTime `json:"dateTime"`
 	TaxationType            uint8           `json:"taxationType"`
-	PrepaidSum              *float64        `json:"prepaidSum,omitempty"`
+	PrepaidSum              *database.Money `json:"prepaidSum,omitempty"`
 	FiscalSign              uint64          `json:"fiscalSign"`
 	RequestNumber           uint            `json:"requestNumber"`
 	Operator                *string         `json:"operator,omitempty"`
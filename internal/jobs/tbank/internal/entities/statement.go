@@ -1,5 +1,7 @@
 package entities
 
+import "github.com/jfk9w/hoarder/internal/database"
+
 type StatementPeriod struct {
 	Start Milliseconds `json:"start"`
 	End   Milliseconds `json:"end"`
@@ -9,154 +11,154 @@ type StatementOverdraftFee struct {
 	OverdraftFeeCurrencyCode uint     `json:"-" gorm:"index"`
 	OverdraftFeeCurrency     Currency `json:"currency" gorm:"constraint:OnDelete:CASCADE"`
 
-	OverdraftFeeValue float64 `json:"value"`
+	OverdraftFeeValue database.Money `json:"value"`
 }
 
 type StatementExpense struct {
 	ExpenseCurrencyCode uint     `json:"-" gorm:"index"`
 	ExpenseCurrency     Currency `json:"currency" gorm:"constraint:OnDelete:CASCADE"`
 
-	ExpenseValue float64 `json:"value"`
+	ExpenseValue database.Money `json:"value"`
 }
 
 type StatementOverLimitDebt struct {
 	OverLimitDebtCurrencyCode uint     `json:"-" gorm:"index"`
 	OverLimitDebtCurrency     Currency `json:"currency" gorm:"constraint:OnDelete:CASCADE"`
 
-	OverLimitDebtValue float64 `json:"value"`
+	OverLimitDebtValue database.Money `json:"value"`
 }
 
 type StatementPeriodEndBalance struct {
 	PeriodEndBalanceCurrencyCode uint     `json:"-" gorm:"index"`
 	PeriodEndBalanceCurrency     Currency `json:"currency" gorm:"constraint:OnDelete:CASCADE"`
 
-	PeriodEndBalanceValue float64 `json:"value"`
+	PeriodEndBalanceValue database.Money `json:"value"`
 }
 
 type StatementArrestAmount struct {
 	ArrestAmountCurrencyCode uint     `json:"-" gorm:"index"`
 	ArrestAmountCurrency     Currency `json:"currency" gorm:"constraint:OnDelete:CASCADE"`
 
-	ArrestAmountValue float64 `json:"value"`
+	ArrestAmountValue database.Money `json:"value"`
 }
 
 type StatementOtherBonus struct {
 	OtherBonusCurrencyCode uint     `json:"-" gorm:"index"`
 	OtherBonusCurrency     Currency `json:"currency" gorm:"constraint:OnDelete:CASCADE"`
 
-	OtherBonusValue float64 `json:"value"`
+	OtherBonusValue database.Money `json:"value"`
 }
 
 type StatementCreditLimit struct {
 	CreditLimitCurrencyCode uint     `json:"-" gorm:"index"`
 	CreditLimitCurrency     Currency `json:"currency" gorm:"constraint:OnDelete:CASCADE"`
 
-	CreditLimitValue float64 `json:"value"`
+	CreditLimitValue database.Money `json:"value"`
 }
 
 type StatementTranchesMonthlyPayment struct {
 	TranchesMonthlyPaymentCurrencyCode uint     `json:"-" gorm:"index"`
 	TranchesMonthlyPaymentCurrency     Currency `json:"currency" gorm:"constraint:OnDelete:CASCADE"`
 
-	TranchesMonthlyPaymentValue float64 `json:"value"`
+	TranchesMonthlyPaymentValue database.Money `json:"value"`
 }
 
 type StatementBilledDebt struct {
 	BilledDebtCurrencyCode uint     `json:"-" gorm:"index"`
 	BilledDebtCurrency     Currency `json:"currency" gorm:"constraint:OnDelete:CASCADE"`
 
-	BilledDebtValue float64 `json:"value"`
+	BilledDebtValue database.Money `json:"value"`
 }
 
 type StatementCashback struct {
 	CashbackCurrencyCode uint     `json:"-" gorm:"index"`
 	CashbackCurrency     Currency `json:"currency" gorm:"constraint:OnDelete:CASCADE"`
 
-	CashbackValue float64 `json:"value"`
+	CashbackValue database.Money `json:"value"`
 }
 
 type StatementBalance struct {
 	BalanceCurrencyCode uint     `json:"-" gorm:"index"`
 	BalanceCurrency     Currency `json:"currency" gorm:"constraint:OnDelete:CASCADE"`
 
-	BalanceValue float64 `json:"value"`
+	BalanceValue database.Money `json:"value"`
 }
 
 type StatementHighCashback struct {
 	HighCashbackCurrencyCode uint     `json:"-" gorm:"index"`
 	HighCashbackCurrency     Currency `json:"currency" gorm:"constraint:OnDelete:CASCADE"`
 
-	HighCashbackValue float64 `json:"value"`
+	HighCashbackValue database.Money `json:"value"`
 }
 
 type StatementPeriodStartBalance struct {
 	PeriodStartBalanceCurrencyCode uint     `json:"-" gorm:"index"`
 	PeriodStartBalanceCurrency     Currency `json:"currency" gorm:"constraint:OnDelete:CASCADE"`
 
-	PeriodStartBalanceValue float64 `json:"value"`
+	PeriodStartBalanceValue database.Money `json:"value"`
 }
 
 type StatementLowCashback struct {
 	LowCashbackCurrencyCode uint     `json:"-" gorm:"index"`
 	LowCashbackCurrency     Currency `json:"currency" gorm:"constraint:OnDelete:CASCADE"`
 
-	LowCashbackValue float64 `json:"value"`
+	LowCashbackValue database.Money `json:"value"`
 }
 
 type StatementAvailableLimit struct {
 	AvailableLimitCurrencyCode uint     `json:"-" gorm:"index"`
 	AvailableLimitCurrency     Currency `json:"currency" gorm:"constraint:OnDelete:CASCADE"`
 
-	AvailableLimitValue float64 `json:"value"`
+	AvailableLimitValue database.Money `json:"value"`
 }
 
 type StatementInterestBonus struct {
 	InterestBonusCurrencyCode uint     `json:"-" gorm:"index"`
 	InterestBonusCurrency     Currency `json:"currency" gorm:"constraint:OnDelete:CASCADE"`
 
-	InterestBonusValue float64 `json:"value"`
+	InterestBonusValue database.Money `json:"value"`
 }
 
 type StatementInterest struct {
 	InterestCurrencyCode uint     `json:"-" gorm:"index"`
 	InterestCurrency     Currency `json:"currency" gorm:"constraint:OnDelete:CASCADE"`
 
-	InterestValue float64 `json:"value"`
+	InterestValue database.Money `json:"value"`
 }
 
 type StatementIncome struct {
 	IncomeCurrencyCode uint     `json:"-" gorm:"index"`
 	IncomeCurrency     Currency `json:"currency" gorm:"constraint:OnDelete:CASCADE"`
 
-	IncomeValue float64 `json:"value"`
+	IncomeValue database.Money `json:"value"`
 }
 
 type StatementCreditBonus struct {
 	CreditBonusCurrencyCode uint     `json:"-" gorm:"index"`
 	CreditBonusCurrency     Currency `json:"currency" gorm:"constraint:OnDelete:CASCADE"`
 
-	CreditBonusValue float64 `json:"value"`
+	CreditBonusValue database.Money `json:"value"`
 }
 
 type StatementOtherCashback struct {
 	OtherCashbackCurrencyCode uint     `json:"-" gorm:"index"`
 	OtherCashbackCurrency     Currency `json:"currency" gorm:"constraint:OnDelete:CASCADE"`
 
-	OtherCashbackValue float64 `json:"value"`
+	OtherCashbackValue database.Money `json:"value"`
 }
 
 type StatementMinimalPaymentAmount struct {
 	MinimalPaymentAmountCurrencyCode uint     `json:"-" gorm:"index"`
 	MinimalPaymentAmountCurrency     Currency `json:"currency" gorm:"constraint:OnDelete:CASCADE"`
 
-	MinimalPaymentAmountValue float64 `json:"value"`
+	MinimalPaymentAmountValue database.Money `json:"value"`
 }
 
 type StatementPastDueDebt struct {
 	PastDueDebtCurrencyCode uint     `json:"-" gorm:"index"`
 	PastDueDebtCurrency     Currency `json:"currency" gorm:"constraint:OnDelete:CASCADE"`
 
-	PastDueDebtValue float64 `json:"value"`
+	PastDueDebtValue database.Money `json:"value"`
 }
 
 type Statement struct {
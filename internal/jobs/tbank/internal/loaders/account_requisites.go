@@ -24,6 +24,11 @@ func (l accountRequisites) Load(ctx jobs.Context, client Client, db database.DB)
 		return
 	}
 
+	errs = l.store(ctx, db, out)
+	return
+}
+
+func (l accountRequisites) store(ctx jobs.Context, db database.DB, out *tbank.AccountRequisitesOut) (errs error) {
 	if out == nil {
 		return
 	}
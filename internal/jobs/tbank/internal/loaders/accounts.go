@@ -3,7 +3,10 @@ package loaders
 import (
 	"time"
 
+	tbank "github.com/jfk9w-go/tbank-api"
+
 	"github.com/jfk9w/hoarder/internal/database"
+	"github.com/jfk9w/hoarder/internal/events"
 	"github.com/jfk9w/hoarder/internal/jobs"
 	. "github.com/jfk9w/hoarder/internal/jobs/tbank/internal/entities"
 )
@@ -19,6 +22,7 @@ type Accounts struct {
 	BatchSize    int
 	Overlap      time.Duration
 	WithReceipts bool
+	Outbox       events.Outbox
 }
 
 func (l Accounts) TableName() string {
@@ -31,15 +35,31 @@ func (l Accounts) Load(ctx jobs.Context, client Client, db database.DB) (ls []In
 		return
 	}
 
-	if len(out) == 0 {
+	ids, errs := l.store(ctx, db, out)
+	if errs != nil {
 		return
 	}
 
-	var (
-		entities []Account
-		ids      []string
-	)
+	for _, id := range ids {
+		ls = append(ls,
+			accountRequisites{accountId: id},
+			statements{accountId: id, batchSize: l.BatchSize},
+			operations{accountId: id, batchSize: l.BatchSize, overlap: l.Overlap, outbox: l.Outbox})
+	}
+
+	if len(out) > 0 && l.WithReceipts {
+		ls = append(ls, receipts{phone: l.Phone, batchSize: l.BatchSize, outbox: l.Outbox})
+	}
+
+	return
+}
+
+func (l Accounts) store(ctx jobs.Context, db database.DB, out tbank.AccountsLightIbOut) (ids []string, errs error) {
+	if len(out) == 0 {
+		return
+	}
 
+	var entities []Account
 	for _, out := range out {
 		ctx := ctx.With("account_id", out.Id)
 		if !supportedAccountTypes[out.AccountType] {
@@ -58,6 +78,15 @@ func (l Accounts) Load(ctx jobs.Context, client Client, db database.DB) (ls []In
 	}
 
 	if errs = db.WithContext(ctx).Transaction(func(tx database.DB) (errs error) {
+		var outbox []events.Event
+		if l.Outbox.Enabled() {
+			var err error
+			outbox, err = accountEvents(tx, l.Phone, entities, ids)
+			if ctx.Error(&errs, err, "failed to create events") {
+				return
+			}
+		}
+
 		if err := tx.Upsert(entities).Error; ctx.Error(&errs, err, "failed to update entities in db") {
 			return
 		}
@@ -69,23 +98,15 @@ func (l Accounts) Load(ctx jobs.Context, client Client, db database.DB) (ls []In
 			return
 		}
 
+		if err := l.Outbox.Write(tx, outbox); ctx.Error(&errs, err, "failed to write events") {
+			return
+		}
+
 		return
 	}); errs != nil {
 		return
 	}
 
 	ctx.Info("updated entities in db", "count", len(ids))
-
-	for _, id := range ids {
-		ls = append(ls,
-			accountRequisites{accountId: id},
-			statements{accountId: id, batchSize: l.BatchSize},
-			operations{accountId: id, batchSize: l.BatchSize, overlap: l.Overlap})
-	}
-
-	if l.WithReceipts {
-		ls = append(ls, receipts{phone: l.Phone, batchSize: l.BatchSize})
-	}
-
 	return
 }
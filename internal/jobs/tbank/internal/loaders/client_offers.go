@@ -2,6 +2,7 @@ package loaders
 
 import (
 	"github.com/AlekSi/pointer"
+	tbank "github.com/jfk9w-go/tbank-api"
 
 	"github.com/jfk9w/hoarder/internal/database"
 	"github.com/jfk9w/hoarder/internal/jobs"
@@ -23,6 +24,11 @@ func (l ClientOffers) Load(ctx jobs.Context, client Client, db database.DB) (ls
 		return
 	}
 
+	errs = l.store(ctx, db, out)
+	return
+}
+
+func (l ClientOffers) store(ctx jobs.Context, db database.DB, out tbank.ClientOfferEssencesOut) (errs error) {
 	if len(out) == 0 {
 		return
 	}
@@ -0,0 +1,33 @@
+package loaders
+
+import (
+	"reflect"
+	"testing"
+
+	tbank "github.com/jfk9w-go/tbank-api"
+
+	"github.com/jfk9w/hoarder/internal/database"
+	"github.com/jfk9w/hoarder/internal/database/databasetest"
+	. "github.com/jfk9w/hoarder/internal/jobs/tbank/internal/entities"
+)
+
+func testConversion[T any](t *testing.T, source any) {
+	t.Run(reflect.TypeFor[T]().String(), func(t *testing.T) {
+		if _, err := database.CheckToViaJSON[T](source); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// TestConversions checks that API responses are converted to entities with reflection the same way as with the JSON round-trip.
+func TestConversions(t *testing.T) {
+	testConversion[[]Operation](t, databasetest.Fixture[tbank.OperationsOut]())
+	testConversion[Account](t, databasetest.Fixture[tbank.AccountsLightIbOut]()[0])
+	testConversion[AccountRequisites](t, databasetest.Fixture[*tbank.AccountRequisitesOut]())
+	testConversion[[]Statement](t, databasetest.Fixture[tbank.StatementsOut]())
+	testConversion[Receipt](t, databasetest.Fixture[tbank.ShoppingReceiptOut]().Receipt)
+	testConversion[[]ClientOffer](t, databasetest.Fixture[tbank.ClientOfferEssencesOut]())
+	testConversion[[]InvestAccount](t, databasetest.Fixture[tbank.InvestAccountsOut]().Accounts.List)
+	testConversion[[]InvestOperation](t, databasetest.Fixture[tbank.InvestOperationsOut]().Items)
+	testConversion[InvestOperationType](t, databasetest.Fixture[tbank.InvestOperationTypesOut]().OperationsTypes[0])
+}
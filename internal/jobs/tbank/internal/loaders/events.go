@@ -0,0 +1,143 @@
+package loaders
+
+import (
+	"slices"
+
+	"github.com/pkg/errors"
+
+	"github.com/jfk9w/hoarder/internal/database"
+	"github.com/jfk9w/hoarder/internal/events"
+	. "github.com/jfk9w/hoarder/internal/jobs/tbank/internal/entities"
+)
+
+type operationEvent struct {
+	Operation      *Operation `json:"operation"`
+	PreviousAmount *amount    `json:"previousAmount,omitempty"`
+}
+
+type amount struct {
+	Value        database.Money `json:"value"`
+	CurrencyCode uint           `json:"currencyCode"`
+}
+
+type accountEvent struct {
+	Account *Account `json:"account"`
+}
+
+type receiptEvent struct {
+	OperationId string   `json:"operationId"`
+	Receipt     *Receipt `json:"receipt"`
+}
+
+// selectStoredOperations returns the stored state of operations which is compared with the received one
+// to produce events.
+func selectStoredOperations(tx database.DB, entities []Operation, batchSize int) (map[string]Operation, error) {
+	ids := make([]string, len(entities))
+	for i, entity := range entities {
+		ids[i] = entity.Id
+	}
+
+	stored := make(map[string]Operation)
+	for ids := range slices.Chunk(ids, batchSize) {
+		var batch []Operation
+		if err := tx.
+			Select("id", "debiting_time", "currency_code", "value").
+			Where("id in ?", ids).
+			Find(&batch).
+			Error; err != nil {
+			return nil, err
+		}
+
+		for _, entity := range batch {
+			stored[entity.Id] = entity
+		}
+	}
+
+	return stored, nil
+}
+
+// operationEvents returns events of changes of received operations relative to the stored ones.
+func operationEvents(stored map[string]Operation, entities []Operation) ([]events.Event, error) {
+	var outbox []events.Event
+	add := func(eventType string, entity *Operation, previousAmount *amount) error {
+		event, err := events.New(eventType, entity.Id, operationEvent{Operation: entity, PreviousAmount: previousAmount})
+		if err != nil {
+			return err
+		}
+
+		outbox = append(outbox, event)
+		return nil
+	}
+
+	for i := range entities {
+		entity := &entities[i]
+		previous, ok := stored[entity.Id]
+		if !ok {
+			if err := add(events.OperationCreated, entity, nil); err != nil {
+				return nil, err
+			}
+		}
+
+		if previous.DebitingTime == nil && entity.DebitingTime != nil {
+			if err := add(events.OperationDebited, entity, nil); err != nil {
+				return nil, err
+			}
+		}
+
+		if ok && (previous.Amount.Value != entity.Amount.Value || previous.Amount.CurrencyCode != entity.Amount.CurrencyCode) {
+			previousAmount := &amount{Value: previous.Amount.Value, CurrencyCode: previous.Amount.CurrencyCode}
+			if err := add(events.OperationAmountChanged, entity, previousAmount); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return outbox, nil
+}
+
+// accountEvents returns events of created and closed accounts. Accounts which are absent in the response
+// are closed, and are selected before they are marked as deleted.
+func accountEvents(tx database.DB, phone string, entities []Account, ids []string) ([]events.Event, error) {
+	var stored []string
+	if err := tx.Model(new(Account)).
+		Where("id in ?", ids).
+		Pluck("id", &stored).
+		Error; err != nil {
+		return nil, errors.Wrap(err, "select stored accounts")
+	}
+
+	var closed []Account
+	if err := tx.
+		Where("user_phone = ? and id not in ? and deleted = ?", phone, ids, false).
+		Find(&closed).
+		Error; err != nil {
+		return nil, errors.Wrap(err, "select closed accounts")
+	}
+
+	var outbox []events.Event
+	add := func(eventType string, entity *Account) error {
+		event, err := events.New(eventType, entity.Id, accountEvent{Account: entity})
+		if err != nil {
+			return err
+		}
+
+		outbox = append(outbox, event)
+		return nil
+	}
+
+	for i := range entities {
+		if !slices.Contains(stored, entities[i].Id) {
+			if err := add(events.AccountCreated, &entities[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for i := range closed {
+		if err := add(events.AccountClosed, &closed[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return outbox, nil
+}
@@ -27,6 +27,24 @@ func (l InvestAccounts) Load(ctx jobs.Context, client Client, db database.DB) (l
 		return
 	}
 
+	ids, errs := l.store(ctx, db, out)
+	if errs != nil {
+		return
+	}
+
+	for _, id := range ids {
+		ls = append(ls, investOperations{
+			accountId: id,
+			batchSize: l.BatchSize,
+			overlap:   l.Overlap,
+			now:       l.Now,
+		})
+	}
+
+	return
+}
+
+func (l InvestAccounts) store(ctx jobs.Context, db database.DB, out *tbank.InvestAccountsOut) (ids []string, errs error) {
 	if len(out.Accounts.List) == 0 {
 		return
 	}
@@ -36,7 +54,6 @@ func (l InvestAccounts) Load(ctx jobs.Context, client Client, db database.DB) (l
 		return
 	}
 
-	var ids []string
 	for i := range entities {
 		entity := &entities[i]
 		entity.UserPhone = l.Phone
@@ -61,15 +78,5 @@ func (l InvestAccounts) Load(ctx jobs.Context, client Client, db database.DB) (l
 	}
 
 	ctx.Info("updated entities in db", "count", len(ids))
-
-	for _, id := range ids {
-		ls = append(ls, investOperations{
-			accountId: id,
-			batchSize: l.BatchSize,
-			overlap:   l.Overlap,
-			now:       l.Now,
-		})
-	}
-
 	return
 }
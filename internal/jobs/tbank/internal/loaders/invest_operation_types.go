@@ -1,6 +1,8 @@
 package loaders
 
 import (
+	tbank "github.com/jfk9w-go/tbank-api"
+
 	"github.com/jfk9w/hoarder/internal/database"
 	"github.com/jfk9w/hoarder/internal/jobs"
 	. "github.com/jfk9w/hoarder/internal/jobs/tbank/internal/entities"
@@ -20,6 +22,11 @@ func (l InvestOperationTypes) Load(ctx jobs.Context, client Client, db database.
 		return
 	}
 
+	errs = l.store(ctx, db, out)
+	return
+}
+
+func (l InvestOperationTypes) store(ctx jobs.Context, db database.DB, out *tbank.InvestOperationTypesOut) (errs error) {
 	if len(out.OperationsTypes) == 0 {
 		return
 	}
@@ -81,26 +81,37 @@ func (l investOperationsBatch) load(ctx jobs.Context, cursor string, limit int)
 		return
 	}
 
+	if errs = storeInvestOperations(ctx, l.db, l.accountId, out); errs != nil {
+		return
+	}
+
+	if out.HasNext {
+		nextCursor = pointer.To(out.NextCursor)
+	}
+
+	return
+}
+
+func storeInvestOperations(ctx jobs.Context, db database.DB, accountId string, out *tbank.InvestOperationsOut) (errs error) {
+	if len(out.Items) == 0 {
+		return
+	}
+
 	entities, err := database.ToViaJSON[[]InvestOperation](out.Items)
 	if ctx.Error(&errs, err, "entity conversion failed") {
 		return
 	}
 
 	for i := range entities {
-		entities[i].InvestAccountId = l.accountId
+		entities[i].InvestAccountId = accountId
 	}
 
-	if err := l.db.WithContext(ctx).
+	if err := db.WithContext(ctx).
 		Upsert(entities).
 		Error; ctx.Error(&errs, err, "failed to update entities in db") {
 		return
 	}
 
-	if out.HasNext {
-		nextCursor = pointer.To(out.NextCursor)
-	}
-
 	ctx.Info("updated entities in db", "count", len(entities))
-
 	return
 }
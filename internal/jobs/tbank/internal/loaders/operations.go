@@ -6,6 +6,7 @@ import (
 	tbank "github.com/jfk9w-go/tbank-api"
 
 	"github.com/jfk9w/hoarder/internal/database"
+	"github.com/jfk9w/hoarder/internal/events"
 	"github.com/jfk9w/hoarder/internal/jobs"
 	. "github.com/jfk9w/hoarder/internal/jobs/tbank/internal/entities"
 )
@@ -14,6 +15,7 @@ type operations struct {
 	accountId string
 	batchSize int
 	overlap   time.Duration
+	outbox    events.Outbox
 }
 
 func (l operations) TableName() string {
@@ -59,6 +61,13 @@ func (l operations) Load(ctx jobs.Context, client Client, db database.DB) (ls []
 		return
 	}
 
+	errs = l.store(ctx, db, start, out)
+	return
+}
+
+// store updates operations received since start. Non-debited operations since start which are absent
+// in the response are deleted, since they may have changed their ids or been cancelled.
+func (l operations) store(ctx jobs.Context, db database.DB, start time.Time, out tbank.OperationsOut) (errs error) {
 	if len(out) == 0 {
 		return
 	}
@@ -69,6 +78,19 @@ func (l operations) Load(ctx jobs.Context, client Client, db database.DB) (ls []
 	}
 
 	if errs = db.WithContext(ctx).Transaction(func(tx database.DB) (errs error) {
+		var outbox []events.Event
+		if l.outbox.Enabled() {
+			stored, err := selectStoredOperations(tx, entities, l.batchSize)
+			if ctx.Error(&errs, err, "failed to select stored entities") {
+				return
+			}
+
+			outbox, err = operationEvents(stored, entities)
+			if ctx.Error(&errs, err, "failed to create events") {
+				return
+			}
+		}
+
 		if err := tx.
 			Where("account_id = ? and status = ? and debiting_time is null and operation_time >= ?", l.accountId, "OK", start).
 			Delete(new(Operation)).
@@ -80,6 +102,10 @@ func (l operations) Load(ctx jobs.Context, client Client, db database.DB) (ls []
 			return
 		}
 
+		if err := l.outbox.Write(tx, outbox); ctx.Error(&errs, err, "failed to write events") {
+			return
+		}
+
 		return
 	}); errs != nil {
 		return
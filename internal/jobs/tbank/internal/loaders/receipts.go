@@ -7,6 +7,7 @@ import (
 	tbank "github.com/jfk9w-go/tbank-api"
 
 	"github.com/jfk9w/hoarder/internal/database"
+	"github.com/jfk9w/hoarder/internal/events"
 	"github.com/jfk9w/hoarder/internal/jobs"
 	. "github.com/jfk9w/hoarder/internal/jobs/tbank/internal/entities"
 )
@@ -14,6 +15,7 @@ import (
 type receipts struct {
 	phone     string
 	batchSize int
+	outbox    events.Outbox
 }
 
 func (l receipts) TableName() string {
@@ -28,6 +30,7 @@ func (l receipts) Load(ctx jobs.Context, client Client, db database.DB) ([]Inter
 		phone:  l.phone,
 		client: client,
 		db:     db,
+		outbox: l.outbox,
 	}.load)
 }
 
@@ -35,6 +38,7 @@ type receiptsBatch struct {
 	phone  string
 	client Client
 	db     database.DB
+	outbox events.Outbox
 }
 
 func (l receiptsBatch) load(ctx jobs.Context, offset int, limit int) (nextOffset *int, errs error) {
@@ -61,14 +65,10 @@ func (l receiptsBatch) load(ctx jobs.Context, offset int, limit int) (nextOffset
 		out, err := l.client.ShoppingReceipt(ctx, &tbank.ShoppingReceiptIn{OperationId: id})
 		if err != nil {
 			if errors.Is(err, tbank.ErrNoDataFound) {
-				if err := l.db.WithContext(ctx).Model(new(Operation)).
-					Where("id = ?", id).
-					Update("has_shopping_receipt", false).
-					Error; ctx.Error(&errs, err, "failed to mark absent entity in db") {
+				if errs = markAbsentReceipt(ctx, l.db, id); errs != nil {
 					return
 				}
 
-				ctx.Info("marked absent entity in db")
 				continue
 			}
 
@@ -76,25 +76,57 @@ func (l receiptsBatch) load(ctx jobs.Context, offset int, limit int) (nextOffset
 			return
 		}
 
-		entity, err := database.ToViaJSON[Receipt](out.Receipt)
-		if ctx.Error(&errs, err, "entity conversion failed") {
+		if errs = storeReceipt(ctx, l.db, l.outbox, id, out); errs != nil {
 			return
 		}
+	}
 
-		entity.OperationId = id
+	if len(ids) == limit {
+		nextOffset = pointer.To(offset + limit)
+	}
+
+	return
+}
 
-		if err := l.db.WithContext(ctx).
-			Upsert(&entity).
-			Error; ctx.Error(&errs, err, "failed to update entities in db") {
+func storeReceipt(ctx jobs.Context, db database.DB, outbox events.Outbox, operationId string, out *tbank.ShoppingReceiptOut) (errs error) {
+	entity, err := database.ToViaJSON[Receipt](out.Receipt)
+	if ctx.Error(&errs, err, "entity conversion failed") {
+		return
+	}
+
+	entity.OperationId = operationId
+
+	event, err := events.New(events.ReceiptFetched, operationId, receiptEvent{OperationId: operationId, Receipt: &entity})
+	if ctx.Error(&errs, err, "failed to create event") {
+		return
+	}
+
+	if errs = db.WithContext(ctx).Transaction(func(tx database.DB) (errs error) {
+		if err := tx.Upsert(&entity).Error; ctx.Error(&errs, err, "failed to update entities in db") {
+			return
+		}
+
+		if err := outbox.Write(tx, []events.Event{event}); ctx.Error(&errs, err, "failed to write event") {
 			return
 		}
 
-		ctx.Info("updated entity in db")
+		return
+	}); errs != nil {
+		return
 	}
 
-	if len(ids) == limit {
-		nextOffset = pointer.To(offset + limit)
+	ctx.Info("updated entity in db")
+	return
+}
+
+func markAbsentReceipt(ctx jobs.Context, db database.DB, operationId string) (errs error) {
+	if err := db.WithContext(ctx).Model(new(Operation)).
+		Where("id = ?", operationId).
+		Update("has_shopping_receipt", false).
+		Error; ctx.Error(&errs, err, "failed to mark absent entity in db") {
+		return
 	}
 
+	ctx.Info("marked absent entity in db")
 	return
 }
@@ -0,0 +1,160 @@
+package loaders
+
+import (
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"time"
+
+	tbank "github.com/jfk9w-go/tbank-api"
+	"github.com/pkg/errors"
+
+	"github.com/jfk9w/hoarder/internal/database"
+	"github.com/jfk9w/hoarder/internal/events"
+	"github.com/jfk9w/hoarder/internal/jobs"
+	. "github.com/jfk9w/hoarder/internal/jobs/tbank/internal/entities"
+)
+
+const (
+	accountsEndpoint             = "/api/common/v1/accounts_light_ib"
+	accountRequisitesEndpoint    = "/api/common/v1/account_requisites"
+	statementsEndpoint           = "/api/common/v1/statements"
+	operationsEndpoint           = "/api/common/v1/operations"
+	shoppingReceiptEndpoint      = "/api/common/v1/shopping_receipt"
+	clientOfferEssencesEndpoint  = "/api/common/v1/client_offer_essences"
+	investOperationTypesEndpoint = "/api/invest-gw/ca-operations/api/v1/operations/types"
+	investAccountsEndpoint       = "/api/invest-gw/invest-portfolio/portfolios/accounts"
+	investOperationsEndpoint     = "/api/invest-gw/ca-operations/api/v1/user/operations"
+)
+
+// Archived checks if responses of the API endpoint (URL path) are stored by loaders
+// and so should be archived for reprocessing.
+func Archived(endpoint string) bool {
+	switch endpoint {
+	case accountsEndpoint,
+		accountRequisitesEndpoint,
+		statementsEndpoint,
+		operationsEndpoint,
+		shoppingReceiptEndpoint,
+		clientOfferEssencesEndpoint,
+		investOperationTypesEndpoint,
+		investAccountsEndpoint,
+		investOperationsEndpoint:
+		return true
+	default:
+		return false
+	}
+}
+
+type commonResponse[R any] struct {
+	ResultCode string `json:"resultCode"`
+	Payload    R      `json:"payload"`
+}
+
+// Reprocess updates entities from the archived API response body as loaders do with responses received from the API.
+// Unlike loaders, it does not request dependent entities, since their responses are archived separately.
+func Reprocess(ctx jobs.Context, db database.DB, batchSize int, outbox events.Outbox, response RawResponse, body []byte) (errs error) {
+	ctx = ctx.With("endpoint", response.Endpoint).With("fetched_at", response.FetchedAt)
+
+	params, err := url.ParseQuery(response.Params)
+	if ctx.Error(&errs, err, "failed to parse request params") {
+		return
+	}
+
+	switch response.Endpoint {
+	case accountsEndpoint:
+		out, resultCode, err := decodeCommon[tbank.AccountsLightIbOut](body)
+		if ctx.Error(&errs, err, "failed to decode response body") || resultCode != "OK" {
+			return
+		}
+
+		_, errs = Accounts{Phone: response.UserPhone, Outbox: outbox}.store(ctx, db, out)
+
+	case accountRequisitesEndpoint:
+		out, resultCode, err := decodeCommon[*tbank.AccountRequisitesOut](body)
+		if ctx.Error(&errs, err, "failed to decode response body") || resultCode != "OK" {
+			return
+		}
+
+		errs = accountRequisites{accountId: params.Get("account")}.store(ctx, db, out)
+
+	case statementsEndpoint:
+		out, resultCode, err := decodeCommon[tbank.StatementsOut](body)
+		if ctx.Error(&errs, err, "failed to decode response body") || resultCode != "OK" {
+			return
+		}
+
+		errs = statements{accountId: params.Get("account"), batchSize: batchSize}.store(ctx, db, out)
+
+	case operationsEndpoint:
+		start, err := strconv.ParseInt(params.Get("start"), 10, 64)
+		if ctx.Error(&errs, err, "failed to parse start") {
+			return
+		}
+
+		out, resultCode, err := decodeCommon[tbank.OperationsOut](body)
+		if ctx.Error(&errs, err, "failed to decode response body") || resultCode != "OK" {
+			return
+		}
+
+		errs = operations{accountId: params.Get("account"), batchSize: batchSize, outbox: outbox}.store(ctx, db, time.UnixMilli(start), out)
+
+	case shoppingReceiptEndpoint:
+		out, resultCode, err := decodeCommon[*tbank.ShoppingReceiptOut](body)
+		if ctx.Error(&errs, err, "failed to decode response body") {
+			return
+		}
+
+		operationId := params.Get("operationId")
+		switch {
+		case resultCode == "NO_DATA_FOUND":
+			errs = markAbsentReceipt(ctx, db, operationId)
+		case resultCode == "OK" && out != nil:
+			errs = storeReceipt(ctx, db, outbox, operationId, out)
+		}
+
+	case clientOfferEssencesEndpoint:
+		out, resultCode, err := decodeCommon[tbank.ClientOfferEssencesOut](body)
+		if ctx.Error(&errs, err, "failed to decode response body") || resultCode != "OK" {
+			return
+		}
+
+		errs = ClientOffers{Phone: response.UserPhone, BatchSize: batchSize}.store(ctx, db, out)
+
+	case investOperationTypesEndpoint:
+		var out tbank.InvestOperationTypesOut
+		if err := json.Unmarshal(body, &out); ctx.Error(&errs, err, "failed to decode response body") {
+			return
+		}
+
+		errs = InvestOperationTypes{BatchSize: batchSize}.store(ctx, db, &out)
+
+	case investAccountsEndpoint:
+		var out tbank.InvestAccountsOut
+		if err := json.Unmarshal(body, &out); ctx.Error(&errs, err, "failed to decode response body") {
+			return
+		}
+
+		_, errs = InvestAccounts{Phone: response.UserPhone}.store(ctx, db, &out)
+
+	case investOperationsEndpoint:
+		var out tbank.InvestOperationsOut
+		if err := json.Unmarshal(body, &out); ctx.Error(&errs, err, "failed to decode response body") {
+			return
+		}
+
+		errs = storeInvestOperations(ctx, db, params.Get("brokerAccountId"), &out)
+
+	default:
+		_ = ctx.Error(&errs, errors.New("unsupported endpoint"), "failed to reprocess response")
+	}
+
+	return
+}
+
+// decodeCommon decodes a common API response body and returns its payload along with the result code.
+func decodeCommon[R any](body []byte) (payload R, resultCode string, err error) {
+	var resp commonResponse[R]
+	err = json.Unmarshal(body, &resp)
+	return resp.Payload, resp.ResultCode, err
+}
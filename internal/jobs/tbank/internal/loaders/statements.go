@@ -25,6 +25,11 @@ func (l statements) Load(ctx jobs.Context, client Client, db database.DB) (ls []
 		return
 	}
 
+	errs = l.store(ctx, db, out)
+	return
+}
+
+func (l statements) store(ctx jobs.Context, db database.DB, out tbank.StatementsOut) (errs error) {
 	if len(out) == 0 {
 		return
 	}
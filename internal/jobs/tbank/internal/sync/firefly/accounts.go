@@ -3,6 +3,7 @@ package firefly
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/AlekSi/pointer"
 	"github.com/pkg/errors"
@@ -14,21 +15,32 @@ import (
 )
 
 type accounts struct {
-	phone     string
-	batchSize int
+	phone             string
+	batchSize         int
+	counterpartyRules []CounterpartyRule
+	tagRules          []TagRule
+	reconciliation    *Reconciliation
+	bills             bool
+	cashback          *Cashback
+	now               time.Time
+	since             time.Time
+	lookup            bool
 }
 
 func (s accounts) TableName() string {
 	return "accounts"
 }
 
-func (s accounts) Sync(ctx jobs.Context, db database.DB, client firefly.Invoker) (ss []Interface, errs error) {
+func (s accounts) Sync(ctx jobs.Context, db database.DB, client firefly.Instance) (ss []Interface, errs error) {
 	ctx = ctx.With("phone", s.phone)
 
+	mappings := mappings{db: db, instance: client.ID}
+
 	var entities []Account
 	if err := db.WithContext(ctx).
+		Scopes(mappings.mapped(new(Account).TableName(), "id")).
 		Where("user_phone = ?", s.phone).
-		Preload("Currency").
+		Preload("Currency", mappings.mapped(new(Currency).TableName(), "name")).
 		Find(&entities).
 		Error; ctx.Error(&errs, err, "failed to select records") {
 		return
@@ -36,10 +48,23 @@ func (s accounts) Sync(ctx jobs.Context, db database.DB, client firefly.Invoker)
 
 	for _, entity := range entities {
 		ctx := ctx.With("id", entity.Id)
+		status := new(transactionsStatus)
 		if entity.FireflyId != nil {
 			err := updateAccount(ctx, client, entity)
 			if !ctx.Error(&errs, err, "failed to update account") {
-				ss = append(ss, transactions{accountId: entity.Id, batchSize: s.batchSize})
+				ss = s.appendBills(ss, entity)
+				ss = append(ss, transactions{
+					accountId:         entity.Id,
+					batchSize:         s.batchSize,
+					counterpartyRules: s.counterpartyRules,
+					tagRules:          s.tagRules,
+					since:             s.since,
+					lookup:            s.lookup,
+					status:            status,
+				})
+
+				ss = s.appendCashback(ss, entity.Id, *entity.FireflyId)
+				ss = s.appendReconciliation(ss, entity.Id, *entity.FireflyId, status)
 			}
 
 			continue
@@ -50,20 +75,66 @@ func (s accounts) Sync(ctx jobs.Context, db database.DB, client firefly.Invoker)
 			continue
 		}
 
-		if err := db.WithContext(ctx).
-			Table(new(Account).TableName()).
-			Where("id = ?", entity.Id).
-			Update("firefly_id", fireflyId).
-			Error; ctx.Error(&errs, err, "failed to update firefly id in db") {
+		if err := mappings.save(ctx, entity.TableName(), entity.Id, fireflyId, nil); ctx.Error(&errs, err, "failed to update firefly id in db") {
 			continue
 		}
 
-		ss = append(ss, transactions{accountId: entity.Id, batchSize: s.batchSize})
+		ss = s.appendBills(ss, entity)
+		ss = append(ss, transactions{
+			accountId:         entity.Id,
+			batchSize:         s.batchSize,
+			counterpartyRules: s.counterpartyRules,
+			tagRules:          s.tagRules,
+			since:             s.since,
+			lookup:            s.lookup,
+			status:            status,
+		})
+
+		ss = s.appendCashback(ss, entity.Id, fireflyId)
+		ss = s.appendReconciliation(ss, entity.Id, fireflyId, status)
 	}
 
 	return
 }
 
+// appendBills maintains the bill of a credit account before its transactions are synced,
+// so that repayments are linked to the bill.
+func (s accounts) appendBills(ss []Interface, account Account) []Interface {
+	if !s.bills || account.AccountType != "Credit" {
+		return ss
+	}
+
+	return append(ss, bills{accountId: account.Id})
+}
+
+func (s accounts) appendCashback(ss []Interface, accountId, fireflyAccountId string) []Interface {
+	if s.cashback == nil {
+		return ss
+	}
+
+	return append(ss, cashback{
+		Cashback:         *s.cashback,
+		accountId:        accountId,
+		fireflyAccountId: fireflyAccountId,
+		now:              s.now,
+	})
+}
+
+// appendReconciliation compares balances after transactions of the account are synced.
+func (s accounts) appendReconciliation(ss []Interface, accountId, fireflyAccountId string, transactions *transactionsStatus) []Interface {
+	if s.reconciliation == nil {
+		return ss
+	}
+
+	return append(ss, reconciliation{
+		Reconciliation:   *s.reconciliation,
+		accountId:        accountId,
+		fireflyAccountId: fireflyAccountId,
+		now:              s.now,
+		transactions:     transactions,
+	})
+}
+
 func updateAccount(ctx context.Context, client firefly.Invoker, account Account) error {
 	in := &firefly.AccountUpdate{
 		Name:   getAccountName(account),
@@ -78,14 +149,23 @@ func updateAccount(ctx context.Context, client firefly.Invoker, account Account)
 	switch out := out.(type) {
 	case *firefly.AccountSingle:
 		return nil
-	case exception:
-		return exception2error(out)
+	case firefly.Exception:
+		return firefly.ExceptionError(out)
 	default:
 		return errors.Errorf("%s", out)
 	}
 }
 
 func storeAccount(ctx context.Context, client firefly.Invoker, account Account) (string, error) {
+	existing, err := findAssetAccount(ctx, client, account.Id)
+	if err != nil {
+		return "", errors.Wrap(err, "find account")
+	}
+
+	if existing != "" {
+		return existing, nil
+	}
+
 	in := &firefly.AccountStore{
 		Name:          getAccountName(account),
 		Type:          firefly.ShortAccountTypePropertyAsset,
@@ -122,8 +202,8 @@ func storeAccount(ctx context.Context, client firefly.Invoker, account Account)
 	switch out := out.(type) {
 	case *firefly.AccountSingle:
 		return out.Data.ID, nil
-	case exception:
-		return "", exception2error(out)
+	case firefly.Exception:
+		return "", firefly.ExceptionError(out)
 	default:
 		return "", errors.Errorf("%s", out)
 	}
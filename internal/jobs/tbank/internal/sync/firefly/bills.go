@@ -0,0 +1,227 @@
+package firefly
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/jfk9w/hoarder/internal/database"
+	"github.com/jfk9w/hoarder/internal/firefly"
+	"github.com/jfk9w/hoarder/internal/jobs"
+	. "github.com/jfk9w/hoarder/internal/jobs/tbank/internal/entities"
+)
+
+// billsEntity is the mapping entity for credit card bills in Firefly III.
+// Bills are keyed by the credit account id.
+const billsEntity = "bills"
+
+// bills maintains a monthly Firefly III bill for a credit account from its latest statement.
+// Bill amounts range from the minimal payment to the billed debt, and the bill date is the payment due date.
+// The bill is deactivated when there is no billed debt.
+type bills struct {
+	accountId string
+}
+
+func (s bills) TableName() string {
+	return billsEntity
+}
+
+func (s bills) Sync(ctx jobs.Context, db database.DB, client firefly.Instance) (_ []Interface, errs error) {
+	ctx = ctx.With("account_id", s.accountId)
+	mappings := mappings{db: db, instance: client.ID}
+
+	var account Account
+	if err := db.WithContext(ctx).
+		Preload("Currency", mappings.mapped(new(Currency).TableName(), "name")).
+		Where("id = ?", s.accountId).
+		First(&account).
+		Error; ctx.Error(&errs, err, "failed to select record") {
+		return
+	}
+
+	var statements []Statement
+	if err := db.WithContext(ctx).
+		Where("account_id = ?", s.accountId).
+		Order("date desc").
+		Limit(1).
+		Find(&statements).
+		Error; ctx.Error(&errs, err, "failed to select last statement") {
+		return
+	}
+
+	if len(statements) == 0 {
+		return
+	}
+
+	bill, ok := getBill(account, statements[0])
+	if !ok {
+		return
+	}
+
+	mapping, err := mappings.get(ctx, billsEntity, s.accountId)
+	if ctx.Error(&errs, err, "failed to select firefly id") {
+		return
+	}
+
+	hash := bill.hash()
+	if mapping != nil {
+		if mapping.Hash != nil && *mapping.Hash == hash {
+			return
+		}
+
+		found, err := updateBill(ctx, client, mapping.FireflyId, bill)
+		if ctx.Error(&errs, err, "failed to update bill") {
+			return
+		}
+
+		if found {
+			if err := mappings.updateHash(ctx, billsEntity, mapping.FireflyId, &hash); ctx.Error(&errs, err, "failed to update firefly hash in db") {
+				return
+			}
+
+			ctx.Debug("updated bill", "firefly_id", mapping.FireflyId)
+			return
+		}
+	}
+
+	if !bill.active {
+		return
+	}
+
+	fireflyId, err := storeBill(ctx, client, bill)
+	if ctx.Error(&errs, err, "failed to store bill") {
+		return
+	}
+
+	if err := mappings.save(ctx, billsEntity, s.accountId, fireflyId, &hash); ctx.Error(&errs, err, "failed to update firefly id in db") {
+		return
+	}
+
+	return
+}
+
+type bill struct {
+	name       string
+	currencyId *string
+	currency   string
+	amountMin  database.Money
+	amountMax  database.Money
+	dueDate    time.Time
+	active     bool
+}
+
+func getBill(account Account, statement Statement) (bill, bool) {
+	b := bill{name: "Выписка " + getAccountName(account)}
+	if currency := account.Currency; currency != nil {
+		b.currencyId = currency.FireflyId
+		b.currency = currency.Name
+	}
+
+	switch {
+	case statement.LastPaymentDate != nil:
+		b.dueDate = statement.LastPaymentDate.Time()
+	case account.DueDate != nil:
+		b.dueDate = account.DueDate.Time()
+	default:
+		return b, false
+	}
+
+	if debt := statement.BilledDebt; debt != nil && debt.BilledDebtValue > 0 {
+		b.active = true
+		b.amountMax = debt.BilledDebtValue
+		b.amountMin = b.amountMax
+		if payment := statement.MinimalPaymentAmount; payment != nil &&
+			payment.MinimalPaymentAmountValue > 0 && payment.MinimalPaymentAmountValue < b.amountMax {
+			b.amountMin = payment.MinimalPaymentAmountValue
+		}
+	}
+
+	return b, true
+}
+
+func (b bill) hash() string {
+	h := sha1.New()
+	_, _ = fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\x00%t",
+		b.name, b.amountMin, b.amountMax, b.dueDate.UTC().Format(time.RFC3339Nano), b.active)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// storeBill stores the bill. If a bill with the same name already exists, it is updated instead.
+func storeBill(ctx context.Context, client firefly.Invoker, bill bill) (string, error) {
+	existing, err := findBill(ctx, client, bill.name)
+	if err != nil {
+		return "", errors.Wrap(err, "find bill")
+	}
+
+	if existing != "" {
+		if _, err := updateBill(ctx, client, existing, bill); err != nil {
+			return "", errors.Wrap(err, "update bill")
+		}
+
+		return existing, nil
+	}
+
+	in := &firefly.BillStore{
+		Name:       bill.name,
+		AmountMin:  bill.amountMin.String(),
+		AmountMax:  bill.amountMax.String(),
+		Date:       bill.dueDate,
+		RepeatFreq: firefly.BillRepeatFrequencyMonthly,
+		Active:     firefly.NewOptBool(bill.active),
+	}
+
+	if bill.currencyId != nil {
+		in.CurrencyID = firefly.NewOptString(*bill.currencyId)
+	} else if bill.currency != "" {
+		in.CurrencyCode = firefly.NewOptString(bill.currency)
+	}
+
+	out, err := client.StoreBill(ctx, in, firefly.StoreBillParams{})
+	if err != nil {
+		return "", err
+	}
+
+	switch out := out.(type) {
+	case *firefly.BillSingle:
+		return out.Data.ID, nil
+	case firefly.Exception:
+		return "", firefly.ExceptionError(out)
+	default:
+		return "", errors.Errorf("%s", out)
+	}
+}
+
+// updateBill updates the bill and returns false if it no longer exists in Firefly III.
+// Amounts of inactive bills are kept intact, since Firefly III does not accept zero amounts.
+func updateBill(ctx context.Context, client firefly.Invoker, billId string, bill bill) (bool, error) {
+	in := &firefly.BillUpdate{
+		Name:   bill.name,
+		Date:   firefly.NewOptDateTime(bill.dueDate),
+		Active: firefly.NewOptBool(bill.active),
+	}
+
+	if bill.active {
+		in.AmountMin = firefly.NewOptString(bill.amountMin.String())
+		in.AmountMax = firefly.NewOptString(bill.amountMax.String())
+	}
+
+	out, err := client.UpdateBill(ctx, in, firefly.UpdateBillParams{ID: billId})
+	if err != nil {
+		return false, err
+	}
+
+	switch out := out.(type) {
+	case *firefly.BillSingle:
+		return true, nil
+	case *firefly.NotFound:
+		return false, nil
+	case firefly.Exception:
+		return false, firefly.ExceptionError(out)
+	default:
+		return false, errors.Errorf("%s", out)
+	}
+}
@@ -0,0 +1,188 @@
+package firefly
+
+import (
+	"context"
+	"time"
+
+	"github.com/jfk9w/hoarder/internal/database"
+	"github.com/jfk9w/hoarder/internal/firefly"
+	"github.com/jfk9w/hoarder/internal/jobs"
+	. "github.com/jfk9w/hoarder/internal/jobs/tbank/internal/entities"
+)
+
+// cashbackEntity is the mapping entity for cashback deposits in Firefly III.
+// Deposits are keyed by the statement id.
+const cashbackEntity = "cashback"
+
+// cashbackPayoutWindow is the period after the statement end in which the accrued cashback may be paid out.
+const cashbackPayoutWindow = 31 * 24 * time.Hour
+
+// Cashback configures booking of accrued cashback as income.
+type Cashback struct {
+	// Account is the name of the asset account receiving cashback.
+	// If empty, cashback is deposited into the card account.
+	Account string
+
+	// RevenueAccount is the name of the revenue account cashback comes from.
+	RevenueAccount string
+
+	// Category is the name of the category of cashback deposits.
+	Category string
+}
+
+// cashback books the cashback accrued for each closed statement of an account as a deposit.
+// The statement cashback is used if present, otherwise the cashback (or loyalty bonuses) of operations
+// within the statement period is summed up. Loyalty payments within the period are subtracted, since purchases
+// compensated by them are refunded to the card and synced as its operations.
+//
+// Cashback paid out as an operation of the account is already synced as income, so such statements are skipped.
+type cashback struct {
+	Cashback
+	accountId        string
+	fireflyAccountId string
+	now              time.Time
+}
+
+func (s cashback) TableName() string {
+	return cashbackEntity
+}
+
+func (s cashback) Sync(ctx jobs.Context, db database.DB, client firefly.Instance) (_ []Interface, errs error) {
+	ctx = ctx.With("account_id", s.accountId)
+	mappings := mappings{db: db, instance: client.ID}
+
+	var account Account
+	if err := db.WithContext(ctx).
+		Preload("Currency", mappings.mapped(new(Currency).TableName(), "name")).
+		Where("id = ?", s.accountId).
+		First(&account).
+		Error; ctx.Error(&errs, err, "failed to select record") {
+		return
+	}
+
+	var statements []Statement
+	if err := mappings.join(db.WithContext(ctx).Model(new(Statement)), "fm", cashbackEntity, "statements.id").
+		Where("account_id = ? and period_end <= ? and fm.firefly_id is null", s.accountId, s.now).
+		Order("date").
+		Find(&statements).
+		Error; ctx.Error(&errs, err, "failed to select pending records") {
+		return
+	}
+
+	for _, statement := range statements {
+		ctx := ctx.With("statement_id", statement.Id)
+		amount, err := s.getAmount(ctx, db, statement)
+		if ctx.Error(&errs, err, "failed to get cashback amount") || amount == 0 {
+			continue
+		}
+
+		paid, err := s.isPaid(ctx, db, statement, amount)
+		if ctx.Error(&errs, err, "failed to check cashback payout") {
+			continue
+		}
+
+		if paid {
+			ctx.Debug("skipping cashback paid out as an operation", "amount", amount.String())
+			continue
+		}
+
+		split := firefly.TransactionSplitStore{
+			Type:        firefly.TransactionTypePropertyDeposit,
+			Date:        statement.Period.End.Time(),
+			Amount:      amount.String(),
+			Description: "Кэшбэк по выписке от " + statement.Date.Time().Format(time.DateOnly),
+			SourceName:  firefly.NewOptNilString(s.RevenueAccount),
+			ExternalID:  firefly.NewOptNilString(reference(cashbackEntity, statement.Id)),
+		}
+
+		if s.Account != "" {
+			split.DestinationName = firefly.NewOptNilString(s.Account)
+		} else {
+			split.DestinationID = firefly.NewOptNilString(s.fireflyAccountId)
+		}
+
+		if amount < 0 {
+			// more bonuses were spent than accrued
+			split.Type = firefly.TransactionTypePropertyWithdrawal
+			split.Amount = (-amount).String()
+			split.SourceName, split.SourceID, split.DestinationName, split.DestinationID =
+				split.DestinationName, split.DestinationID, split.SourceName, firefly.OptNilString{}
+		}
+
+		if s.Category != "" {
+			split.CategoryName = firefly.NewOptNilString(s.Category)
+		}
+
+		if currency := account.Currency; currency != nil && currency.FireflyId != nil {
+			split.CurrencyID = firefly.NewOptNilString(*currency.FireflyId)
+		}
+
+		fireflyId, err := storeSplit(ctx, client, split)
+		if ctx.Error(&errs, err, "failed to store cashback") {
+			continue
+		}
+
+		if err := mappings.save(ctx, cashbackEntity, statement.Id, fireflyId, nil); ctx.Error(&errs, err, "failed to update firefly id in db") {
+			continue
+		}
+
+		ctx.Info("stored cashback", "amount", amount.String())
+	}
+
+	return
+}
+
+func (s cashback) getAmount(ctx context.Context, db database.DB, statement Statement) (database.Money, error) {
+	var operations []Operation
+	if err := db.WithContext(ctx).
+		Select("id", "cashback_value").
+		Preload("LoyaltyBonus").
+		Preload("LoyaltyPayment").
+		Where("account_id = ? and status = ?", s.accountId, "OK").
+		Where("operation_time >= ? and operation_time < ?", statement.Period.Start, statement.Period.End).
+		Find(&operations).
+		Error; err != nil {
+		return 0, err
+	}
+
+	var accrued, spent database.Money
+	for _, operation := range operations {
+		if operation.CashbackAmount.CashbackValue > 0 {
+			accrued += operation.CashbackAmount.CashbackValue
+		} else {
+			for _, bonus := range operation.LoyaltyBonus {
+				accrued += database.MoneyFromFloat(bonus.Amount.Value)
+			}
+		}
+
+		for _, payment := range operation.LoyaltyPayment {
+			spent += database.MoneyFromFloat(payment.Amount.Value)
+		}
+	}
+
+	if cashback := statement.Cashback; cashback != nil && cashback.CashbackValue > 0 {
+		accrued = cashback.CashbackValue
+	}
+
+	return accrued - spent, nil
+}
+
+// isPaid checks if the accrued cashback was paid out to the account as a credit operation after the statement end.
+func (s cashback) isPaid(ctx context.Context, db database.DB, statement Statement, amount database.Money) (bool, error) {
+	if amount <= 0 {
+		return false, nil
+	}
+
+	var count int64
+	if err := db.WithContext(ctx).
+		Model(new(Operation)).
+		Where("account_id = ? and status = ? and type = ? and account_value = ?", s.accountId, "OK", "Credit", amount).
+		Where("operation_time >= ? and operation_time < ?",
+			statement.Period.End, statement.Period.End.Time().Add(cashbackPayoutWindow)).
+		Count(&count).
+		Error; err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
@@ -17,10 +17,13 @@ func (s Categories) TableName() string {
 	return "categories"
 }
 
-func (s Categories) Sync(ctx jobs.Context, db database.DB, client firefly.Invoker) (ss []Interface, errs error) {
+func (s Categories) Sync(ctx jobs.Context, db database.DB, client firefly.Instance) (ss []Interface, errs error) {
+	mappings := mappings{db: db, instance: client.ID}
+
 	var entities []SpendingCategory
 	if err := db.WithContext(ctx).
-		Where("firefly_id is null").
+		Scopes(mappings.mapped(new(SpendingCategory).TableName(), "id")).
+		Where("fm.firefly_id is null").
 		Find(&entities).
 		Error; ctx.Error(&errs, err, "failed to select pending records") {
 		return
@@ -33,11 +36,7 @@ func (s Categories) Sync(ctx jobs.Context, db database.DB, client firefly.Invoke
 			continue
 		}
 
-		if err := db.WithContext(ctx).
-			Table(new(SpendingCategory).TableName()).
-			Where("id = ?", entity.Id).
-			Update("firefly_id", fireflyId).
-			Error; ctx.Error(&errs, err, "failed to update firefly id in db") {
+		if err := mappings.save(ctx, entity.TableName(), entity.Id, fireflyId, nil); ctx.Error(&errs, err, "failed to update firefly id in db") {
 			continue
 		}
 	}
@@ -46,6 +45,15 @@ func (s Categories) Sync(ctx jobs.Context, db database.DB, client firefly.Invoke
 }
 
 func storeCategory(ctx context.Context, client firefly.Invoker, category SpendingCategory) (string, error) {
+	existing, err := findCategory(ctx, client, category.Name)
+	if err != nil {
+		return "", errors.Wrap(err, "find category")
+	}
+
+	if existing != "" {
+		return existing, nil
+	}
+
 	in := &firefly.Category{
 		Name: category.Name,
 	}
@@ -58,8 +66,8 @@ func storeCategory(ctx context.Context, client firefly.Invoker, category Spendin
 	switch out := out.(type) {
 	case *firefly.CategorySingle:
 		return out.Data.ID, nil
-	case exception:
-		return "", exception2error(out)
+	case firefly.Exception:
+		return "", firefly.ExceptionError(out)
 	default:
 		return "", errors.Errorf("%s", out)
 	}
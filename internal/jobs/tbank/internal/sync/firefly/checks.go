@@ -0,0 +1,117 @@
+package firefly
+
+import (
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+
+	"github.com/jfk9w/hoarder/internal/database"
+	. "github.com/jfk9w/hoarder/internal/jobs/tbank/internal/entities"
+)
+
+// mappedTables lists mapping entities along with the tables and the columns of their keys.
+var mappedTables = []struct {
+	entity, table, key string
+}{
+	{new(Currency).TableName(), new(Currency).TableName(), "name"},
+	{new(SpendingCategory).TableName(), new(SpendingCategory).TableName(), "id"},
+	{new(Account).TableName(), new(Account).TableName(), "id"},
+	{billsEntity, new(Account).TableName(), "id"},
+	{cashbackEntity, new(Statement).TableName(), "id"},
+	{new(Operation).TableName(), new(Operation).TableName(), "id"},
+	{new(InvestAccount).TableName(), new(InvestAccount).TableName(), "id"},
+	{new(InvestOperation).TableName(), new(InvestOperation).TableName(), "internal_id"},
+}
+
+// Checks returns consistency checks of Firefly ids stored in the database.
+func Checks() []database.Check {
+	mappings := new(FireflyMapping).TableName()
+	checks := []database.Check{
+		database.QueryCheck("empty-firefly-ids", "firefly_mappings with empty firefly ids",
+			func(tx database.DB) *gorm.DB {
+				// pending transaction marks are stored without firefly ids
+				return tx.Model(new(FireflyMapping)).Where("firefly_id = '' and entity <> ?", pendingTransactionsEntity)
+			},
+			func(query *gorm.DB) *gorm.DB {
+				return query.Delete(new(FireflyMapping))
+			}),
+		database.QueryCheck("empty-firefly-ids", "balance_mismatches with empty firefly ids",
+			func(tx database.DB) *gorm.DB {
+				return tx.Model(new(BalanceMismatch)).Where("firefly_id = ''")
+			},
+			func(query *gorm.DB) *gorm.DB {
+				return query.Update("firefly_id", nil)
+			}),
+	}
+
+	for _, mapped := range mappedTables {
+		checks = append(checks, database.QueryCheck("stale-firefly-ids", "firefly_mappings for absent "+mapped.entity,
+			func(tx database.DB) *gorm.DB {
+				return tx.Model(new(FireflyMapping)).
+					Where("entity = ?", mapped.entity).
+					Where("not exists (select 1 from " + mapped.table + " where " +
+						mapped.table + "." + mapped.key + " = " + mappings + ".entity_key)")
+			},
+			func(query *gorm.DB) *gorm.DB {
+				return query.Delete(new(FireflyMapping))
+			}))
+	}
+
+	return append(checks,
+		database.Check{
+			Category:    "half-paired-transfers",
+			Description: "operations sharing firefly ids without being transfer pairs",
+			Run:         findHalfPairedTransfers,
+		},
+		database.QueryCheck("deleted-accounts", "operations of deleted accounts",
+			func(tx database.DB) *gorm.DB {
+				return tx.Model(new(Operation)).
+					Where("account_id in (?)", tx.Model(new(Account)).Select("id").Where("deleted = ?", true))
+			},
+			nil),
+	)
+}
+
+// findHalfPairedTransfers finds operations mapped to the same Firefly transaction which do not form a transfer pair
+// (see pairing), e.g. when one of the transfer operations was stored again as a separate transaction.
+// These are not repaired, since it is unknown which of the transactions in Firefly III is correct.
+func findHalfPairedTransfers(tx database.DB, _ bool) (found, repaired int64, err error) {
+	var shared []FireflyMapping
+	if err := tx.Table(new(FireflyMapping).TableName()+" as fm").
+		Where("fm.entity = ?", new(Operation).TableName()).
+		Where("exists (select 1 from firefly_mappings as fm2 where fm2.instance = fm.instance and " +
+			"fm2.entity = fm.entity and fm2.firefly_id = fm.firefly_id and fm2.entity_key <> fm.entity_key)").
+		Order("fm.instance, fm.firefly_id").
+		Find(&shared).
+		Error; err != nil {
+		return 0, 0, errors.Wrap(err, "select shared firefly ids")
+	}
+
+	for i := 0; i < len(shared); {
+		j := i + 1
+		for j < len(shared) && shared[j].Instance == shared[i].Instance && shared[j].FireflyId == shared[i].FireflyId {
+			j++
+		}
+
+		group := shared[i:j]
+		i = j
+
+		if len(group) == 2 {
+			var operations []Operation
+			if err := tx.
+				Where("id in ?", []string{group[0].Key, group[1].Key}).
+				Find(&operations).
+				Error; err != nil {
+				return 0, 0, errors.Wrap(err, "select operations")
+			}
+
+			if len(operations) == 2 &&
+				(isTransferPair(&operations[0], &operations[1]) || isTransferPair(&operations[1], &operations[0])) {
+				continue
+			}
+		}
+
+		found += int64(len(group))
+	}
+
+	return
+}
@@ -1,6 +1,8 @@
 package firefly
 
 import (
+	"time"
+
 	"go.uber.org/multierr"
 
 	"github.com/jfk9w/hoarder/internal/database"
@@ -9,15 +11,32 @@ import (
 )
 
 type All struct {
-	Phones    []string
-	BatchSize int
+	Phones               []string
+	BatchSize            int
+	InvestOperationKinds map[string]InvestOperationKind
+	RevaluationAccount   string
+	CounterpartyRules    []CounterpartyRule
+	TagRules             []TagRule
+	Reconciliation       *Reconciliation
+	Bills                bool
+	Cashback             *Cashback
+	Now                  time.Time
+
+	// Since limits synced operations to the ones which are not synced yet, were forced to update
+	// or may have changed since then (by operation or debiting time). Zero value means all operations.
+	Since time.Time
+
+	// Lookup enables looking up every bank transaction by external id before storing it,
+	// which recovers Firefly ids lost with the database. Otherwise only transactions left
+	// pending by an interrupted sync are looked up.
+	Lookup bool
 }
 
 func (s All) TableName() string {
 	return "base"
 }
 
-func (s All) Sync(ctx jobs.Context, db database.DB, client firefly.Invoker) (ls []Interface, errs error) {
+func (s All) Sync(ctx jobs.Context, db database.DB, client firefly.Instance) (ls []Interface, errs error) {
 	for _, sync := range []Interface{
 		Categories{},
 		Currencies{},
@@ -33,8 +52,26 @@ func (s All) Sync(ctx jobs.Context, db database.DB, client firefly.Invoker) (ls
 
 	for _, phone := range s.Phones {
 		ls = append(ls, accounts{
-			phone:     phone,
-			batchSize: s.BatchSize,
+			phone:             phone,
+			batchSize:         s.BatchSize,
+			counterpartyRules: s.CounterpartyRules,
+			tagRules:          s.TagRules,
+			reconciliation:    s.Reconciliation,
+			bills:             s.Bills,
+			cashback:          s.Cashback,
+			now:               s.Now,
+			since:             s.Since,
+			lookup:            s.Lookup,
+		})
+	}
+
+	for _, phone := range s.Phones {
+		ls = append(ls, investAccounts{
+			phone:              phone,
+			batchSize:          s.BatchSize,
+			kinds:              s.InvestOperationKinds,
+			revaluationAccount: s.RevaluationAccount,
+			now:                s.Now,
 		})
 	}
 
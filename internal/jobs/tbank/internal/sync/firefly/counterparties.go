@@ -0,0 +1,131 @@
+package firefly
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/jfk9w/hoarder/internal/firefly"
+)
+
+// CounterpartyRule replaces counterparty names matching Pattern with Name.
+type CounterpartyRule struct {
+	Pattern *regexp.Regexp
+	Name    string
+}
+
+// NormalizeCounterparty collapses whitespace in the name and applies the first matching rule.
+func NormalizeCounterparty(rules []CounterpartyRule, name string) string {
+	name = strings.Join(strings.Fields(name), " ")
+	if name == "" {
+		return ""
+	}
+
+	for _, rule := range rules {
+		if rule.Pattern.MatchString(name) {
+			return rule.Name
+		}
+	}
+
+	return name
+}
+
+type counterpartyKey struct {
+	name        string
+	accountType firefly.ShortAccountTypeProperty
+}
+
+// String returns the key of the counterparty in Firefly ids mappings.
+func (k counterpartyKey) String() string {
+	return string(k.accountType) + ":" + k.name
+}
+
+// counterparties resolves counterparty names to expense and revenue accounts in Firefly III.
+// Resolved accounts are cached in Firefly ids mappings.
+type counterparties struct {
+	mappings mappings
+	client   firefly.Invoker
+	rules    []CounterpartyRule
+	cache    map[counterpartyKey]string
+}
+
+func (c *counterparties) resolve(ctx context.Context, name string, accountType firefly.ShortAccountTypeProperty) (string, error) {
+	key := counterpartyKey{name: name, accountType: accountType}
+	if id, ok := c.cache[key]; ok {
+		return id, nil
+	}
+
+	mapping, err := c.mappings.get(ctx, counterpartiesEntity, key.String())
+	if err != nil {
+		return "", errors.Wrap(err, "select from db")
+	}
+
+	var id string
+	if mapping != nil {
+		id = mapping.FireflyId
+	} else {
+		id, err = ensureCounterpartyAccount(ctx, c.client, name, accountType)
+		if err != nil {
+			return "", err
+		}
+
+		if err := c.mappings.save(ctx, counterpartiesEntity, key.String(), id, nil); err != nil {
+			return "", errors.Wrap(err, "update firefly id in db")
+		}
+	}
+
+	if c.cache == nil {
+		c.cache = make(map[counterpartyKey]string)
+	}
+
+	c.cache[key] = id
+	return id, nil
+}
+
+func ensureCounterpartyAccount(ctx context.Context, client firefly.Invoker, name string, accountType firefly.ShortAccountTypeProperty) (string, error) {
+	out, err := client.SearchAccounts(ctx, firefly.SearchAccountsParams{
+		Query: name,
+		Type:  firefly.NewOptAccountTypeFilter(firefly.AccountTypeFilter(accountType)),
+		Field: firefly.AccountSearchFieldFilterName,
+	})
+
+	if err != nil {
+		return "", errors.Wrap(err, "search account")
+	}
+
+	switch out := out.(type) {
+	case *firefly.AccountArray:
+		for _, account := range out.Data {
+			if account.Attributes.Name == name {
+				return account.ID, nil
+			}
+		}
+	case *firefly.NotFound:
+	case firefly.Exception:
+		return "", firefly.ExceptionError(out)
+	default:
+		return "", errors.Errorf("%s", out)
+	}
+
+	in := &firefly.AccountStore{
+		Name:   name,
+		Type:   accountType,
+		Active: firefly.NewOptBool(true),
+	}
+
+	stored, err := client.StoreAccount(ctx, in, firefly.StoreAccountParams{})
+	if err != nil {
+		return "", errors.Wrap(err, "store account")
+	}
+
+	switch stored := stored.(type) {
+	case *firefly.AccountSingle:
+		return stored.Data.ID, nil
+	case firefly.Exception:
+		return "", firefly.ExceptionError(stored)
+	default:
+		return "", errors.Errorf("%s", stored)
+	}
+}
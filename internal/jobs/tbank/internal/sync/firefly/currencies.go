@@ -17,10 +17,13 @@ func (s Currencies) TableName() string {
 	return "currencies"
 }
 
-func (s Currencies) Sync(ctx jobs.Context, db database.DB, client firefly.Invoker) (ss []Interface, errs error) {
+func (s Currencies) Sync(ctx jobs.Context, db database.DB, client firefly.Instance) (ss []Interface, errs error) {
+	mappings := mappings{db: db, instance: client.ID}
+
 	var entities []Currency
 	if err := db.WithContext(ctx).
-		Where("firefly_id is null").
+		Scopes(mappings.mapped(new(Currency).TableName(), "name")).
+		Where("fm.firefly_id is null").
 		Find(&entities).
 		Error; ctx.Error(&errs, err, "failed to select pending records") {
 		return
@@ -33,11 +36,7 @@ func (s Currencies) Sync(ctx jobs.Context, db database.DB, client firefly.Invoke
 			continue
 		}
 
-		if err := db.WithContext(ctx).
-			Table(new(Currency).TableName()).
-			Where("code = ?", entity.Code).
-			Update("firefly_id", fireflyId).
-			Error; ctx.Error(&errs, err, "failed to update firefly id in db") {
+		if err := mappings.save(ctx, entity.TableName(), entity.Name, fireflyId, nil); ctx.Error(&errs, err, "failed to update firefly id in db") {
 			continue
 		}
 	}
@@ -78,8 +77,8 @@ func storeCurrency(ctx context.Context, client firefly.Invoker, currency Currenc
 	switch out := out.(type) {
 	case *firefly.CurrencySingle:
 		return out.Data.ID, nil
-	case exception:
-		return "", exception2error(out)
+	case firefly.Exception:
+		return "", firefly.ExceptionError(out)
 	default:
 		return "", errors.Errorf("%s", out)
 	}
@@ -98,8 +97,8 @@ func enableCurrency(ctx context.Context, client firefly.Invoker, code string) er
 	switch out := out.(type) {
 	case *firefly.CurrencySingle:
 		return nil
-	case exception:
-		return exception2error(out)
+	case firefly.Exception:
+		return firefly.ExceptionError(out)
 	default:
 		return errors.Errorf("%s", out)
 	}
@@ -116,8 +115,8 @@ func getCurrency(ctx context.Context, client firefly.Invoker, code string) (*fir
 		return out, nil
 	case *firefly.NotFound:
 		return nil, nil
-	case exception:
-		return nil, exception2error(out)
+	case firefly.Exception:
+		return nil, firefly.ExceptionError(out)
 	default:
 		return nil, errors.Errorf("%s", out)
 	}
@@ -0,0 +1,64 @@
+package firefly
+
+const investCurrencyCode = "RUB"
+
+type InvestOperationKind string
+
+const (
+	// InvestPayIn is a transfer from a bank account to a broker account.
+	InvestPayIn InvestOperationKind = "payIn"
+
+	// InvestPayOut is a transfer from a broker account to a bank account.
+	InvestPayOut InvestOperationKind = "payOut"
+
+	// InvestIncome is booked as a deposit to a broker account.
+	InvestIncome InvestOperationKind = "income"
+
+	// InvestExpense is booked as a withdrawal from a broker account.
+	InvestExpense InvestOperationKind = "expense"
+)
+
+func (InvestOperationKind) SchemaEnum() any {
+	return []InvestOperationKind{
+		InvestPayIn,
+		InvestPayOut,
+		InvestIncome,
+		InvestExpense,
+	}
+}
+
+var defaultInvestOperationKinds = map[string]InvestOperationKind{
+	"PayIn":              InvestPayIn,
+	"PayOut":             InvestPayOut,
+	"Dividend":           InvestIncome,
+	"Coupon":             InvestIncome,
+	"TaxBack":            InvestIncome,
+	"BrokerCommission":   InvestExpense,
+	"ExchangeCommission": InvestExpense,
+	"ServiceCommission":  InvestExpense,
+	"MarginCommission":   InvestExpense,
+	"OtherCommission":    InvestExpense,
+	"Tax":                InvestExpense,
+	"TaxDividend":        InvestExpense,
+	"TaxCoupon":          InvestExpense,
+	"TaxLucre":           InvestExpense,
+}
+
+// InvestOperationKinds merges overrides into the default operation type mapping.
+func InvestOperationKinds(overrides map[string]InvestOperationKind) map[string]InvestOperationKind {
+	kinds := make(map[string]InvestOperationKind, len(defaultInvestOperationKinds)+len(overrides))
+	for operationType, kind := range defaultInvestOperationKinds {
+		kinds[operationType] = kind
+	}
+
+	for operationType, kind := range overrides {
+		if kind == "" {
+			delete(kinds, operationType)
+			continue
+		}
+
+		kinds[operationType] = kind
+	}
+
+	return kinds
+}
@@ -0,0 +1,136 @@
+package firefly
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/AlekSi/pointer"
+	"github.com/pkg/errors"
+
+	"github.com/jfk9w/hoarder/internal/database"
+	"github.com/jfk9w/hoarder/internal/firefly"
+	"github.com/jfk9w/hoarder/internal/jobs"
+	. "github.com/jfk9w/hoarder/internal/jobs/tbank/internal/entities"
+)
+
+type investAccounts struct {
+	phone              string
+	batchSize          int
+	kinds              map[string]InvestOperationKind
+	revaluationAccount string
+	now                time.Time
+}
+
+func (s investAccounts) TableName() string {
+	return new(InvestAccount).TableName()
+}
+
+func (s investAccounts) Sync(ctx jobs.Context, db database.DB, client firefly.Instance) (ss []Interface, errs error) {
+	ctx = ctx.With("phone", s.phone)
+
+	mappings := mappings{db: db, instance: client.ID}
+
+	var entities []InvestAccount
+	if err := db.WithContext(ctx).
+		Scopes(mappings.mapped(new(InvestAccount).TableName(), "id")).
+		Where("user_phone = ?", s.phone).
+		Find(&entities).
+		Error; ctx.Error(&errs, err, "failed to select records") {
+		return
+	}
+
+	for _, entity := range entities {
+		ctx := ctx.With("id", entity.Id)
+		if entity.FireflyId == nil {
+			fireflyId, err := storeInvestAccount(ctx, client, entity)
+			if ctx.Error(&errs, err, "failed to store account") {
+				continue
+			}
+
+			if err := mappings.save(ctx, entity.TableName(), entity.Id, fireflyId, nil); ctx.Error(&errs, err, "failed to update firefly id in db") {
+				continue
+			}
+
+			entity.FireflyId = &fireflyId
+		} else if err := updateInvestAccount(ctx, client, entity); ctx.Error(&errs, err, "failed to update account") {
+			continue
+		}
+
+		ss = append(ss,
+			investOperations{
+				phone:            s.phone,
+				accountId:        entity.Id,
+				fireflyAccountId: *entity.FireflyId,
+				batchSize:        s.batchSize,
+				kinds:            s.kinds,
+			},
+			investRevaluation{
+				accountId:          entity.Id,
+				fireflyAccountId:   *entity.FireflyId,
+				revaluationAccount: s.revaluationAccount,
+				now:                s.now,
+			})
+	}
+
+	return
+}
+
+func updateInvestAccount(ctx context.Context, client firefly.Invoker, account InvestAccount) error {
+	in := &firefly.AccountUpdate{
+		Name:   getInvestAccountName(account),
+		Active: firefly.NewOptBool(!account.Deleted),
+	}
+
+	out, err := client.UpdateAccount(ctx, in, firefly.UpdateAccountParams{ID: pointer.Get(account.FireflyId)})
+	if err != nil {
+		return err
+	}
+
+	switch out := out.(type) {
+	case *firefly.AccountSingle:
+		return nil
+	case firefly.Exception:
+		return firefly.ExceptionError(out)
+	default:
+		return errors.Errorf("%s", out)
+	}
+}
+
+func storeInvestAccount(ctx context.Context, client firefly.Invoker, account InvestAccount) (string, error) {
+	existing, err := findAssetAccount(ctx, client, account.Id)
+	if err != nil {
+		return "", errors.Wrap(err, "find account")
+	}
+
+	if existing != "" {
+		return existing, nil
+	}
+
+	in := &firefly.AccountStore{
+		Name:          getInvestAccountName(account),
+		Type:          firefly.ShortAccountTypePropertyAsset,
+		AccountNumber: firefly.NewOptNilString(account.Id),
+		AccountRole:   firefly.NewOptNilAccountRoleProperty(firefly.AccountRolePropertyDefaultAsset),
+		CurrencyCode:  firefly.NewOptString(investCurrencyCode),
+		Active:        firefly.NewOptBool(!account.Deleted),
+	}
+
+	out, err := client.StoreAccount(ctx, in, firefly.StoreAccountParams{})
+	if err != nil {
+		return "", err
+	}
+
+	switch out := out.(type) {
+	case *firefly.AccountSingle:
+		return out.Data.ID, nil
+	case firefly.Exception:
+		return "", firefly.ExceptionError(out)
+	default:
+		return "", errors.Errorf("%s", out)
+	}
+}
+
+func getInvestAccountName(account InvestAccount) string {
+	return fmt.Sprintf("%s (%s)", account.Name, account.Id)
+}
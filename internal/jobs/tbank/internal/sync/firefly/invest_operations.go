@@ -0,0 +1,276 @@
+package firefly
+
+import (
+	"context"
+	"time"
+
+	"github.com/AlekSi/pointer"
+	"github.com/pkg/errors"
+
+	"github.com/jfk9w/hoarder/internal/database"
+	"github.com/jfk9w/hoarder/internal/firefly"
+	"github.com/jfk9w/hoarder/internal/jobs"
+	. "github.com/jfk9w/hoarder/internal/jobs/tbank/internal/entities"
+)
+
+// investTransferWindow is the maximum time difference between a broker pay-in or pay-out
+// and the matching bank account operation.
+const investTransferWindow = 24 * time.Hour
+
+type investOperationRow struct {
+	InternalId      string
+	Date            time.Time
+	Description     string
+	Type            string
+	PaymentRubValue database.Money
+	ShowName        *string
+	OperationName   *string
+}
+
+type bankOperationRow struct {
+	Id               string
+	FireflyId        *string
+	FireflyAccountId string
+}
+
+type investOperations struct {
+	phone            string
+	accountId        string
+	fireflyAccountId string
+	batchSize        int
+	kinds            map[string]InvestOperationKind
+}
+
+func (s investOperations) TableName() string {
+	return new(InvestOperation).TableName()
+}
+
+func (s investOperations) Sync(ctx jobs.Context, db database.DB, client firefly.Instance) ([]Interface, error) {
+	ctx = ctx.With("account_id", s.accountId)
+	if len(s.kinds) == 0 {
+		return nil, nil
+	}
+
+	return nil, jobs.Batch[string]{
+		Key:  "after",
+		Size: s.batchSize,
+	}.Run(ctx, investOperationsBatch{
+		investOperations: s,
+		db:               db,
+		client:           client,
+		mappings:         mappings{db: db, instance: client.ID},
+	}.sync)
+}
+
+type investOperationsBatch struct {
+	investOperations
+	db       database.DB
+	client   firefly.Invoker
+	mappings mappings
+}
+
+func (s investOperationsBatch) sync(ctx jobs.Context, after string, limit int) (nextAfter *string, errs error) {
+	types := make([]string, 0, len(s.kinds))
+	for operationType := range s.kinds {
+		types = append(types, operationType)
+	}
+
+	query := s.db.WithContext(ctx).
+		Table(new(InvestOperation).TableName() + " as io").
+		Select("io.internal_id, io.date, io.description, io.type, io.payment_rub_value, io.show_name, t.operation_name").
+		Joins("left join " + new(InvestOperationType).TableName() + " as t on t.operation_type = io.type")
+
+	var rows []investOperationRow
+	if err := s.mappings.join(query, "fm", new(InvestOperation).TableName(), "io.internal_id").
+		Where("io.invest_account_id = ? and fm.firefly_id is null and lower(io.status) = ? and io.type in ? and io.internal_id > ?",
+			s.accountId, "done", types, after).
+		Order("io.internal_id").
+		Limit(limit).
+		Scan(&rows).
+		Error; ctx.Error(&errs, err, "failed to query operations") {
+		return
+	}
+
+	for _, row := range rows {
+		ctx := ctx.With("internal_id", row.InternalId)
+		if err := s.syncOperation(ctx, row); ctx.Error(&errs, err, "failed to sync operation") {
+			continue
+		}
+	}
+
+	if len(rows) == limit {
+		nextAfter = pointer.To(rows[len(rows)-1].InternalId)
+	}
+
+	return
+}
+
+func (s investOperationsBatch) syncOperation(ctx jobs.Context, row investOperationRow) error {
+	amount := row.PaymentRubValue
+	if amount < 0 {
+		amount = -amount
+	}
+
+	if amount == 0 {
+		return nil
+	}
+
+	split := firefly.TransactionSplitStore{
+		Date:         row.Date,
+		Amount:       amount.String(),
+		Description:  row.Description,
+		CurrencyCode: firefly.NewOptNilString(investCurrencyCode),
+		ExternalID:   firefly.NewOptNilString(reference(new(InvestOperation).TableName(), row.InternalId)),
+	}
+
+	if name := pointer.Get(row.OperationName); name != "" {
+		split.CategoryName = firefly.NewOptNilString(name)
+	}
+
+	counterparty := pointer.Get(row.ShowName)
+	if counterparty == "" {
+		counterparty = row.Description
+	}
+
+	var bankOperation *bankOperationRow
+	switch kind := s.kinds[row.Type]; kind {
+	case InvestPayIn, InvestPayOut:
+		bankOperationType := "Debit"
+		if kind == InvestPayOut {
+			bankOperationType = "Credit"
+		}
+
+		var err error
+		bankOperation, err = s.findBankOperation(ctx, bankOperationType, amount, row.Date)
+		if err != nil {
+			return errors.Wrap(err, "find bank operation")
+		}
+
+		switch {
+		case bankOperation == nil && kind == InvestPayIn:
+			split.Type = firefly.TransactionTypePropertyDeposit
+			split.SourceName = firefly.NewOptNilString(counterparty)
+			split.DestinationID = firefly.NewOptNilString(s.fireflyAccountId)
+		case bankOperation == nil:
+			split.Type = firefly.TransactionTypePropertyWithdrawal
+			split.SourceID = firefly.NewOptNilString(s.fireflyAccountId)
+			split.DestinationName = firefly.NewOptNilString(counterparty)
+		case kind == InvestPayIn:
+			split.Type = firefly.TransactionTypePropertyTransfer
+			split.SourceID = firefly.NewOptNilString(bankOperation.FireflyAccountId)
+			split.DestinationID = firefly.NewOptNilString(s.fireflyAccountId)
+		default:
+			split.Type = firefly.TransactionTypePropertyTransfer
+			split.SourceID = firefly.NewOptNilString(s.fireflyAccountId)
+			split.DestinationID = firefly.NewOptNilString(bankOperation.FireflyAccountId)
+		}
+
+		if split.Type == firefly.TransactionTypePropertyTransfer {
+			split.CategoryName = firefly.OptNilString{}
+		}
+
+	case InvestIncome:
+		split.Type = firefly.TransactionTypePropertyDeposit
+		split.SourceName = firefly.NewOptNilString(counterparty)
+		split.DestinationID = firefly.NewOptNilString(s.fireflyAccountId)
+
+	case InvestExpense:
+		split.Type = firefly.TransactionTypePropertyWithdrawal
+		split.SourceID = firefly.NewOptNilString(s.fireflyAccountId)
+		split.DestinationName = firefly.NewOptNilString(counterparty)
+
+	default:
+		return errors.Errorf("unsupported operation kind: %s", kind)
+	}
+
+	if bankOperation != nil && bankOperation.FireflyId != nil {
+		if err := deleteTransaction(ctx, s.client, *bankOperation.FireflyId); err != nil {
+			return errors.Wrap(err, "delete bank transaction")
+		}
+	}
+
+	fireflyId, err := storeSplit(ctx, s.client, split)
+	if err != nil {
+		return errors.Wrap(err, "store transaction")
+	}
+
+	return s.db.WithContext(ctx).Transaction(func(tx database.DB) error {
+		mappings := mappings{db: tx, instance: s.mappings.instance}
+		if bankOperation != nil {
+			if err := mappings.save(ctx, new(Operation).TableName(), bankOperation.Id, fireflyId, nil); err != nil {
+				return errors.Wrap(err, "update bank operation firefly id in db")
+			}
+		}
+
+		if err := mappings.save(ctx, new(InvestOperation).TableName(), row.InternalId, fireflyId, nil); err != nil {
+			return errors.Wrap(err, "update firefly id in db")
+		}
+
+		return nil
+	})
+}
+
+func (s investOperationsBatch) findBankOperation(ctx context.Context, operationType string, amount database.Money, date time.Time) (*bankOperationRow, error) {
+	query := s.db.WithContext(ctx).
+		Table(new(Operation).TableName() + " as o").
+		Select("o.id, fm.firefly_id, fa.firefly_id as firefly_account_id").
+		Joins("inner join " + new(Account).TableName() + " as a on a.id = o.account_id")
+	query = s.mappings.join(query, "fa", new(Account).TableName(), "a.id")
+	query = s.mappings.join(query, "fm", new(Operation).TableName(), "o.id")
+
+	var rows []bankOperationRow
+	if err := query.
+		Where("a.user_phone = ? and fa.firefly_id is not null", s.phone).
+		Where("o.status = ? and o.type = ? and o.account_value = ?", "OK", operationType, amount).
+		Where("o.operation_time between ? and ?", date.Add(-investTransferWindow), date.Add(investTransferWindow)).
+		Where("fm.firefly_id is null or "+
+			"not exists (select 1 from firefly_mappings as fm2 where fm2.instance = fm.instance and fm2.entity = fm.entity and "+
+			"fm2.firefly_id = fm.firefly_id and fm2.entity_key <> fm.entity_key) and "+
+			"not exists (select 1 from firefly_mappings as fm3 where fm3.instance = fm.instance and fm3.entity = ? and "+
+			"fm3.firefly_id = fm.firefly_id)", new(InvestOperation).TableName()).
+		Order("o.operation_time").
+		Limit(1).
+		Scan(&rows).
+		Error; err != nil {
+		return nil, err
+	}
+
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	return &rows[0], nil
+}
+
+// storeSplit stores the transaction.
+// If the split has an external id, the transaction is looked up by it first and the existing one is returned if found.
+func storeSplit(ctx context.Context, client firefly.Invoker, split firefly.TransactionSplitStore) (string, error) {
+	if externalId := split.ExternalID.Or(""); externalId != "" {
+		existing, err := findTransaction(ctx, client, externalId)
+		if err != nil {
+			return "", errors.Wrap(err, "find transaction")
+		}
+
+		if existing != "" {
+			return existing, nil
+		}
+	}
+
+	in := &firefly.TransactionStore{
+		Transactions: []firefly.TransactionSplitStore{split},
+	}
+
+	out, err := client.StoreTransaction(ctx, in, firefly.StoreTransactionParams{})
+	if err != nil {
+		return "", err
+	}
+
+	switch out := out.(type) {
+	case *firefly.TransactionSingle:
+		return out.Data.ID, nil
+	case firefly.Exception:
+		return "", firefly.ExceptionError(out)
+	default:
+		return "", errors.Errorf("%s", out)
+	}
+}
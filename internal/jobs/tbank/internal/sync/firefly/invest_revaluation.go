@@ -0,0 +1,94 @@
+package firefly
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/jfk9w/hoarder/internal/database"
+	"github.com/jfk9w/hoarder/internal/firefly"
+	"github.com/jfk9w/hoarder/internal/jobs"
+	. "github.com/jfk9w/hoarder/internal/jobs/tbank/internal/entities"
+)
+
+// investRevaluation books the difference between InvestTotals and the Firefly account balance,
+// so that the broker account balance in Firefly follows the portfolio value.
+type investRevaluation struct {
+	accountId          string
+	fireflyAccountId   string
+	revaluationAccount string
+	now                time.Time
+}
+
+func (s investRevaluation) TableName() string {
+	return "invest_revaluation"
+}
+
+func (s investRevaluation) Sync(ctx jobs.Context, db database.DB, client firefly.Instance) (_ []Interface, errs error) {
+	ctx = ctx.With("account_id", s.accountId)
+
+	var entity InvestAccount
+	if err := db.WithContext(ctx).
+		Where("id = ?", s.accountId).
+		First(&entity).
+		Error; ctx.Error(&errs, err, "failed to select record") {
+		return
+	}
+
+	if entity.Deleted || entity.TotalAmount.Currency == "" {
+		return
+	}
+
+	balance, err := getAccountBalance(ctx, client, s.fireflyAccountId, s.now)
+	if ctx.Error(&errs, err, "failed to get account balance") {
+		return
+	}
+
+	diff := entity.TotalAmount.Value - balance
+	if diff == 0 {
+		return
+	}
+
+	split := firefly.TransactionSplitStore{
+		Date:         s.now,
+		Description:  "Переоценка",
+		CurrencyCode: firefly.NewOptNilString(investCurrencyCode),
+	}
+
+	if diff > 0 {
+		split.Type = firefly.TransactionTypePropertyDeposit
+		split.Amount = diff.String()
+		split.SourceName = firefly.NewOptNilString(s.revaluationAccount)
+		split.DestinationID = firefly.NewOptNilString(s.fireflyAccountId)
+	} else {
+		split.Type = firefly.TransactionTypePropertyWithdrawal
+		split.Amount = (-diff).String()
+		split.SourceID = firefly.NewOptNilString(s.fireflyAccountId)
+		split.DestinationName = firefly.NewOptNilString(s.revaluationAccount)
+	}
+
+	_, err = storeSplit(ctx, client, split)
+	if ctx.Error(&errs, err, "failed to store revaluation") {
+		return
+	}
+
+	ctx.Info("stored revaluation", "amount", diff.String())
+	return
+}
+
+func getAccountBalance(ctx context.Context, client firefly.Invoker, accountId string, date time.Time) (database.Money, error) {
+	out, err := client.GetAccount(ctx, firefly.GetAccountParams{ID: accountId, Date: firefly.NewOptDate(date)})
+	if err != nil {
+		return 0, err
+	}
+
+	switch out := out.(type) {
+	case *firefly.AccountSingle:
+		return database.ParseMoney(out.Data.Attributes.CurrentBalance.Or("0"))
+	case firefly.Exception:
+		return 0, firefly.ExceptionError(out)
+	default:
+		return 0, errors.Errorf("%s", out)
+	}
+}
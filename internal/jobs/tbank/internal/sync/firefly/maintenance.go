@@ -0,0 +1,249 @@
+package firefly
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
+	"gorm.io/gorm"
+
+	"github.com/jfk9w/hoarder/internal/database"
+	"github.com/jfk9w/hoarder/internal/firefly"
+	"github.com/jfk9w/hoarder/internal/jobs"
+	. "github.com/jfk9w/hoarder/internal/jobs/tbank/internal/entities"
+)
+
+type existsFunc func(ctx context.Context, client firefly.Invoker, id string) (bool, error)
+
+// mappedEntity describes Firefly ids mappings of an entity and the keys of the records of the users.
+type mappedEntity struct {
+	entity string
+	keys   *gorm.DB
+	exists existsFunc
+}
+
+func getMappedEntities(db database.DB, phones []string) []mappedEntity {
+	accounts := db.Model(new(Account)).Select("id").Where("user_phone in ?", phones)
+	investAccounts := db.Model(new(InvestAccount)).Select("id").Where("user_phone in ?", phones)
+	return []mappedEntity{
+		{
+			entity: new(Account).TableName(),
+			keys:   accounts,
+			exists: accountExists,
+		},
+		{
+			entity: new(InvestAccount).TableName(),
+			keys:   investAccounts,
+			exists: accountExists,
+		},
+		{
+			entity: new(Operation).TableName(),
+			keys:   db.Model(new(Operation)).Select("id").Where("account_id in (?)", accounts),
+			exists: transactionExists,
+		},
+		{
+			entity: new(InvestOperation).TableName(),
+			keys:   db.Model(new(InvestOperation)).Select("internal_id").Where("invest_account_id in (?)", investAccounts),
+			exists: transactionExists,
+		},
+		{
+			entity: cashbackEntity,
+			keys:   db.Model(new(Statement)).Select("id").Where("account_id in (?)", accounts),
+			exists: transactionExists,
+		},
+		{
+			entity: billsEntity,
+			keys:   accounts,
+			exists: billExists,
+		},
+		{
+			entity: new(SpendingCategory).TableName(),
+			exists: categoryExists,
+		},
+		{
+			entity: counterpartiesEntity,
+			exists: accountExists,
+		},
+		{
+			entity: new(Currency).TableName(),
+		},
+	}
+}
+
+func (e mappedEntity) query(ctx context.Context, db database.DB, instance string) *gorm.DB {
+	query := db.WithContext(ctx).
+		Model(new(FireflyMapping)).
+		Where("instance = ? and entity = ?", instance, e.entity)
+	if e.keys != nil {
+		query = query.Where("entity_key in (?)", e.keys)
+	}
+
+	return query
+}
+
+// Reset deletes Firefly ids mappings in the instance for records belonging to the users along with shared reference records,
+// so that everything is stored again on the next sync.
+func Reset(ctx jobs.Context, db database.DB, instance string, phones []string) (errs error) {
+	for _, entity := range getMappedEntities(db, phones) {
+		ctx := ctx.With("entity", entity.entity)
+		result := entity.query(ctx, db, instance).Delete(new(FireflyMapping))
+		if ctx.Error(&errs, result.Error, "failed to reset firefly ids") {
+			continue
+		}
+
+		ctx.Info("reset firefly ids", "count", result.RowsAffected)
+	}
+
+	ctx = ctx.With("entity", new(BalanceMismatch).TableName())
+	result := balanceMismatches(ctx, db, instance, phones).Update("firefly_id", nil)
+	if !ctx.Error(&errs, result.Error, "failed to reset firefly ids") {
+		ctx.Info("reset firefly ids", "count", result.RowsAffected)
+	}
+
+	return
+}
+
+// Verify deletes Firefly ids mappings in the instance which no longer exist in Firefly III.
+func Verify(ctx jobs.Context, db database.DB, client firefly.Instance, phones []string) (errs error) {
+	mappings := mappings{db: db, instance: client.ID}
+	for _, entity := range getMappedEntities(db, phones) {
+		if entity.exists == nil {
+			continue
+		}
+
+		ctx := ctx.With("entity", entity.entity)
+		var ids []string
+		if err := entity.query(ctx, db, client.ID).
+			Distinct("firefly_id").
+			Pluck("firefly_id", &ids).
+			Error; ctx.Error(&errs, err, "failed to select firefly ids") {
+			continue
+		}
+
+		missing, err := verifyIds(ctx, client, ids, entity.exists, func(id string) error {
+			return mappings.deleteFireflyId(ctx, id, entity.entity)
+		})
+
+		_ = multierr.AppendInto(&errs, err)
+		ctx.Info("verified firefly ids", "count", len(ids), "missing", missing)
+	}
+
+	ctx = ctx.With("entity", new(BalanceMismatch).TableName())
+	var ids []string
+	if err := balanceMismatches(ctx, db, client.ID, phones).
+		Distinct("firefly_id").
+		Pluck("firefly_id", &ids).
+		Error; ctx.Error(&errs, err, "failed to select firefly ids") {
+		return
+	}
+
+	missing, err := verifyIds(ctx, client, ids, transactionExists, func(id string) error {
+		return db.WithContext(ctx).
+			Model(new(BalanceMismatch)).
+			Where("instance = ? and firefly_id = ?", client.ID, id).
+			Update("firefly_id", nil).
+			Error
+	})
+
+	_ = multierr.AppendInto(&errs, err)
+	ctx.Info("verified firefly ids", "count", len(ids), "missing", missing)
+	return
+}
+
+func balanceMismatches(ctx context.Context, db database.DB, instance string, phones []string) *gorm.DB {
+	return db.WithContext(ctx).
+		Model(new(BalanceMismatch)).
+		Where("instance = ? and firefly_id is not null", instance).
+		Where("account_id in (?)", db.Model(new(Account)).Select("id").Where("user_phone in ?", phones))
+}
+
+// verifyIds checks that Firefly ids exist in Firefly III and clears missing ones.
+func verifyIds(ctx jobs.Context, client firefly.Invoker, ids []string, exists existsFunc, clear func(id string) error) (missing int, errs error) {
+	for _, id := range ids {
+		ctx := ctx.With("firefly_id", id)
+		ok, err := exists(ctx, client, id)
+		if ctx.Error(&errs, err, "failed to check firefly id") || ok {
+			continue
+		}
+
+		if err := clear(id); ctx.Error(&errs, err, "failed to clear firefly id in db") {
+			continue
+		}
+
+		missing++
+	}
+
+	return
+}
+
+func accountExists(ctx context.Context, client firefly.Invoker, id string) (bool, error) {
+	out, err := client.GetAccount(ctx, firefly.GetAccountParams{ID: id})
+	if err != nil {
+		return false, err
+	}
+
+	switch out := out.(type) {
+	case *firefly.AccountSingle:
+		return true, nil
+	case *firefly.NotFound:
+		return false, nil
+	case firefly.Exception:
+		return false, firefly.ExceptionError(out)
+	default:
+		return false, errors.Errorf("%s", out)
+	}
+}
+
+func transactionExists(ctx context.Context, client firefly.Invoker, id string) (bool, error) {
+	out, err := client.GetTransaction(ctx, firefly.GetTransactionParams{ID: id})
+	if err != nil {
+		return false, err
+	}
+
+	switch out := out.(type) {
+	case *firefly.TransactionSingle:
+		return true, nil
+	case *firefly.NotFound:
+		return false, nil
+	case firefly.Exception:
+		return false, firefly.ExceptionError(out)
+	default:
+		return false, errors.Errorf("%s", out)
+	}
+}
+
+func categoryExists(ctx context.Context, client firefly.Invoker, id string) (bool, error) {
+	out, err := client.GetCategory(ctx, firefly.GetCategoryParams{ID: id})
+	if err != nil {
+		return false, err
+	}
+
+	switch out := out.(type) {
+	case *firefly.CategorySingle:
+		return true, nil
+	case *firefly.NotFound:
+		return false, nil
+	case firefly.Exception:
+		return false, firefly.ExceptionError(out)
+	default:
+		return false, errors.Errorf("%s", out)
+	}
+}
+
+func billExists(ctx context.Context, client firefly.Invoker, id string) (bool, error) {
+	out, err := client.GetBill(ctx, firefly.GetBillParams{ID: id})
+	if err != nil {
+		return false, err
+	}
+
+	switch out := out.(type) {
+	case *firefly.BillSingle:
+		return true, nil
+	case *firefly.NotFound:
+		return false, nil
+	case firefly.Exception:
+		return false, firefly.ExceptionError(out)
+	default:
+		return false, errors.Errorf("%s", out)
+	}
+}
@@ -0,0 +1,90 @@
+package firefly
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+
+	"github.com/jfk9w/hoarder/internal/database"
+	. "github.com/jfk9w/hoarder/internal/jobs/tbank/internal/entities"
+)
+
+// counterpartiesEntity is the mapping entity for expense and revenue accounts in Firefly III.
+// Counterparty keys are built from the account type and the normalized name.
+const counterpartiesEntity = "counterparties"
+
+// mappings reads and writes Firefly ids of records in a Firefly III instance.
+// Entities are named after the tables of the records.
+type mappings struct {
+	db       database.DB
+	instance string
+}
+
+// mapped returns a scope selecting records of the table along with their Firefly ids and hashes.
+// key is the column of the table which identifies a record.
+func (m mappings) mapped(table, key string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return m.join(db.Select(table+".*, fm.firefly_id as firefly_id, fm.hash as firefly_hash"), "fm", table, table+"."+key)
+	}
+}
+
+// join left joins Firefly ids mappings for the entity as alias.
+func (m mappings) join(db *gorm.DB, alias, entity, key string) *gorm.DB {
+	return db.Joins("left join "+new(FireflyMapping).TableName()+" as "+alias+" on "+
+		alias+".instance = ? and "+alias+".entity = ? and "+alias+".entity_key = "+key,
+		m.instance, entity)
+}
+
+// get returns the mapping of the entity record or nil if the record is not mapped.
+func (m mappings) get(ctx context.Context, entity, key string) (*FireflyMapping, error) {
+	var mapping FireflyMapping
+	if err := m.db.WithContext(ctx).
+		Where("instance = ? and entity = ? and entity_key = ?", m.instance, entity, key).
+		Limit(1).
+		Find(&mapping).
+		Error; err != nil {
+		return nil, err
+	}
+
+	if mapping.FireflyId == "" {
+		return nil, nil
+	}
+
+	return &mapping, nil
+}
+
+func (m mappings) save(ctx context.Context, entity, key, fireflyId string, hash *string) error {
+	return m.db.WithContext(ctx).
+		Upsert(&FireflyMapping{
+			Instance:  m.instance,
+			Entity:    entity,
+			Key:       key,
+			FireflyId: fireflyId,
+			Hash:      hash,
+		}).
+		Error
+}
+
+func (m mappings) updateHash(ctx context.Context, entity, fireflyId string, hash *string) error {
+	return m.db.WithContext(ctx).
+		Model(new(FireflyMapping)).
+		Where("instance = ? and entity = ? and firefly_id = ?", m.instance, entity, fireflyId).
+		Update("hash", hash).
+		Error
+}
+
+// delete removes the mapping of the entity record.
+func (m mappings) delete(ctx context.Context, entity, key string) error {
+	return m.db.WithContext(ctx).
+		Where("instance = ? and entity = ? and entity_key = ?", m.instance, entity, key).
+		Delete(new(FireflyMapping)).
+		Error
+}
+
+// deleteFireflyId removes mappings of all records of the entities mapped to the Firefly id.
+func (m mappings) deleteFireflyId(ctx context.Context, fireflyId string, entities ...string) error {
+	return m.db.WithContext(ctx).
+		Where("instance = ? and entity in ? and firefly_id = ?", m.instance, entities, fireflyId).
+		Delete(new(FireflyMapping)).
+		Error
+}
@@ -0,0 +1,653 @@
+package firefly
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/AlekSi/pointer"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/jfk9w/hoarder/internal/database"
+	. "github.com/jfk9w/hoarder/internal/jobs/tbank/internal/entities"
+)
+
+// Operation ids are numeric strings, so they are ordered by length first.
+const operationIdOrder = "length(id), id"
+
+// pairing builds transaction rows from operations of an account.
+//
+// Outgoing transfers (Debit operations in TRANSFER group) are paired with incoming ones
+// (Credit operations in INCOME group) in the account specified as the sender agreement.
+// Paired operations must have matching amounts and either the same operation time
+// or be adjacent in the global operation order. Everything else is booked as a withdrawal or a deposit.
+//
+// Pairing is done in Go in order to avoid dialect-specific SQL (window functions, full joins and casts).
+type pairing struct {
+	db        database.DB
+	mappings  mappings
+	accountId string
+
+	// since limits rows to operations which may have changed, see All.Since.
+	since time.Time
+
+	currencies map[uint]*string
+	categories map[string]*string
+	accounts   map[string]*string
+	bills      map[string]*string
+	statements map[string][]Statement
+	brands     map[string]string
+	providers  map[string]string
+}
+
+func (p *pairing) rows(ctx context.Context, after string, limit int) (rows []transactionQueryRow, lastId string, err error) {
+	var operations []Operation
+	if err := p.db.WithContext(ctx).
+		Scopes(settledOperations, p.operations, p.changed).
+		Where("account_id = ?", p.accountId).
+		Where("length(id) > ? or length(id) = ? and id > ?", len(after), len(after), after).
+		Order(operationIdOrder).
+		Limit(limit).
+		Find(&operations).
+		Error; err != nil {
+		return nil, "", errors.Wrap(err, "select operations")
+	}
+
+	if len(operations) == 0 {
+		return nil, "", nil
+	}
+
+	lastId = operations[len(operations)-1].Id
+	counterparts, err := p.selectCounterparts(ctx, operations)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "select counterparts")
+	}
+
+	pairs := make([][2]*Operation, 0, len(operations))
+	for i := range operations {
+		operation := &operations[i]
+		switch operation.Type {
+		case "Debit":
+			pairs = append(pairs, [2]*Operation{operation, counterparts[operation.Id]})
+		case "Credit":
+			pairs = append(pairs, [2]*Operation{counterparts[operation.Id], operation})
+		}
+	}
+
+	claimed, err := p.selectClaimedTransactionIds(ctx, pairs)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "select claimed transaction ids")
+	}
+
+	if err := p.loadReferences(ctx, pairs); err != nil {
+		return nil, "", errors.Wrap(err, "load references")
+	}
+
+	if err := p.loadCounterpartyCandidates(ctx, pairs); err != nil {
+		return nil, "", errors.Wrap(err, "load counterparty candidates")
+	}
+
+	for _, pair := range pairs {
+		if isClaimed(claimed, pair) {
+			continue
+		}
+
+		if row, ok := p.row(pair[0], pair[1]); ok {
+			rows = append(rows, row)
+		}
+	}
+
+	return
+}
+
+func settledOperations(db *gorm.DB) *gorm.DB {
+	return db.Where("status = ? and debiting_time is not null", "OK")
+}
+
+func (p *pairing) operations(db *gorm.DB) *gorm.DB {
+	return p.mappings.mapped(new(Operation).TableName(), "id")(db)
+}
+
+// changed limits operations to the ones which are not synced, have their hashes reset or may have changed since p.since.
+// Transfers are paired from the side of the changed operation, so their synced counterparts do not need to be selected.
+func (p *pairing) changed(db *gorm.DB) *gorm.DB {
+	if p.since.IsZero() {
+		return db
+	}
+
+	return db.Where("fm.firefly_id is null or fm.hash is null or operation_time >= ? or debiting_time >= ?", p.since, p.since)
+}
+
+// isTransfer checks if the operation may be a part of a transfer between accounts.
+// Outgoing transfers are Debit operations, incoming ones are Credit operations.
+func isTransfer(operation *Operation) bool {
+	switch operation.Type {
+	case "Debit":
+		return pointer.Get(operation.Group) == "TRANSFER"
+	case "Credit":
+		return pointer.Get(operation.Group) == "INCOME" && pointer.Get(operation.SenderAgreement) != ""
+	default:
+		return false
+	}
+}
+
+// selectCounterparts finds incoming transfers for outgoing ones (and vice versa) among the operations, keyed by operation ids.
+// Candidates with the same operation time and neighbours in global order are selected for all operations at once.
+func (p *pairing) selectCounterparts(ctx context.Context, operations []Operation) (map[string]*Operation, error) {
+	var (
+		transfers      []*Operation
+		times          []Milliseconds
+		senderAccounts []string
+		outgoing       bool
+		incoming       bool
+	)
+
+	for i := range operations {
+		operation := &operations[i]
+		if !isTransfer(operation) {
+			continue
+		}
+
+		transfers = append(transfers, operation)
+		times = append(times, operation.OperationTime)
+		if operation.Type == "Debit" {
+			outgoing = true
+		} else {
+			incoming = true
+			senderAccounts = append(senderAccounts, *operation.SenderAgreement)
+		}
+	}
+
+	if len(transfers) == 0 {
+		return nil, nil
+	}
+
+	var candidates []Operation
+	if outgoing {
+		var credits []Operation
+		if err := p.db.WithContext(ctx).
+			Scopes(settledOperations, p.operations).
+			Where("type = ? and sender_agreement = ?", "Credit", p.accountId).
+			Where(clause.Eq{Column: clause.Column{Name: "group"}, Value: "INCOME"}).
+			Where("operation_time in ?", times).
+			Order(operationIdOrder).
+			Find(&credits).
+			Error; err != nil {
+			return nil, errors.Wrap(err, "select incoming transfers")
+		}
+
+		candidates = append(candidates, credits...)
+	}
+
+	if incoming {
+		var debits []Operation
+		if err := p.db.WithContext(ctx).
+			Scopes(settledOperations, p.operations).
+			Where("type = ? and account_id in ?", "Debit", senderAccounts).
+			Where(clause.Eq{Column: clause.Column{Name: "group"}, Value: "TRANSFER"}).
+			Where("operation_time in ?", times).
+			Order(operationIdOrder).
+			Find(&debits).
+			Error; err != nil {
+			return nil, errors.Wrap(err, "select outgoing transfers")
+		}
+
+		candidates = append(candidates, debits...)
+	}
+
+	neighbours, err := p.selectNeighbours(ctx, transfers)
+	if err != nil {
+		return nil, errors.Wrap(err, "select neighbours")
+	}
+
+	counterparts := make(map[string]*Operation, len(transfers))
+	for _, operation := range transfers {
+		matching := make([]*Operation, 0, 2)
+		for i := range candidates {
+			if candidates[i].OperationTime.Time().Equal(operation.OperationTime.Time()) {
+				matching = append(matching, &candidates[i])
+			}
+		}
+
+		if neighbour := neighbourOf(operation, neighbours, operation.Type == "Debit"); neighbour != nil {
+			matching = append(matching, neighbour)
+		}
+
+		for _, candidate := range matching {
+			source, target := operation, candidate
+			if operation.Type == "Credit" {
+				source, target = candidate, operation
+			}
+
+			if isTransferPair(source, target) {
+				counterparts[operation.Id] = candidate
+				break
+			}
+		}
+	}
+
+	return counterparts, nil
+}
+
+// selectNeighbours selects the next settled operations in global order for outgoing transfers
+// and the previous ones for incoming transfers. Neighbours of all operations are selected with one query
+// and matched with the operations by neighbourOf.
+func (p *pairing) selectNeighbours(ctx context.Context, transfers []*Operation) ([]Operation, error) {
+	var (
+		selects    []string
+		subqueries []any
+	)
+
+	for _, operation := range transfers {
+		cmp, order := ">", "operation_time, length(id), id"
+		if operation.Type == "Credit" {
+			cmp, order = "<", "operation_time desc, length(id) desc, id desc"
+		}
+
+		subqueries = append(subqueries, p.db.
+			Model(new(Operation)).
+			Scopes(settledOperations).
+			Select("id").
+			Where("operation_time "+cmp+" ? or operation_time = ? and "+
+				"(length(id) "+cmp+" ? or length(id) = ? and id "+cmp+" ?)",
+				operation.OperationTime, operation.OperationTime,
+				len(operation.Id), len(operation.Id), operation.Id).
+			Order(order).
+			Limit(1))
+		selects = append(selects, fmt.Sprintf("select id from (?) as n%d", len(selects)))
+	}
+
+	var ids []string
+	if err := p.db.WithContext(ctx).
+		Raw(strings.Join(selects, " union "), subqueries...).
+		Scan(&ids).
+		Error; err != nil {
+		return nil, err
+	}
+
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	var neighbours []Operation
+	if err := p.db.WithContext(ctx).
+		Scopes(p.operations).
+		Where("id in ?", ids).
+		Find(&neighbours).
+		Error; err != nil {
+		return nil, err
+	}
+
+	return neighbours, nil
+}
+
+// neighbourOf returns the next (or previous) operation in global order among selected neighbours.
+// The nearest selected operation following (or preceding) the operation is its neighbour,
+// since the neighbour of every operation was selected.
+func neighbourOf(operation *Operation, neighbours []Operation, next bool) *Operation {
+	var result *Operation
+	for i := range neighbours {
+		neighbour := &neighbours[i]
+		if next && operationLess(operation, neighbour) && (result == nil || operationLess(neighbour, result)) ||
+			!next && operationLess(neighbour, operation) && (result == nil || operationLess(result, neighbour)) {
+			result = neighbour
+		}
+	}
+
+	return result
+}
+
+// operationLess compares operations in global order, that is by operation time and id.
+func operationLess(a, b *Operation) bool {
+	at, bt := a.OperationTime.Time(), b.OperationTime.Time()
+	switch {
+	case !at.Equal(bt):
+		return at.Before(bt)
+	case len(a.Id) != len(b.Id):
+		return len(a.Id) < len(b.Id)
+	default:
+		return a.Id < b.Id
+	}
+}
+
+func isTransferPair(source, target *Operation) bool {
+	return source.Type == "Debit" && pointer.Get(source.Group) == "TRANSFER" &&
+		target.Type == "Credit" && pointer.Get(target.Group) == "INCOME" &&
+		pointer.Get(target.SenderAgreement) == source.AccountId &&
+		(source.Amount.Value == target.AccountAmount.AccountValue ||
+			source.AccountAmount.AccountValue == target.Amount.Value)
+}
+
+// isClaimed checks if any operation of the pair is synced to a transaction owned by an investment operation.
+func isClaimed(claimed map[string]bool, pair [2]*Operation) bool {
+	for _, operation := range pair {
+		if operation != nil && claimed[pointer.Get(operation.FireflyId)] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// selectClaimedTransactionIds returns Firefly transaction ids which are owned by investment operations.
+func (p *pairing) selectClaimedTransactionIds(ctx context.Context, pairs [][2]*Operation) (map[string]bool, error) {
+	var ids []string
+	for _, pair := range pairs {
+		for _, operation := range pair {
+			if operation != nil && operation.FireflyId != nil {
+				ids = append(ids, *operation.FireflyId)
+			}
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	var claimed []string
+	if err := p.db.WithContext(ctx).
+		Model(new(FireflyMapping)).
+		Where("instance = ? and entity = ? and firefly_id in ?", p.mappings.instance, new(InvestOperation).TableName(), ids).
+		Pluck("firefly_id", &claimed).
+		Error; err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]bool, len(claimed))
+	for _, id := range claimed {
+		result[id] = true
+	}
+
+	return result, nil
+}
+
+// loadReferences loads Firefly ids of currencies, spending categories and accounts used by the operations.
+func (p *pairing) loadReferences(ctx context.Context, pairs [][2]*Operation) error {
+	if p.currencies == nil {
+		var currencies []Currency
+		if err := p.db.WithContext(ctx).
+			Scopes(p.mappings.mapped(new(Currency).TableName(), "name")).
+			Find(&currencies).
+			Error; err != nil {
+			return errors.Wrap(err, "select currencies")
+		}
+
+		p.currencies = make(map[uint]*string, len(currencies))
+		for _, currency := range currencies {
+			p.currencies[currency.Code] = currency.FireflyId
+		}
+	}
+
+	if p.categories == nil {
+		p.categories = make(map[string]*string)
+		p.accounts = make(map[string]*string)
+		p.bills = make(map[string]*string)
+		p.statements = make(map[string][]Statement)
+	}
+
+	var categoryIds, accountIds []string
+	for _, pair := range pairs {
+		for _, operation := range pair {
+			if operation == nil {
+				continue
+			}
+
+			if _, ok := p.categories[operation.SpendingCategoryId]; !ok {
+				categoryIds = append(categoryIds, operation.SpendingCategoryId)
+			}
+
+			if _, ok := p.accounts[operation.AccountId]; !ok {
+				accountIds = append(accountIds, operation.AccountId)
+			}
+		}
+	}
+
+	if len(categoryIds) > 0 {
+		var categories []SpendingCategory
+		if err := p.db.WithContext(ctx).
+			Scopes(p.mappings.mapped(new(SpendingCategory).TableName(), "id")).
+			Where("id in ?", categoryIds).
+			Find(&categories).
+			Error; err != nil {
+			return errors.Wrap(err, "select spending categories")
+		}
+
+		for _, category := range categories {
+			p.categories[category.Id] = category.FireflyId
+		}
+	}
+
+	if len(accountIds) > 0 {
+		var accounts []Account
+		if err := p.db.WithContext(ctx).
+			Scopes(p.mappings.mapped(new(Account).TableName(), "id")).
+			Where("id in ?", accountIds).
+			Find(&accounts).
+			Error; err != nil {
+			return errors.Wrap(err, "select accounts")
+		}
+
+		for _, account := range accounts {
+			p.accounts[account.Id] = account.FireflyId
+		}
+
+		var bills []FireflyMapping
+		if err := p.db.WithContext(ctx).
+			Where("instance = ? and entity = ? and entity_key in ?", p.mappings.instance, billsEntity, accountIds).
+			Find(&bills).
+			Error; err != nil {
+			return errors.Wrap(err, "select bills")
+		}
+
+		billAccountIds := make([]string, len(bills))
+		for i, bill := range bills {
+			p.bills[bill.Key] = &bill.FireflyId
+			billAccountIds[i] = bill.Key
+		}
+
+		if len(billAccountIds) > 0 {
+			var statements []Statement
+			if err := p.db.WithContext(ctx).
+				Select("id", "account_id", "date", "last_payment_date", "billed_debt_value").
+				Where("account_id in ? and last_payment_date is not null and billed_debt_value > 0", billAccountIds).
+				Order("date").
+				Find(&statements).
+				Error; err != nil {
+				return errors.Wrap(err, "select statements")
+			}
+
+			for _, statement := range statements {
+				p.statements[statement.AccountId] = append(p.statements[statement.AccountId], statement)
+			}
+		}
+	}
+
+	return nil
+}
+
+// row builds a transaction row from an outgoing (left) and an incoming (right) operation, either of which may be nil.
+func (p *pairing) row(lo, ro *Operation) (row transactionQueryRow, ok bool) {
+	first, second := lo, ro
+	if first == nil {
+		first, second = ro, lo
+	}
+
+	categoryId, ok := p.categories[first.SpendingCategoryId]
+	if !ok {
+		return row, false
+	}
+
+	row = transactionQueryRow{
+		OperationId:       first.Id,
+		FireflyHash:       first.FireflyHash,
+		OperationTime:     first.OperationTime.Time(),
+		DebitingTime:      first.DebitingTime.Time(),
+		Description:       first.Description,
+		FireflyCategoryId: pointer.Get(categoryId),
+		MerchantName:      merchantName(first),
+		SenderDetails:     first.SenderDetails,
+	}
+
+	if row.FireflyHash == nil && second != nil {
+		row.FireflyHash = second.FireflyHash
+	}
+
+	if row.MerchantName == nil && second != nil {
+		row.MerchantName = merchantName(second)
+	}
+
+	if row.SenderDetails == nil && second != nil {
+		row.SenderDetails = second.SenderDetails
+	}
+
+	if lo != nil {
+		row.FireflyCurrencyId = pointer.Get(p.currencies[lo.AccountAmount.AccountCurrencyCode])
+		row.Amount = lo.AccountAmount.AccountValue
+		row.FireflySourceTransactionId = lo.FireflyId
+		if accountId := p.accounts[lo.AccountId]; accountId != nil {
+			row.SourceOperationId = &lo.Id
+			row.FireflySourceAccountId = accountId
+		}
+	} else {
+		row.FireflyCurrencyId = pointer.Get(p.currencies[ro.Amount.CurrencyCode])
+		row.Amount = ro.Amount.Value
+	}
+
+	if ro != nil {
+		row.FireflyForeignCurrencyId = pointer.Get(p.currencies[ro.AccountAmount.AccountCurrencyCode])
+		row.ForeignAmount = ro.AccountAmount.AccountValue
+		row.FireflyDestinationTransactionId = ro.FireflyId
+		if accountId := p.accounts[ro.AccountId]; accountId != nil {
+			row.DestinationOperationId = &ro.Id
+			row.FireflyDestinationAccountId = accountId
+			if row.FireflySourceAccountId != nil && pointer.Get(ro.Group) == "INCOME" && p.isBilled(ro) {
+				// Incoming transfers to a credit account are repayments, refunds belong to other groups.
+				// Bills are linked on the withdrawal side, so repayments from other banks are not linked.
+				row.FireflyBillId = p.bills[ro.AccountId]
+			}
+		}
+	} else {
+		row.FireflyForeignCurrencyId = pointer.Get(p.currencies[lo.Amount.CurrencyCode])
+		row.ForeignAmount = lo.Amount.Value
+	}
+
+	p.setCounterpartyCandidates(&row, first, second)
+	return row, true
+}
+
+// isBilled checks if the repayment was made in the payment period of a statement with billed debt,
+// that is between the statement date and its last payment date.
+func (p *pairing) isBilled(repayment *Operation) bool {
+	if p.bills[repayment.AccountId] == nil {
+		return false
+	}
+
+	date := repayment.OperationTime.Time()
+	for _, statement := range p.statements[repayment.AccountId] {
+		if !date.Before(statement.Date.Time()) && date.Before(statement.LastPaymentDate.Time().AddDate(0, 0, 1)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// loadCounterpartyCandidates loads brand names and payment providers used by the operations.
+func (p *pairing) loadCounterpartyCandidates(ctx context.Context, pairs [][2]*Operation) error {
+	if p.brands == nil {
+		p.brands = make(map[string]string)
+		p.providers = make(map[string]string)
+	}
+
+	var brandIds, paymentIds []string
+	for _, pair := range pairs {
+		for _, operation := range pair {
+			if operation == nil {
+				continue
+			}
+
+			if id := operation.BrandId; id != nil {
+				if _, ok := p.brands[*id]; !ok {
+					brandIds = append(brandIds, *id)
+				}
+			}
+
+			if id := operation.PaymentId; id != nil {
+				if _, ok := p.providers[*id]; !ok {
+					paymentIds = append(paymentIds, *id)
+				}
+			}
+		}
+	}
+
+	if len(brandIds) > 0 {
+		var brands []Brand
+		if err := p.db.WithContext(ctx).
+			Select("id", "name").
+			Where("id in ?", brandIds).
+			Find(&brands).
+			Error; err != nil {
+			return errors.Wrap(err, "select brands")
+		}
+
+		for _, id := range brandIds {
+			p.brands[id] = ""
+		}
+
+		for _, brand := range brands {
+			p.brands[brand.Id] = brand.Name
+		}
+	}
+
+	if len(paymentIds) > 0 {
+		var payments []Payment
+		if err := p.db.WithContext(ctx).
+			Select("payment_id", "provider_id").
+			Where("payment_id in ?", paymentIds).
+			Find(&payments).
+			Error; err != nil {
+			return errors.Wrap(err, "select payments")
+		}
+
+		for _, id := range paymentIds {
+			p.providers[id] = ""
+		}
+
+		for _, payment := range payments {
+			p.providers[payment.PaymentId] = payment.ProviderId
+		}
+	}
+
+	return nil
+}
+
+func (p *pairing) setCounterpartyCandidates(row *transactionQueryRow, operations ...*Operation) {
+	for _, operation := range operations {
+		if operation == nil {
+			continue
+		}
+
+		if row.BrandName == nil && operation.BrandId != nil {
+			if name := p.brands[*operation.BrandId]; name != "" {
+				row.BrandName = &name
+			}
+		}
+
+		if row.ProviderId == nil && operation.PaymentId != nil {
+			if providerId := p.providers[*operation.PaymentId]; providerId != "" {
+				row.ProviderId = &providerId
+			}
+		}
+	}
+}
+
+func merchantName(operation *Operation) *string {
+	if merchant := operation.Merchant; merchant != nil && merchant.Name != "" {
+		return &merchant.Name
+	}
+
+	return nil
+}
@@ -0,0 +1,304 @@
+package firefly
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AlekSi/pointer"
+	"github.com/jfk9w-go/based"
+	tbank "github.com/jfk9w-go/tbank-api"
+	"gorm.io/gorm/clause"
+
+	"github.com/jfk9w/hoarder/internal/database"
+	"github.com/jfk9w/hoarder/internal/firefly"
+	"github.com/jfk9w/hoarder/internal/jobs"
+	. "github.com/jfk9w/hoarder/internal/jobs/tbank/internal/entities"
+)
+
+const testInstance = "test"
+
+// fakeInvoker stores transactions in memory. Methods which are not overridden panic.
+type fakeInvoker struct {
+	firefly.Invoker
+
+	mu      sync.Mutex
+	stored  map[string]firefly.TransactionSplitStore
+	deleted []string
+}
+
+func (f *fakeInvoker) StoreTransaction(_ context.Context, in *firefly.TransactionStore, _ firefly.StoreTransactionParams) (firefly.StoreTransactionRes, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.stored == nil {
+		f.stored = make(map[string]firefly.TransactionSplitStore)
+	}
+
+	id := fmt.Sprint(len(f.stored) + len(f.deleted) + 1)
+	f.stored[id] = in.Transactions[0]
+	return &firefly.TransactionSingle{Data: firefly.TransactionRead{ID: id}}, nil
+}
+
+func (f *fakeInvoker) DeleteTransaction(_ context.Context, params firefly.DeleteTransactionParams) (firefly.DeleteTransactionRes, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.stored, params.ID)
+	f.deleted = append(f.deleted, params.ID)
+	return new(firefly.DeleteTransactionNoContent), nil
+}
+
+type pairingTest struct {
+	t      *testing.T
+	ctx    jobs.Context
+	db     database.DB
+	client *fakeInvoker
+}
+
+func newPairingTest(t *testing.T) *pairingTest {
+	ctx := context.Background()
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", strings.ReplaceAll(t.Name(), "/", "_"))
+	db, err := database.Open(ctx, database.Params{
+		Clock:  based.StandardClock,
+		Logger: slog.Default(),
+		Config: database.Config{Driver: "sqlite", DSN: dsn},
+		Name:   "tbank",
+		Migrations: []database.Migration{
+			{
+				Version:     1,
+				Description: "create tables",
+				Up: database.AutoMigrate(new(User), new(Currency), new(Account), new(Category), new(SpendingCategory),
+					new(Brand), new(Payment), new(Operation), new(Statement), new(FireflyMapping), new(FireflyOverride), new(BalanceMismatch)),
+			},
+		},
+	})
+
+	if err != nil && strings.Contains(err.Error(), "cgo") {
+		t.Skipf("sqlite is not available: %v", err)
+	}
+
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB.DB(); err == nil {
+			_ = sqlDB.Close()
+		}
+	})
+
+	test := &pairingTest{
+		t:      t,
+		ctx:    jobs.NewContext(ctx, slog.Default()),
+		db:     db,
+		client: new(fakeInvoker),
+	}
+
+	test.create(&User{Phone: "+7"})
+	test.create(&Category{Id: "c"})
+	test.create(&SpendingCategory{Id: "sc"})
+	test.mapping(new(SpendingCategory).TableName(), "sc", "category")
+	for _, currency := range []Currency{{Code: 643, Name: "RUB"}, {Code: 840, Name: "USD"}} {
+		test.create(&currency)
+		test.mapping(currency.TableName(), currency.Name, strings.ToLower(currency.Name))
+	}
+
+	for _, account := range []Account{{Id: "a", UserPhone: "+7"}, {Id: "b", UserPhone: "+7"}} {
+		test.create(&account)
+		test.mapping(account.TableName(), account.Id, "account-"+account.Id)
+	}
+
+	return test
+}
+
+func (test *pairingTest) create(value any) {
+	test.t.Helper()
+	if err := test.db.Omit(clause.Associations).Create(value).Error; err != nil {
+		test.t.Fatalf("create %T: %v", value, err)
+	}
+}
+
+func (test *pairingTest) mapping(entity, key, fireflyId string) {
+	test.create(&FireflyMapping{Instance: testInstance, Entity: entity, Key: key, FireflyId: fireflyId})
+}
+
+// operation creates a settled RUB operation. Transfers between accounts a and b are recognized by type, group and sender agreement.
+func (test *pairingTest) operation(id, accountId, operationType string, at time.Time, value float64, fn func(*Operation)) {
+	test.t.Helper()
+	operation := Operation{
+		Id:                 id,
+		AccountId:          accountId,
+		Type:               operationType,
+		Status:             "OK",
+		CategoryId:         "c",
+		SpendingCategoryId: "sc",
+		Description:        "operation " + id,
+		OperationTime:      Milliseconds{Milliseconds: tbank.Milliseconds(at)},
+		DebitingTime:       &Milliseconds{Milliseconds: tbank.Milliseconds(at)},
+		Amount:             OperationAmount{CurrencyCode: 643, Value: database.MoneyFromFloat(value)},
+		AccountAmount:      OperationAccountAmount{AccountCurrencyCode: 643, AccountValue: database.MoneyFromFloat(value)},
+	}
+
+	switch operationType {
+	case "Debit":
+		operation.Group = pointer.To("TRANSFER")
+	case "Credit":
+		operation.Group = pointer.To("INCOME")
+		operation.SenderAgreement = pointer.To("a")
+		if accountId == "a" {
+			operation.SenderAgreement = pointer.To("b")
+		}
+	}
+
+	if fn != nil {
+		fn(&operation)
+	}
+
+	test.create(&operation)
+}
+
+func (test *pairingTest) sync(accountIds ...string) {
+	test.t.Helper()
+	client := firefly.Instance{Invoker: test.client, ID: testInstance, Concurrency: 1}
+	for _, accountId := range accountIds {
+		if _, err := (transactions{accountId: accountId, batchSize: 10}).Sync(test.ctx, test.db, client); err != nil {
+			test.t.Fatalf("sync %s: %v", accountId, err)
+		}
+	}
+}
+
+// stored returns the only transaction stored in Firefly III.
+func (test *pairingTest) stored() firefly.TransactionSplitStore {
+	test.t.Helper()
+	if len(test.client.stored) != 1 {
+		test.t.Fatalf("expected 1 stored transaction, got %d", len(test.client.stored))
+	}
+
+	for _, transaction := range test.client.stored {
+		return transaction
+	}
+
+	return firefly.TransactionSplitStore{}
+}
+
+func (test *pairingTest) fireflyId(operationId string) string {
+	test.t.Helper()
+	mapping, err := mappings{db: test.db, instance: testInstance}.get(test.ctx, new(Operation).TableName(), operationId)
+	if err != nil {
+		test.t.Fatalf("get mapping of %s: %v", operationId, err)
+	}
+
+	if mapping == nil {
+		return ""
+	}
+
+	return mapping.FireflyId
+}
+
+func TestPairing_TransferPair(t *testing.T) {
+	test := newPairingTest(t)
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	test.operation("1", "a", "Debit", at, 100, nil)
+	test.operation("2", "b", "Credit", at, 100, nil)
+
+	test.sync("a", "b")
+
+	transaction := test.stored()
+	if transaction.Type != firefly.TransactionTypePropertyTransfer {
+		t.Errorf("expected transfer, got %s", transaction.Type)
+	}
+
+	if source, destination := transaction.SourceID.Value, transaction.DestinationID.Value; source != "account-a" || destination != "account-b" {
+		t.Errorf("expected transfer from account-a to account-b, got %s -> %s", source, destination)
+	}
+
+	if id := test.fireflyId("1"); id == "" || id != test.fireflyId("2") {
+		t.Errorf("expected both operations to be mapped to the same transaction, got %q and %q", id, test.fireflyId("2"))
+	}
+}
+
+func TestPairing_UnpairedOperation(t *testing.T) {
+	test := newPairingTest(t)
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	test.operation("1", "a", "Debit", at, 100, nil)
+	// same time, but the amount does not match
+	test.operation("2", "b", "Credit", at, 99, nil)
+
+	test.sync("a")
+
+	transaction := test.stored()
+	if transaction.Type != firefly.TransactionTypePropertyWithdrawal {
+		t.Errorf("expected withdrawal, got %s", transaction.Type)
+	}
+
+	if transaction.DestinationID.Set {
+		t.Errorf("expected no destination, got %s", transaction.DestinationID.Value)
+	}
+
+	if id := test.fireflyId("2"); id != "" {
+		t.Errorf("expected unpaired operation not to be mapped, got %q", id)
+	}
+}
+
+func TestPairing_FailedCounterpart(t *testing.T) {
+	test := newPairingTest(t)
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	test.operation("1", "a", "Debit", at, 100, nil)
+	test.operation("2", "b", "Credit", at, 100, nil)
+	test.sync("a")
+	transferId := test.fireflyId("1")
+
+	if err := test.db.Model(new(Operation)).Where("id = ?", "2").Update("status", "FAILED").Error; err != nil {
+		t.Fatalf("update status: %v", err)
+	}
+
+	test.sync("b", "a")
+
+	if len(test.client.deleted) != 1 || test.client.deleted[0] != transferId {
+		t.Errorf("expected transfer %s to be deleted, got %v", transferId, test.client.deleted)
+	}
+
+	transaction := test.stored()
+	if transaction.Type != firefly.TransactionTypePropertyWithdrawal {
+		t.Errorf("expected withdrawal, got %s", transaction.Type)
+	}
+
+	if id := test.fireflyId("1"); id == "" || id == transferId {
+		t.Errorf("expected remaining operation to be stored again, got %q", id)
+	}
+
+	if id := test.fireflyId("2"); id != "" {
+		t.Errorf("expected failed operation not to be mapped, got %q", id)
+	}
+}
+
+func TestPairing_CrossCurrencyPair(t *testing.T) {
+	test := newPairingTest(t)
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	// the counterpart is one second later, so it is found as the neighbour in global order
+	test.operation("1", "a", "Debit", at, 9000, func(operation *Operation) {
+		operation.Amount = OperationAmount{CurrencyCode: 840, Value: database.MoneyFromFloat(100)}
+	})
+	test.operation("2", "b", "Credit", at.Add(time.Second), 100, func(operation *Operation) {
+		operation.Amount = OperationAmount{CurrencyCode: 840, Value: database.MoneyFromFloat(100)}
+		operation.AccountAmount = OperationAccountAmount{AccountCurrencyCode: 840, AccountValue: database.MoneyFromFloat(100)}
+	})
+
+	test.sync("b", "a")
+
+	transaction := test.stored()
+	if transaction.Type != firefly.TransactionTypePropertyTransfer {
+		t.Errorf("expected transfer, got %s", transaction.Type)
+	}
+
+	if currency, amount := transaction.CurrencyID.Value, transaction.Amount; currency != "rub" || amount != "9000.00" {
+		t.Errorf("expected 9000.00 rub, got %s %s", amount, currency)
+	}
+
+	if currency, amount := transaction.ForeignCurrencyID.Value, transaction.ForeignAmount.Value; currency != "usd" || amount != "100.00" {
+		t.Errorf("expected foreign 100.00 usd, got %s %s", amount, currency)
+	}
+}
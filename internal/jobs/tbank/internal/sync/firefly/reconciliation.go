@@ -0,0 +1,172 @@
+package firefly
+
+import (
+	"context"
+	"time"
+
+	"github.com/jfk9w/hoarder/internal/database"
+	"github.com/jfk9w/hoarder/internal/firefly"
+	"github.com/jfk9w/hoarder/internal/jobs"
+	. "github.com/jfk9w/hoarder/internal/jobs/tbank/internal/entities"
+)
+
+// Reconciliation configures the comparison of account balances between T-Bank and Firefly III.
+type Reconciliation struct {
+	// Account is the name of the revenue and expense accounts used for reconciliation transactions.
+	// If empty, differences are only reported.
+	Account string
+}
+
+// reconciliation compares the account balance from AccountsLightIb with the Firefly III account balance.
+// Operations which are not debited yet are not synced, so they are added to the Firefly III balance.
+type reconciliation struct {
+	Reconciliation
+	accountId        string
+	fireflyAccountId string
+	now              time.Time
+
+	// transactions is the status of the transactions sync of the account. If it failed, the difference
+	// includes transactions which are synced later, so the mismatch is only recorded.
+	transactions *transactionsStatus
+}
+
+func (s reconciliation) TableName() string {
+	return new(BalanceMismatch).TableName()
+}
+
+func (s reconciliation) Sync(ctx jobs.Context, db database.DB, client firefly.Instance) (_ []Interface, errs error) {
+	ctx = ctx.With("account_id", s.accountId)
+
+	mappings := mappings{db: db, instance: client.ID}
+
+	var account Account
+	if err := db.WithContext(ctx).
+		Preload("Currency", mappings.mapped(new(Currency).TableName(), "name")).
+		Where("id = ?", s.accountId).
+		First(&account).
+		Error; ctx.Error(&errs, err, "failed to select record") {
+		return
+	}
+
+	expected, ok := getAccountBalanceValue(account)
+	if account.Deleted || !ok {
+		return
+	}
+
+	balance, err := getAccountBalance(ctx, client, s.fireflyAccountId, s.now)
+	if ctx.Error(&errs, err, "failed to get account balance") {
+		return
+	}
+
+	pending, err := s.getPendingAmount(ctx, db)
+	if ctx.Error(&errs, err, "failed to get pending amount") {
+		return
+	}
+
+	actual := balance + pending
+	difference := expected - actual
+	if difference == 0 {
+		return
+	}
+
+	mismatch := BalanceMismatch{
+		Instance:   client.ID,
+		AccountId:  s.accountId,
+		CheckedAt:  s.now,
+		Expected:   expected,
+		Actual:     actual,
+		Difference: difference,
+	}
+
+	if err := db.WithContext(ctx).
+		Create(&mismatch).
+		Error; ctx.Error(&errs, err, "failed to save balance mismatch") {
+		return
+	}
+
+	if s.Account != "" && s.transactions != nil && s.transactions.failed {
+		ctx.Warn("not storing reconciliation, since transactions of the account failed to sync")
+	} else if s.Account != "" {
+		fireflyId, err := s.storeReconciliation(ctx, client, account, difference)
+		if ctx.Error(&errs, err, "failed to store reconciliation") {
+			return
+		}
+
+		if err := db.WithContext(ctx).
+			Table(mismatch.TableName()).
+			Where("instance = ? and account_id = ? and checked_at = ?", client.ID, s.accountId, s.now).
+			Update("firefly_id", fireflyId).
+			Error; ctx.Error(&errs, err, "failed to update firefly id in db") {
+			return
+		}
+	}
+
+	ctx.Warn("balance mismatch", "expected", expected, "actual", actual, "difference", difference)
+	ctx.Report("balance mismatch of account %s: expected %s, actual %s, difference %s",
+		s.accountId, expected, actual, difference)
+
+	return
+}
+
+func (s reconciliation) getPendingAmount(ctx context.Context, db database.DB) (database.Money, error) {
+	var pending struct {
+		Credit database.Money
+		Debit  database.Money
+	}
+
+	if err := db.WithContext(ctx).
+		Model(new(Operation)).
+		Select("coalesce(sum(case when type = 'Credit' then account_value else 0 end), 0) as credit, "+
+			"coalesce(sum(case when type = 'Debit' then account_value else 0 end), 0) as debit").
+		Where("account_id = ? and status = ? and debiting_time is null", s.accountId, "OK").
+		Scan(&pending).
+		Error; err != nil {
+		return 0, err
+	}
+
+	return pending.Credit - pending.Debit, nil
+}
+
+func (s reconciliation) storeReconciliation(ctx context.Context, client firefly.Invoker, account Account, difference database.Money) (string, error) {
+	split := firefly.TransactionSplitStore{
+		Date:        s.now,
+		Description: "Сверка баланса",
+	}
+
+	if currency := account.Currency; currency != nil && currency.FireflyId != nil {
+		split.CurrencyID = firefly.NewOptNilString(*currency.FireflyId)
+	}
+
+	if difference > 0 {
+		split.Type = firefly.TransactionTypePropertyDeposit
+		split.Amount = difference.String()
+		split.SourceName = firefly.NewOptNilString(s.Account)
+		split.DestinationID = firefly.NewOptNilString(s.fireflyAccountId)
+	} else {
+		split.Type = firefly.TransactionTypePropertyWithdrawal
+		split.Amount = (-difference).String()
+		split.SourceID = firefly.NewOptNilString(s.fireflyAccountId)
+		split.DestinationName = firefly.NewOptNilString(s.Account)
+	}
+
+	return storeSplit(ctx, client, split)
+}
+
+// getAccountBalanceValue returns the account balance as it is tracked in Firefly III.
+// Credit card balance is the negated debt, that is the available amount minus the credit limit.
+func getAccountBalanceValue(account Account) (database.Money, bool) {
+	if account.MoneyAmount == nil {
+		return 0, false
+	}
+
+	balance := account.MoneyAmount.MoneyAmountValue
+	if account.AccountType == "Credit" {
+		if account.CreditLimit == nil {
+			return 0, false
+		}
+
+		balance -= account.CreditLimit.CreditLimitValue
+	}
+
+	return balance, true
+}
@@ -0,0 +1,112 @@
+package firefly
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/jfk9w/hoarder/internal/database"
+	"github.com/jfk9w/hoarder/internal/firefly"
+	. "github.com/jfk9w/hoarder/internal/jobs/tbank/internal/entities"
+)
+
+// balanceInvoker reports the balance of every account and fails to store transactions if failing is set.
+type balanceInvoker struct {
+	*fakeInvoker
+	balance string
+	failing bool
+}
+
+func (f *balanceInvoker) GetAccount(_ context.Context, params firefly.GetAccountParams) (firefly.GetAccountRes, error) {
+	return &firefly.AccountSingle{Data: firefly.AccountRead{
+		ID:         params.ID,
+		Attributes: firefly.Account{CurrentBalance: firefly.NewOptString(f.balance)},
+	}}, nil
+}
+
+func (f *balanceInvoker) StoreTransaction(ctx context.Context, in *firefly.TransactionStore, params firefly.StoreTransactionParams) (firefly.StoreTransactionRes, error) {
+	if f.failing {
+		return nil, errors.New("store failed")
+	}
+
+	return f.fakeInvoker.StoreTransaction(ctx, in, params)
+}
+
+// reconcile syncs transactions of account a and then compares its balance of 100 with the Firefly III balance of 50.
+func (test *pairingTest) reconcile(failing bool) BalanceMismatch {
+	test.t.Helper()
+	if err := test.db.Model(new(Account)).
+		Where("id = ?", "a").
+		Updates(map[string]any{"money_amount_currency_code": 643, "money_amount_value": database.MoneyFromFloat(100)}).
+		Error; err != nil {
+		test.t.Fatalf("update balance: %v", err)
+	}
+
+	client := firefly.Instance{
+		Invoker:     &balanceInvoker{fakeInvoker: test.client, balance: "50", failing: failing},
+		ID:          testInstance,
+		Concurrency: 1,
+	}
+
+	now := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	status := new(transactionsStatus)
+	_, err := (transactions{accountId: "a", batchSize: 10, status: status}).Sync(test.ctx, test.db, client)
+	if failing != (err != nil) {
+		test.t.Fatalf("expected transactions sync error: %t, got %v", failing, err)
+	}
+
+	if status.failed != failing {
+		test.t.Fatalf("expected failed status %t, got %t", failing, status.failed)
+	}
+
+	if _, err := (reconciliation{
+		Reconciliation:   Reconciliation{Account: "Сверка"},
+		accountId:        "a",
+		fireflyAccountId: "account-a",
+		now:              now,
+		transactions:     status,
+	}).Sync(test.ctx, test.db, client); err != nil {
+		test.t.Fatalf("reconcile: %v", err)
+	}
+
+	var mismatch BalanceMismatch
+	if err := test.db.Where("instance = ? and account_id = ?", testInstance, "a").Take(&mismatch).Error; err != nil {
+		test.t.Fatalf("select balance mismatch: %v", err)
+	}
+
+	if mismatch.Difference != database.MoneyFromFloat(50) {
+		test.t.Errorf("expected difference 50, got %s", mismatch.Difference)
+	}
+
+	return mismatch
+}
+
+func TestReconciliation_Store(t *testing.T) {
+	test := newPairingTest(t)
+	mismatch := test.reconcile(false)
+
+	transaction := test.stored()
+	if transaction.Type != firefly.TransactionTypePropertyDeposit || transaction.Amount != "50.00" {
+		t.Errorf("expected deposit of 50.00, got %s of %s", transaction.Type, transaction.Amount)
+	}
+
+	if mismatch.FireflyId == nil {
+		t.Error("expected reconciliation transaction to be linked to the mismatch")
+	}
+}
+
+func TestReconciliation_FailedTransactions(t *testing.T) {
+	test := newPairingTest(t)
+	test.operation("1", "a", "Debit", time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC), 100, nil)
+	mismatch := test.reconcile(true)
+
+	if len(test.client.stored) != 0 {
+		t.Errorf("expected no reconciliation transaction, got %v", test.client.stored)
+	}
+
+	if mismatch.FireflyId != nil {
+		t.Errorf("expected mismatch not to be linked to a transaction, got %s", *mismatch.FireflyId)
+	}
+}
@@ -0,0 +1,133 @@
+package firefly
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"github.com/jfk9w/hoarder/internal/firefly"
+)
+
+// Objects are looked up in Firefly III by deterministic references before being stored,
+// so that an interrupted sync or a database restore does not lead to duplicates.
+// Transactions are referenced by external ids, asset accounts by account numbers holding record ids,
+// and categories and bills by their names, which are unique in Firefly III.
+
+// externalIdPrefix is prepended to references of records in external ids of Firefly III transactions.
+const externalIdPrefix = "tbank:"
+
+// reference returns the external id of a Firefly III transaction for the entity record.
+func reference(entity, key string) string {
+	return externalIdPrefix + entity + ":" + key
+}
+
+// findTransaction searches for the transaction by external id and returns an empty string if it does not exist.
+func findTransaction(ctx context.Context, client firefly.Invoker, externalId string) (string, error) {
+	out, err := client.SearchTransactions(ctx, firefly.SearchTransactionsParams{
+		Query: fmt.Sprintf("external_id_is:%q", externalId),
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	switch out := out.(type) {
+	case *firefly.TransactionArray:
+		for _, transaction := range out.Data {
+			for _, split := range transaction.Attributes.Transactions {
+				if split.ExternalID.Or("") == externalId {
+					return transaction.ID, nil
+				}
+			}
+		}
+
+		return "", nil
+	case *firefly.NotFound:
+		return "", nil
+	case firefly.Exception:
+		return "", firefly.ExceptionError(out)
+	default:
+		return "", errors.Errorf("%s", out)
+	}
+}
+
+// findAssetAccount searches for the asset account by account number and returns an empty string if it does not exist.
+func findAssetAccount(ctx context.Context, client firefly.Invoker, number string) (string, error) {
+	out, err := client.SearchAccounts(ctx, firefly.SearchAccountsParams{
+		Query: number,
+		Type:  firefly.NewOptAccountTypeFilter(firefly.AccountTypeFilterAsset),
+		Field: firefly.AccountSearchFieldFilterNumber,
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	switch out := out.(type) {
+	case *firefly.AccountArray:
+		for _, account := range out.Data {
+			if account.Attributes.AccountNumber.Or("") == number {
+				return account.ID, nil
+			}
+		}
+
+		return "", nil
+	case *firefly.NotFound:
+		return "", nil
+	case firefly.Exception:
+		return "", firefly.ExceptionError(out)
+	default:
+		return "", errors.Errorf("%s", out)
+	}
+}
+
+// findCategory searches for the category by name and returns an empty string if it does not exist.
+func findCategory(ctx context.Context, client firefly.Invoker, name string) (string, error) {
+	out, err := client.GetCategoriesAC(ctx, firefly.GetCategoriesACParams{Query: firefly.NewOptString(name)})
+	if err != nil {
+		return "", err
+	}
+
+	switch out := out.(type) {
+	case *firefly.AutocompleteCategoryArray:
+		for _, category := range *out {
+			if category.Name == name {
+				return category.ID, nil
+			}
+		}
+
+		return "", nil
+	case *firefly.NotFound:
+		return "", nil
+	case firefly.Exception:
+		return "", firefly.ExceptionError(out)
+	default:
+		return "", errors.Errorf("%s", out)
+	}
+}
+
+// findBill searches for the bill by name and returns an empty string if it does not exist.
+func findBill(ctx context.Context, client firefly.Invoker, name string) (string, error) {
+	out, err := client.GetBillsAC(ctx, firefly.GetBillsACParams{Query: firefly.NewOptString(name)})
+	if err != nil {
+		return "", err
+	}
+
+	switch out := out.(type) {
+	case *firefly.AutocompleteBillArray:
+		for _, bill := range *out {
+			if bill.Name == name {
+				return bill.ID, nil
+			}
+		}
+
+		return "", nil
+	case *firefly.NotFound:
+		return "", nil
+	case firefly.Exception:
+		return "", firefly.ExceptionError(out)
+	default:
+		return "", errors.Errorf("%s", out)
+	}
+}
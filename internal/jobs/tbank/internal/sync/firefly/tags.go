@@ -0,0 +1,81 @@
+package firefly
+
+import (
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/AlekSi/pointer"
+	"github.com/pkg/errors"
+
+	. "github.com/jfk9w/hoarder/internal/jobs/tbank/internal/entities"
+)
+
+// TagRule attaches tags and notes to Firefly III transactions of matching operations.
+// All specified conditions must hold for a rule to match.
+type TagRule struct {
+	Mcc            []uint
+	Cards          []string
+	CardPresent    *bool
+	IsExternalCard *bool
+	HasCashback    *bool
+	HasLoyalty     *bool
+	HasLocation    *bool
+	Tags           []string
+
+	// Notes is executed with the operation.
+	Notes *template.Template
+}
+
+func (r *TagRule) match(operation *Operation) bool {
+	switch {
+	case len(r.Mcc) > 0 && !slices.Contains(r.Mcc, operation.Mcc):
+		return false
+	case len(r.Cards) > 0 &&
+		!slices.Contains(r.Cards, pointer.Get(operation.Card)) &&
+		!slices.Contains(r.Cards, pointer.Get(operation.CardNumber)):
+		return false
+	case r.CardPresent != nil && *r.CardPresent != operation.CardPresent:
+		return false
+	case r.IsExternalCard != nil && *r.IsExternalCard != operation.IsExternalCard:
+		return false
+	case r.HasCashback != nil && *r.HasCashback != (operation.Cashback > 0 || operation.CashbackAmount.CashbackValue > 0):
+		return false
+	case r.HasLoyalty != nil && *r.HasLoyalty != (len(operation.LoyaltyBonus) > 0 || len(operation.LoyaltyPayment) > 0):
+		return false
+	case r.HasLocation != nil && *r.HasLocation != (len(operation.Locations) > 0):
+		return false
+	}
+
+	return true
+}
+
+// applyTagRules returns sorted unique tags and notes of all rules matching the operation.
+func applyTagRules(rules []TagRule, operation *Operation) ([]string, string, error) {
+	var (
+		tags  []string
+		notes []string
+	)
+
+	for i := range rules {
+		rule := &rules[i]
+		if !rule.match(operation) {
+			continue
+		}
+
+		tags = append(tags, rule.Tags...)
+		if rule.Notes != nil {
+			var b strings.Builder
+			if err := rule.Notes.Execute(&b, operation); err != nil {
+				return nil, "", errors.Wrapf(err, "execute notes template in rule %d", i)
+			}
+
+			if text := strings.TrimSpace(b.String()); text != "" {
+				notes = append(notes, text)
+			}
+		}
+	}
+
+	slices.Sort(tags)
+	return slices.Compact(tags), strings.Join(notes, "\n"), nil
+}
@@ -2,10 +2,17 @@ package firefly
 
 import (
 	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/AlekSi/pointer"
 	"github.com/pkg/errors"
+	"go.uber.org/multierr"
+	"gorm.io/gorm/clause"
 
 	"github.com/jfk9w/hoarder/internal/database"
 	"github.com/jfk9w/hoarder/internal/firefly"
@@ -13,57 +20,288 @@ import (
 	. "github.com/jfk9w/hoarder/internal/jobs/tbank/internal/entities"
 )
 
+// pendingTransactionsEntity is the mapping entity marking transactions which are being stored in Firefly III.
+// Pending transactions are keyed by the external id and have no Firefly id.
+const pendingTransactionsEntity = "pending_transactions"
+
 type transactionQueryRow struct {
 	OperationId                     string
+	FireflyHash                     *string
 	OperationTime                   time.Time
 	DebitingTime                    time.Time
 	Description                     string
 	FireflyCategoryId               string
 	FireflyCurrencyId               string
-	Amount                          string
+	Amount                          database.Money
 	FireflyForeignCurrencyId        string
-	ForeignAmount                   string
+	ForeignAmount                   database.Money
 	SourceOperationId               *string
 	FireflySourceTransactionId      *string
 	FireflySourceAccountId          *string
 	DestinationOperationId          *string
 	FireflyDestinationTransactionId *string
 	FireflyDestinationAccountId     *string
+	BrandName                       *string
+	MerchantName                    *string
+	ProviderId                      *string
+	SenderDetails                   *string
+	FireflyBillId                   *string
+
+	Counterparty          string   `gorm:"-"`
+	FireflyCounterpartyId *string  `gorm:"-"`
+	Tags                  []string `gorm:"-"`
+	Notes                 string   `gorm:"-"`
+}
+
+// counterparty returns the first non-empty counterparty name candidate.
+// Sender details are preferred for deposits, brand and merchant names for withdrawals.
+func (r *transactionQueryRow) counterparty() string {
+	candidates := []*string{r.BrandName, r.MerchantName, r.ProviderId, r.SenderDetails}
+	if r.FireflySourceAccountId == nil {
+		candidates = []*string{r.SenderDetails, r.BrandName, r.MerchantName, r.ProviderId}
+	}
+
+	for _, candidate := range candidates {
+		if value := strings.TrimSpace(pointer.Get(candidate)); value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
+
+// hash returns the digest of the row fields which are propagated to Firefly III.
+// It must be calculated before setTransactionFields, which swaps some of the fields in place.
+func (r *transactionQueryRow) hash() string {
+	h := sha1.New()
+	_, _ = fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\x00%s\x00%s\x00%s\x00%s\x00%s\x00%s\x00%s\x00%s",
+		r.OperationTime.UTC().Format(time.RFC3339Nano), r.Description, r.FireflyCategoryId,
+		r.FireflyCurrencyId, r.Amount, r.FireflyForeignCurrencyId, r.ForeignAmount,
+		pointer.Get(r.FireflySourceAccountId), pointer.Get(r.FireflyDestinationAccountId),
+		pointer.Get(r.FireflyCounterpartyId), strings.Join(r.Tags, ","), r.Notes)
+	if r.FireflyBillId != nil {
+		_, _ = fmt.Fprintf(h, "\x00%s", *r.FireflyBillId)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// transactionId returns the id of the Firefly III transaction the row is synced to, if any.
+func (r *transactionQueryRow) transactionId() *string {
+	if r.FireflySourceTransactionId != nil {
+		return r.FireflySourceTransactionId
+	}
+
+	return r.FireflyDestinationTransactionId
+}
+
+// externalId returns the external id of the Firefly III transaction for the row.
+// It does not depend on the account being synced, so that transfers get the same id from both sides.
+func (r *transactionQueryRow) externalId() string {
+	operationId := r.SourceOperationId
+	if operationId == nil {
+		operationId = r.DestinationOperationId
+	}
+
+	if operationId == nil {
+		operationId = &r.OperationId
+	}
+
+	return reference(new(Operation).TableName(), *operationId)
+}
+
+// applyOverride replaces row fields with values changed by the user in Firefly III.
+func (r *transactionQueryRow) applyOverride(override *FireflyOverride) {
+	if override.Description != nil {
+		r.Description = *override.Description
+	}
+
+	if override.CategoryId != nil {
+		r.FireflyCategoryId = *override.CategoryId
+	}
+
+	if override.CounterpartyId != nil && (r.FireflySourceAccountId == nil) != (r.FireflyDestinationAccountId == nil) {
+		r.FireflyCounterpartyId = override.CounterpartyId
+	}
 }
 
 type transactions struct {
-	accountId string
-	batchSize int
+	accountId         string
+	batchSize         int
+	counterpartyRules []CounterpartyRule
+	tagRules          []TagRule
+	since             time.Time
+	lookup            bool
+	status            *transactionsStatus
+}
+
+// transactionsStatus is the outcome of the transactions sync of an account, which is used by later syncs of the account.
+type transactionsStatus struct {
+	failed bool
 }
 
 func (s transactions) TableName() string {
 	return "transactions"
 }
 
-func (s transactions) Sync(ctx jobs.Context, db database.DB, client firefly.Invoker) ([]Interface, error) {
+func (s transactions) Sync(ctx jobs.Context, db database.DB, client firefly.Instance) (_ []Interface, errs error) {
+	if s.status != nil {
+		defer func() { s.status.failed = errs != nil }()
+	}
+
 	ctx = ctx.With("account_id", s.accountId)
-	return nil, jobs.Batch[int]{
-		Key:  "offset",
+	mappings := mappings{db: db, instance: client.ID}
+	batch := transactionsBatch{
+		db:          db,
+		client:      client,
+		concurrency: client.Concurrency,
+		batchSize:   s.batchSize,
+		mappings:    mappings,
+		accountId:   s.accountId,
+		tagRules:    s.tagRules,
+		lookup:      s.lookup,
+		pairing: &pairing{
+			db:        db,
+			mappings:  mappings,
+			accountId: s.accountId,
+			since:     s.since,
+		},
+		counterparties: &counterparties{
+			mappings: mappings,
+			client:   client,
+			rules:    s.counterpartyRules,
+		},
+	}
+
+	if err := batch.deleteFailed(ctx); ctx.Error(&errs, err, "failed to delete failed operations") {
+		return
+	}
+
+	return nil, jobs.Batch[string]{
+		Key:  "after",
 		Size: s.batchSize,
-	}.Run(ctx, transactionsBatch{
-		db:        db,
-		client:    client,
-		accountId: s.accountId,
-	}.sync)
+	}.Run(ctx, batch.sync)
 }
 
 type transactionsBatch struct {
-	db        database.DB
-	client    firefly.Invoker
-	accountId string
+	db             database.DB
+	client         firefly.Invoker
+	concurrency    int
+	batchSize      int
+	mappings       mappings
+	accountId      string
+	tagRules       []TagRule
+	lookup         bool
+	pairing        *pairing
+	counterparties *counterparties
 }
 
-func (s transactionsBatch) sync(ctx jobs.Context, offset int, limit int) (nextOffset *int, errs error) {
-	var rows []transactionQueryRow
-	if err := s.db.WithContext(ctx).
-		Raw(transactionQuerySQL, s.accountId, limit).
-		Scan(&rows).
-		Error; ctx.Error(&errs, err, "failed to query operations") {
+// deleteFailed deletes transactions for operations which turned FAILED after being synced.
+// Firefly ids are cleared on all operations sharing the transaction, so that the remaining
+// transfer counterpart is stored again on its own.
+func (s transactionsBatch) deleteFailed(ctx jobs.Context) (errs error) {
+	var transactionIds []string
+	if err := s.mappings.join(s.db.WithContext(ctx).Model(new(Operation)), "fm", new(Operation).TableName(), "operations.id").
+		Distinct("fm.firefly_id").
+		Where("account_id = ? and status = ? and fm.firefly_id is not null", s.accountId, "FAILED").
+		Pluck("fm.firefly_id", &transactionIds).
+		Error; err != nil {
+		return errors.Wrap(err, "select failed operations")
+	}
+
+	for _, transactionId := range transactionIds {
+		ctx := ctx.With("firefly_id", transactionId)
+		if err := deleteTransaction(ctx, s.client, transactionId); ctx.Error(&errs, err, "failed to delete transaction") {
+			continue
+		}
+
+		if err := s.mappings.deleteFireflyId(ctx, transactionId,
+			new(Operation).TableName(), new(InvestOperation).TableName()); ctx.Error(&errs, err, "failed to delete firefly id from db") {
+			continue
+		}
+
+		ctx.Info("deleted transaction for failed operation")
+	}
+
+	return
+}
+
+// transactionTask is a transaction to be stored or updated in Firefly III.
+type transactionTask struct {
+	ctx  jobs.Context
+	row  transactionQueryRow
+	hash string
+
+	// transactionId is the id of the transaction to update, nil if the transaction is to be stored.
+	transactionId *string
+
+	// pending is set if the transaction may have been stored by an interrupted run.
+	pending bool
+
+	fireflyId string
+	err       error
+	errMsg    string
+}
+
+func (s transactionsBatch) sync(ctx jobs.Context, after string, limit int) (nextAfter *string, errs error) {
+	rows, lastId, err := s.pairing.rows(ctx, after, limit)
+	if ctx.Error(&errs, err, "failed to query operations") {
+		return
+	}
+
+	if lastId != "" {
+		nextAfter = &lastId
+	}
+
+	tasks, err := s.prepare(ctx, rows)
+	_ = multierr.AppendInto(&errs, err)
+	if len(tasks) == 0 {
+		return
+	}
+
+	if err := s.beforeRequests(ctx, tasks); ctx.Error(&errs, err, "failed to save pending transactions") {
+		return
+	}
+
+	parallel(ctx, s.concurrency, tasks, s.request)
+
+	var done []*transactionTask
+	for _, task := range tasks {
+		if task.ctx.Error(&errs, task.err, task.errMsg) {
+			continue
+		}
+
+		done = append(done, task)
+	}
+
+	if err := s.afterRequests(ctx, done); ctx.Error(&errs, err, "failed to update firefly ids in db") {
+		return
+	}
+
+	for _, task := range done {
+		if task.transactionId != nil && *task.transactionId == task.fireflyId {
+			task.ctx.Debug("updated transaction", "firefly_id", task.fireflyId)
+		}
+	}
+
+	return
+}
+
+// prepare resolves counterparties, tags and overrides of the rows sequentially,
+// since these may create shared objects in Firefly III, and returns rows which need to be sent.
+func (s transactionsBatch) prepare(ctx jobs.Context, rows []transactionQueryRow) (tasks []*transactionTask, errs error) {
+	operations, err := s.selectOperations(ctx, rows)
+	if ctx.Error(&errs, err, "failed to select operations for tag rules") {
+		return
+	}
+
+	overrides, err := s.selectOverrides(ctx, rows)
+	if ctx.Error(&errs, err, "failed to select overrides") {
+		return
+	}
+
+	pending, err := s.selectPending(ctx, rows)
+	if ctx.Error(&errs, err, "failed to select pending transactions") {
 		return
 	}
 
@@ -88,11 +326,7 @@ func (s transactionsBatch) sync(ctx jobs.Context, offset int, limit int) (nextOf
 				continue
 			}
 
-			if err := s.db.WithContext(ctx).
-				Table(new(Operation).TableName()).
-				Where("id = ?", *operationId).
-				Update("firefly_id", nil).
-				Error; ctx.Error(&errs, err, "failed to delete firefly id from db") {
+			if err := s.mappings.delete(ctx, new(Operation).TableName(), *operationId); ctx.Error(&errs, err, "failed to delete firefly id from db") {
 				continue
 			}
 
@@ -100,31 +334,184 @@ func (s transactionsBatch) sync(ctx jobs.Context, offset int, limit int) (nextOf
 			row.FireflyDestinationTransactionId = nil
 		}
 
-		if row.FireflySourceTransactionId != nil || row.FireflyDestinationTransactionId != nil {
+		if err := s.setCounterparty(ctx, &row); ctx.Error(&errs, err, "failed to resolve counterparty") {
 			continue
 		}
 
-		fireflyId, err := storeTransaction(ctx, s.client, &row)
-		if ctx.Error(&errs, err, "failed to store transaction") {
-			continue
+		if operation, ok := operations[row.OperationId]; ok {
+			row.Tags, row.Notes, err = applyTagRules(s.tagRules, operation)
+			if ctx.Error(&errs, err, "failed to apply tag rules") {
+				continue
+			}
+		}
+
+		transactionId := row.transactionId()
+		if override := overrides[pointer.Get(transactionId)]; override != nil {
+			if override.Deleted {
+				continue
+			}
+
+			row.applyOverride(override)
 		}
 
-		if err := s.db.WithContext(ctx).
-			Table(new(Operation).TableName()).
-			Where("id in ?", []any{row.SourceOperationId, row.DestinationOperationId}).
-			Update("firefly_id", fireflyId).
-			Error; ctx.Error(&errs, err, "failed to update firefly id in db") {
+		hash := row.hash()
+		if transactionId != nil && pointer.Get(row.FireflyHash) == hash {
 			continue
 		}
-	}
 
-	if len(rows) == limit {
-		nextOffset = pointer.To(offset + limit)
+		tasks = append(tasks, &transactionTask{
+			ctx:           ctx,
+			row:           row,
+			hash:          hash,
+			transactionId: transactionId,
+			pending:       s.lookup || pending[row.externalId()],
+		})
 	}
 
 	return
 }
 
+// beforeRequests saves values sent with updates and marks transactions to be stored as pending,
+// so that an interrupted run does not lead to duplicate transactions.
+func (s transactionsBatch) beforeRequests(ctx context.Context, tasks []*transactionTask) error {
+	var (
+		sent    []FireflyOverride
+		pending []FireflyMapping
+	)
+
+	for _, task := range tasks {
+		if task.transactionId != nil {
+			sent = append(sent, s.sent(*task.transactionId, &task.row))
+		} else {
+			pending = append(pending, FireflyMapping{
+				Instance: s.mappings.instance,
+				Entity:   pendingTransactionsEntity,
+				Key:      task.row.externalId(),
+			})
+		}
+	}
+
+	return s.db.WithContext(ctx).Transaction(func(tx database.DB) error {
+		if err := s.saveSent(ctx, tx, sent); err != nil {
+			return errors.Wrap(err, "save sent values")
+		}
+
+		if len(pending) > 0 {
+			if err := tx.UpsertInBatches(pending, s.batchSize).Error; err != nil {
+				return errors.Wrap(err, "save pending transactions")
+			}
+		}
+
+		return nil
+	})
+}
+
+// request stores or updates the transaction in Firefly III.
+// Transactions which could have been stored by an interrupted run are looked up by external id first.
+// Rows are copied for each request, since setTransactionFields modifies them in place.
+func (s transactionsBatch) request(ctx context.Context, task *transactionTask) {
+	if task.transactionId != nil {
+		row := task.row
+		found, err := updateTransaction(ctx, s.client, *task.transactionId, &row)
+		if err != nil {
+			task.err, task.errMsg = err, "failed to update transaction"
+			return
+		}
+
+		if found {
+			task.fireflyId = *task.transactionId
+			return
+		}
+
+		task.pending = true
+	}
+
+	if task.pending {
+		fireflyId, err := findTransaction(ctx, s.client, task.row.externalId())
+		if err != nil {
+			task.err, task.errMsg = err, "failed to find pending transaction"
+			return
+		}
+
+		if fireflyId != "" {
+			row := task.row
+			if _, err := updateTransaction(ctx, s.client, fireflyId, &row); err != nil {
+				task.err, task.errMsg = err, "failed to update pending transaction"
+				return
+			}
+
+			task.fireflyId = fireflyId
+			return
+		}
+	}
+
+	row := task.row
+	fireflyId, err := storeTransaction(ctx, s.client, &row)
+	if err != nil {
+		task.err, task.errMsg = err, "failed to store transaction"
+		return
+	}
+
+	task.fireflyId = fireflyId
+}
+
+// afterRequests saves Firefly ids and hashes of the sent transactions and clears pending marks in a single database transaction.
+func (s transactionsBatch) afterRequests(ctx context.Context, tasks []*transactionTask) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+
+	var (
+		mappings []FireflyMapping
+		sent     []FireflyOverride
+		pending  []string
+	)
+
+	for _, task := range tasks {
+		for _, operationId := range []*string{task.row.SourceOperationId, task.row.DestinationOperationId} {
+			if operationId == nil {
+				continue
+			}
+
+			mappings = append(mappings, FireflyMapping{
+				Instance:  s.mappings.instance,
+				Entity:    new(Operation).TableName(),
+				Key:       *operationId,
+				FireflyId: task.fireflyId,
+				Hash:      &task.hash,
+			})
+		}
+
+		if task.transactionId == nil || *task.transactionId != task.fireflyId {
+			sent = append(sent, s.sent(task.fireflyId, &task.row))
+			pending = append(pending, task.row.externalId())
+		}
+	}
+
+	return s.db.WithContext(ctx).Transaction(func(tx database.DB) error {
+		if len(mappings) > 0 {
+			if err := tx.UpsertInBatches(mappings, s.batchSize).Error; err != nil {
+				return errors.Wrap(err, "save firefly ids")
+			}
+		}
+
+		if err := s.saveSent(ctx, tx, sent); err != nil {
+			return errors.Wrap(err, "save sent values")
+		}
+
+		if len(pending) > 0 {
+			if err := tx.
+				Where("instance = ? and entity = ? and entity_key in ?", s.mappings.instance, pendingTransactionsEntity, pending).
+				Delete(new(FireflyMapping)).
+				Error; err != nil {
+				return errors.Wrap(err, "delete pending transactions")
+			}
+		}
+
+		return nil
+	})
+}
+
 type transaction interface {
 	SetProcessDate(firefly.OptNilDateTime)
 	SetCategoryID(firefly.OptNilString)
@@ -133,6 +520,9 @@ type transaction interface {
 	SetForeignAmount(firefly.OptNilString)
 	SetSourceID(firefly.OptNilString)
 	SetDestinationID(firefly.OptNilString)
+	SetTags(firefly.OptNilStringArray)
+	SetNotes(firefly.OptNilString)
+	SetBillID(firefly.OptNilString)
 }
 
 func deleteTransaction(ctx context.Context, client firefly.Invoker, transactionId string) error {
@@ -144,8 +534,8 @@ func deleteTransaction(ctx context.Context, client firefly.Invoker, transactionI
 	switch out := out.(type) {
 	case *firefly.DeleteTransactionNoContent, *firefly.NotFound:
 		return nil
-	case exception:
-		return exception2error(out)
+	case firefly.Exception:
+		return firefly.ExceptionError(out)
 	default:
 		return errors.Errorf("%s", out)
 	}
@@ -157,7 +547,8 @@ func storeTransaction(ctx context.Context, client firefly.Invoker, row *transact
 	transaction.SetType(transactionType)
 	transaction.SetDate(row.OperationTime)
 	transaction.SetDescription(row.Description)
-	transaction.SetAmount(row.Amount)
+	transaction.SetAmount(row.Amount.String())
+	transaction.SetExternalID(firefly.NewOptNilString(row.externalId()))
 
 	in := &firefly.TransactionStore{
 		Transactions: []firefly.TransactionSplitStore{
@@ -173,16 +564,247 @@ func storeTransaction(ctx context.Context, client firefly.Invoker, row *transact
 	switch out := out.(type) {
 	case *firefly.TransactionSingle:
 		return out.Data.ID, nil
-	case exception:
-		return "", exception2error(out)
+	case firefly.Exception:
+		return "", firefly.ExceptionError(out)
 	default:
 		return "", errors.Errorf("%s", out)
 	}
 }
 
+// parallel runs fn for each of the tasks with at most concurrency tasks running at once.
+func parallel[T any](ctx context.Context, concurrency int, tasks []T, fn func(ctx context.Context, task T)) {
+	var (
+		wg        sync.WaitGroup
+		semaphore = make(chan struct{}, max(concurrency, 1))
+	)
+
+	for _, task := range tasks {
+		semaphore <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+
+			fn(ctx, task)
+		}()
+	}
+
+	wg.Wait()
+}
+
+// selectOperations loads operations with related entities used by tag rules.
+func (s transactionsBatch) selectOperations(ctx context.Context, rows []transactionQueryRow) (map[string]*Operation, error) {
+	if len(s.tagRules) == 0 || len(rows) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]string, len(rows))
+	for i, row := range rows {
+		ids[i] = row.OperationId
+	}
+
+	var operations []Operation
+	if err := s.db.WithContext(ctx).
+		Preload("Locations").
+		Preload("LoyaltyBonus").
+		Preload("LoyaltyPayment").
+		Where("id in ?", ids).
+		Find(&operations).
+		Error; err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]*Operation, len(operations))
+	for i := range operations {
+		result[operations[i].Id] = &operations[i]
+	}
+
+	return result, nil
+}
+
+// selectOverrides loads user overrides of the transactions the rows are synced to.
+func (s transactionsBatch) selectOverrides(ctx context.Context, rows []transactionQueryRow) (map[string]*FireflyOverride, error) {
+	var ids []string
+	for i := range rows {
+		if id := rows[i].transactionId(); id != nil {
+			ids = append(ids, *id)
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	var overrides []FireflyOverride
+	if err := s.db.WithContext(ctx).
+		Where("instance = ? and firefly_id in ?", s.mappings.instance, ids).
+		Find(&overrides).
+		Error; err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]*FireflyOverride, len(overrides))
+	for i := range overrides {
+		result[overrides[i].FireflyId] = &overrides[i]
+	}
+
+	return result, nil
+}
+
+// selectPending returns external ids of the rows which were marked as pending by an interrupted run.
+func (s transactionsBatch) selectPending(ctx context.Context, rows []transactionQueryRow) (map[string]bool, error) {
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]string, len(rows))
+	for i := range rows {
+		ids[i] = rows[i].externalId()
+	}
+
+	var pending []string
+	if err := s.db.WithContext(ctx).
+		Model(new(FireflyMapping)).
+		Where("instance = ? and entity = ? and entity_key in ?", s.mappings.instance, pendingTransactionsEntity, ids).
+		Pluck("entity_key", &pending).
+		Error; err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]bool, len(pending))
+	for _, id := range pending {
+		result[id] = true
+	}
+
+	return result, nil
+}
+
+// sent returns the values sent to Firefly III with the transaction.
+func (s transactionsBatch) sent(transactionId string, row *transactionQueryRow) FireflyOverride {
+	return FireflyOverride{
+		Instance:           s.mappings.instance,
+		FireflyId:          transactionId,
+		SentDescription:    row.Description,
+		SentCategoryId:     row.FireflyCategoryId,
+		SentCounterpartyId: pointer.Get(row.FireflyCounterpartyId),
+	}
+}
+
+// saveSent remembers the values sent to Firefly III, so that webhooks caused by the sync are not taken for user changes.
+// Updates trigger webhooks before the response is received, so the values are saved before the requests.
+func (s transactionsBatch) saveSent(ctx context.Context, db database.DB, sent []FireflyOverride) error {
+	if len(sent) == 0 {
+		return nil
+	}
+
+	return db.WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "instance"}, {Name: "firefly_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"sent_description", "sent_category_id", "sent_counterparty_id"}),
+		}).
+		CreateInBatches(sent, s.batchSize).
+		Error
+}
+
+// setCounterparty resolves the expense or revenue account for non-transfer transactions.
+func (s transactionsBatch) setCounterparty(ctx context.Context, row *transactionQueryRow) error {
+	var accountType firefly.ShortAccountTypeProperty
+	switch {
+	case row.FireflySourceAccountId == nil:
+		accountType = firefly.ShortAccountTypePropertyRevenue
+	case row.FireflyDestinationAccountId == nil:
+		accountType = firefly.ShortAccountTypePropertyExpense
+	default:
+		return nil
+	}
+
+	name := NormalizeCounterparty(s.counterparties.rules, row.counterparty())
+	if name == "" {
+		return nil
+	}
+
+	id, err := s.counterparties.resolve(ctx, name, accountType)
+	if err != nil {
+		return errors.Wrapf(err, "resolve %s account %q", accountType, name)
+	}
+
+	row.Counterparty = name
+	row.FireflyCounterpartyId = &id
+	return nil
+}
+
+// updateTransaction updates the first split of an existing transaction, keeping its journal id.
+// It returns false if the transaction no longer exists in Firefly III.
+func updateTransaction(ctx context.Context, client firefly.Invoker, transactionId string, row *transactionQueryRow) (bool, error) {
+	current, err := client.GetTransaction(ctx, firefly.GetTransactionParams{ID: transactionId})
+	if err != nil {
+		return false, err
+	}
+
+	var journalId firefly.OptString
+	switch current := current.(type) {
+	case *firefly.TransactionSingle:
+		if splits := current.Data.Attributes.Transactions; len(splits) > 0 {
+			journalId = splits[0].TransactionJournalID
+		}
+	case *firefly.NotFound:
+		return false, nil
+	case firefly.Exception:
+		return false, firefly.ExceptionError(current)
+	default:
+		return false, errors.Errorf("%s", current)
+	}
+
+	var transaction firefly.TransactionSplitUpdate
+	transactionType := setTransactionFields(row, &transaction)
+	// empty tags and notes are sent as well to clear the ones of rules which no longer match
+	transaction.SetTags(firefly.NewOptNilStringArray(row.Tags))
+	transaction.SetNotes(firefly.NewOptNilString(row.Notes))
+	transaction.SetTransactionJournalID(journalId)
+	transaction.SetType(firefly.NewOptTransactionTypeProperty(transactionType))
+	transaction.SetDate(firefly.NewOptDateTime(row.OperationTime))
+	transaction.SetDescription(firefly.NewOptString(row.Description))
+	transaction.SetAmount(firefly.NewOptString(row.Amount.String()))
+
+	in := &firefly.TransactionUpdate{
+		Transactions: []firefly.TransactionSplitUpdate{
+			transaction,
+		},
+	}
+
+	out, err := client.UpdateTransaction(ctx, in, firefly.UpdateTransactionParams{ID: transactionId})
+	if err != nil {
+		return false, err
+	}
+
+	switch out := out.(type) {
+	case *firefly.TransactionSingle:
+		return true, nil
+	case *firefly.NotFound:
+		return false, nil
+	case firefly.Exception:
+		return false, firefly.ExceptionError(out)
+	default:
+		return false, errors.Errorf("%s", out)
+	}
+}
+
 func setTransactionFields(row *transactionQueryRow, transaction transaction) firefly.TransactionTypeProperty {
 	transaction.SetProcessDate(firefly.NewOptNilDateTime(row.OperationTime))
 	transaction.SetCategoryID(firefly.NewOptNilString(row.FireflyCategoryId))
+	if len(row.Tags) > 0 {
+		transaction.SetTags(firefly.NewOptNilStringArray(row.Tags))
+	}
+
+	if row.Notes != "" {
+		transaction.SetNotes(firefly.NewOptNilString(row.Notes))
+	}
+
+	if row.FireflyBillId != nil {
+		transaction.SetBillID(firefly.NewOptNilString(*row.FireflyBillId))
+	}
 
 	var transactionType firefly.TransactionTypeProperty
 	if row.FireflySourceAccountId != nil {
@@ -190,6 +812,10 @@ func setTransactionFields(row *transactionQueryRow, transaction transaction) fir
 		transaction.SetSourceID(firefly.NewOptNilString(*row.FireflySourceAccountId))
 	} else {
 		transactionType = firefly.TransactionTypePropertyDeposit
+		if row.FireflyCounterpartyId != nil {
+			transaction.SetSourceID(firefly.NewOptNilString(*row.FireflyCounterpartyId))
+		}
+
 		row.FireflyCurrencyId, row.FireflyForeignCurrencyId = row.FireflyForeignCurrencyId, row.FireflyCurrencyId
 		row.Amount, row.ForeignAmount = row.ForeignAmount, row.Amount
 	}
@@ -200,52 +826,15 @@ func setTransactionFields(row *transactionQueryRow, transaction transaction) fir
 		}
 
 		transaction.SetDestinationID(firefly.NewOptNilString(*row.FireflyDestinationAccountId))
+	} else if row.FireflyCounterpartyId != nil {
+		transaction.SetDestinationID(firefly.NewOptNilString(*row.FireflyCounterpartyId))
 	}
 
 	transaction.SetCurrencyID(firefly.NewOptNilString(row.FireflyCurrencyId))
 	if row.FireflyCurrencyId != row.FireflyForeignCurrencyId || transactionType == firefly.TransactionTypePropertyTransfer {
 		transaction.SetForeignCurrencyID(firefly.NewOptNilString(row.FireflyForeignCurrencyId))
-		transaction.SetForeignAmount(firefly.NewOptNilString(row.ForeignAmount))
+		transaction.SetForeignAmount(firefly.NewOptNilString(row.ForeignAmount.String()))
 	}
 
 	return transactionType
 }
-
-const transactionQuerySQL = `
-with o as (select *, row_number() over (order by operation_time, id::bigint) as num
-           from operations
-           where status = 'OK'
-             and debiting_time is not null)
-select coalesce(lo.id, ro.id)                             as operation_id,
-       coalesce(lo.firefly_id, ro.firefly_id)             as firefly_id,
-       coalesce(lo.operation_time, ro.operation_time)     as operation_time,
-       coalesce(lo.debiting_time, ro.debiting_time)       as debiting_time,
-       coalesce(lo.description, ro.description)           as description,
-       sc.firefly_id                                      as firefly_category_id,
-       lc.firefly_id                                      as firefly_currency_id,
-       coalesce(lo.account_value, ro.value)::text         as amount,
-       rc.firefly_id                                      as firefly_foreign_currency_id,
-       coalesce(ro.account_value, lo.value)::text         as foreign_amount,
-       case when la.firefly_id is not null then lo.id end as source_operation_id,
-       lo.firefly_id                                      as firefly_source_transaction_id,
-       la.firefly_id                                      as firefly_source_account_id,
-       case when ra.firefly_id is not null then ro.id end as destination_operation_id,
-       ro.firefly_id                                      as firefly_destination_transaction_id,
-       ra.firefly_id                                      as firefly_destination_account_id
-from o as lo
-         full join o as ro on ro.sender_agreement = lo.account_id and
-                              (lo.num + 1 = ro.num or lo.operation_time = ro.operation_time) and
-                              (lo.value = ro.account_value or lo.account_value = ro.value) and
-                              (lo."group" = 'TRANSFER' and ro."group" = 'INCOME')
-         inner join spending_categories sc on coalesce(lo.spending_category_id, ro.spending_category_id) = sc.id
-         inner join currencies lc on coalesce(lo.account_currency_code, ro.currency_code) = lc.code
-         inner join currencies rc on coalesce(ro.account_currency_code, lo.currency_code) = rc.code
-         left join accounts la on lo.account_id = la.id
-         left join accounts ra on ro.account_id = ra.id
-where coalesce(lo.type, 'Debit') = 'Debit'
-  and coalesce(ro.type, 'Credit') = 'Credit'
-  and ? in (lo.account_id, ro.account_id)
-  and (la.firefly_id is not null and lo.firefly_id is null or ra.firefly_id is not null and ro.firefly_id is null)
-order by 1::bigint
-limit ?
-`
@@ -3,6 +3,9 @@ package tbank
 import (
 	"context"
 	"log/slog"
+	"regexp"
+	"sync"
+	"text/template"
 	"time"
 
 	"github.com/jfk9w-go/based"
@@ -12,6 +15,7 @@ import (
 
 	"github.com/jfk9w/hoarder/internal/common"
 	"github.com/jfk9w/hoarder/internal/database"
+	"github.com/jfk9w/hoarder/internal/events"
 	"github.com/jfk9w/hoarder/internal/firefly"
 	"github.com/jfk9w/hoarder/internal/jobs"
 	. "github.com/jfk9w/hoarder/internal/jobs/tbank/internal/entities"
@@ -28,17 +32,28 @@ type JobParams struct {
 	Logger        *slog.Logger `validate:"required"`
 	Config        Config       `validate:"required"`
 	ClientFactory ClientFactory
-	Firefly       firefly.Invoker
+	Firefly       *firefly.Instances
 	Selenium      *selenium.Service
+	Events        *events.Dispatcher
+
+	// FireflyResetters are called when Firefly III ids of the user are reset.
+	FireflyResetters []FireflyResetter
 }
 
 type Job struct {
-	users        map[string]map[string]pingingClient
-	batchSize    int
-	overlap      time.Duration
-	withReceipts bool
-	db           database.DB
-	firefly      firefly.Invoker
+	users            map[string]map[string]pingingClient
+	batchSize        int
+	overlap          time.Duration
+	withReceipts     bool
+	outbox           events.Outbox
+	db               database.DB
+	firefly          *firefly.Instances
+	fireflyConfig    FireflyConfig
+	fireflyResetters []FireflyResetter
+	counterparties   []fireflySync.CounterpartyRule
+	tagRules         []fireflySync.TagRule
+	log              *slog.Logger
+	fireflyMu        sync.Mutex
 }
 
 func NewJob(ctx context.Context, params JobParams) (*Job, error) {
@@ -58,18 +73,65 @@ func NewJob(ctx context.Context, params JobParams) (*Job, error) {
 		params.ClientFactory = defaultClientFactory
 	}
 
+	cipher, err := database.NewCipher(params.Config.Database.Encryption)
+	if err != nil {
+		return nil, errors.Wrap(err, "create cipher")
+	}
+
 	db, err := database.Open(ctx, database.Params{
-		Clock:    params.Clock,
-		Logger:   params.Logger.With(logs.Database(JobID)),
-		Config:   params.Config.Database,
-		Entities: entities,
+		Clock:      params.Clock,
+		Logger:     params.Logger.With(logs.Database(JobID)),
+		Config:     params.Config.Database,
+		Name:       JobID,
+		Migrations: getMigrations(cipher),
 	})
 
 	if err != nil {
 		return nil, err
 	}
 
-	storage := &storage{db: db}
+	if params.Events != nil {
+		params.Events.Register(JobID, db)
+	}
+
+	var counterparties []fireflySync.CounterpartyRule
+	for _, rule := range params.Config.Firefly.Counterparties {
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, errors.Wrapf(err, "compile counterparty pattern %q", rule.Pattern)
+		}
+
+		counterparties = append(counterparties, fireflySync.CounterpartyRule{Pattern: pattern, Name: rule.Name})
+	}
+
+	var tagRules []fireflySync.TagRule
+	for i, rule := range params.Config.Firefly.Rules {
+		var notes *template.Template
+		if rule.Notes != "" {
+			notes, err = template.New("notes").Parse(rule.Notes)
+			if err != nil {
+				return nil, errors.Wrapf(err, "parse notes template in rule %d", i)
+			}
+		}
+
+		tagRules = append(tagRules, fireflySync.TagRule{
+			Mcc:            rule.Mcc,
+			Cards:          rule.Cards,
+			CardPresent:    rule.CardPresent,
+			IsExternalCard: rule.IsExternalCard,
+			HasCashback:    rule.HasCashback,
+			HasLoyalty:     rule.HasLoyalty,
+			HasLocation:    rule.HasLocation,
+			Tags:           rule.Tags,
+			Notes:          notes,
+		})
+	}
+
+	storage := &storage{db: db, cipher: cipher}
+	if err := storage.encryptSecrets(); err != nil {
+		return nil, errors.Wrap(err, "encrypt secrets")
+	}
+
 	users := make(map[string]map[string]pingingClient)
 	for user, credentials := range params.Config.Users {
 		phones := make(map[string]pingingClient)
@@ -83,6 +145,7 @@ func NewJob(ctx context.Context, params JobParams) (*Job, error) {
 				},
 				SessionStorage: storage,
 				AuthFlow:       authFlow,
+				Transport:      newArchiveTransport(params, db, credential.Phone),
 			})
 
 			if err != nil {
@@ -97,12 +160,18 @@ func NewJob(ctx context.Context, params JobParams) (*Job, error) {
 	}
 
 	return &Job{
-		users:        users,
-		batchSize:    params.Config.BatchSize,
-		overlap:      params.Config.Overlap,
-		withReceipts: params.Config.WithReceipts,
-		db:           db,
-		firefly:      params.Firefly,
+		users:            users,
+		batchSize:        params.Config.BatchSize,
+		overlap:          params.Config.Overlap,
+		withReceipts:     params.Config.WithReceipts,
+		outbox:           params.Events.Outbox(),
+		db:               db,
+		firefly:          params.Firefly,
+		fireflyConfig:    params.Config.Firefly,
+		fireflyResetters: params.FireflyResetters,
+		counterparties:   counterparties,
+		tagRules:         tagRules,
+		log:              params.Logger,
 	}, nil
 }
 
@@ -136,7 +205,7 @@ func (j *Job) Run(ctx jobs.Context, now time.Time, userID string) (errs error) {
 		_ = multierr.AppendInto(&errs, err)
 	}
 
-	if err := j.executeFireflySync(ctx, userID); err != nil {
+	if err := j.executeFireflySync(ctx, now, userID, false); err != nil {
 		_ = multierr.AppendInto(&errs, err)
 	}
 
@@ -153,7 +222,7 @@ func (j *Job) executeLoaders(ctx jobs.Context, now time.Time, userID string, pho
 	var stack common.Stack[loaders.Interface]
 	stack.Push(
 		loaders.ClientOffers{Phone: phone, BatchSize: j.batchSize},
-		loaders.Accounts{Phone: phone, BatchSize: j.batchSize, Overlap: j.overlap, WithReceipts: j.withReceipts},
+		loaders.Accounts{Phone: phone, BatchSize: j.batchSize, Overlap: j.overlap, WithReceipts: j.withReceipts, Outbox: j.outbox},
 		loaders.InvestOperationTypes{BatchSize: j.batchSize},
 		loaders.InvestAccounts{Phone: phone, BatchSize: j.batchSize, Overlap: j.overlap, Now: now},
 	)
@@ -174,18 +243,40 @@ func (j *Job) executeLoaders(ctx jobs.Context, now time.Time, userID string, pho
 	return
 }
 
-func (j *Job) executeFireflySync(ctx jobs.Context, userID string) (errs error) {
-	if j.firefly == nil {
+// executeFireflySync syncs loaded data to Firefly III.
+// If lookup is set, transactions are looked up in Firefly III by external ids before being stored.
+func (j *Job) executeFireflySync(ctx jobs.Context, now time.Time, userID string, lookup bool) (errs error) {
+	client, ok := j.firefly.Get(userID)
+	if !ok {
 		return
 	}
 
-	var phones []string
-	for phone := range j.users[userID] {
-		phones = append(phones, phone)
+	j.fireflyMu.Lock()
+	defer j.fireflyMu.Unlock()
+
+	phones := j.getPhones(userID)
+
+	// operations are changed by loaders only within the overlap, others are synced again only if their hashes are reset
+	since := now.Add(-j.overlap)
+	if lookup {
+		since = time.Time{}
 	}
 
 	var stack common.Stack[fireflySync.Interface]
-	stack.Push(fireflySync.All{Phones: phones, BatchSize: j.batchSize})
+	stack.Push(fireflySync.All{
+		Phones:               phones,
+		BatchSize:            j.batchSize,
+		InvestOperationKinds: fireflySync.InvestOperationKinds(j.fireflyConfig.InvestOperations),
+		RevaluationAccount:   j.fireflyConfig.RevaluationAccount,
+		CounterpartyRules:    j.counterparties,
+		TagRules:             j.tagRules,
+		Reconciliation:       j.reconciliation(),
+		Bills:                j.fireflyConfig.Bills,
+		Cashback:             j.cashback(),
+		Now:                  now,
+		Since:                since,
+		Lookup:               lookup,
+	})
 
 	for {
 		sync, ok := stack.Pop()
@@ -194,7 +285,7 @@ func (j *Job) executeFireflySync(ctx jobs.Context, userID string) (errs error) {
 		}
 
 		ctx := ctx.With("entity", sync.TableName())
-		syncs, err := sync.Sync(ctx, j.db, j.firefly)
+		syncs, err := sync.Sync(ctx, j.db, client)
 		if !multierr.AppendInto(&errs, err) {
 			stack.Push(syncs...)
 		}
@@ -202,3 +293,66 @@ func (j *Job) executeFireflySync(ctx jobs.Context, userID string) (errs error) {
 
 	return
 }
+
+func (j *Job) reconciliation() *fireflySync.Reconciliation {
+	cfg := j.fireflyConfig.Reconciliation
+	if cfg == nil || !cfg.Enabled {
+		return nil
+	}
+
+	return &fireflySync.Reconciliation{Account: cfg.Account}
+}
+
+func (j *Job) cashback() *fireflySync.Cashback {
+	cfg := j.fireflyConfig.Cashback
+	if cfg == nil || !cfg.Enabled {
+		return nil
+	}
+
+	return &fireflySync.Cashback{
+		Account:        cfg.Account,
+		RevenueAccount: cfg.RevenueAccount,
+		Category:       cfg.Category,
+	}
+}
+
+// Retag forces an update of all Firefly III transactions synced for the user,
+// so that current tag and notes rules are applied to them.
+func (j *Job) Retag(ctx context.Context, now time.Time, userID string) error {
+	client, ok := j.firefly.Get(userID)
+	if !ok {
+		return errors.New("firefly is not configured")
+	}
+
+	phones := j.getPhones(userID)
+	if len(phones) == 0 {
+		return errors.Errorf("user %s not found", userID)
+	}
+
+	jobCtx := jobs.NewContext(ctx, j.log.With("job", JobID)).With("user", userID)
+	if err := j.db.WithContext(jobCtx).
+		Model(new(FireflyMapping)).
+		Where("instance = ? and entity = ?", client.ID, new(Operation).TableName()).
+		Where("entity_key in (?)", j.db.
+			Model(new(Operation)).
+			Select("id").
+			Where("account_id in (?)", j.db.
+				Model(new(Account)).
+				Select("id").
+				Where("user_phone in ?", phones))).
+		Update("hash", nil).
+		Error; err != nil {
+		return errors.Wrap(err, "reset firefly hashes")
+	}
+
+	return j.executeFireflySync(jobCtx, now, userID, false)
+}
+
+func (j *Job) getPhones(userID string) []string {
+	var phones []string
+	for phone := range j.users[userID] {
+		phones = append(phones, phone)
+	}
+
+	return phones
+}
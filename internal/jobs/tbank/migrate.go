@@ -0,0 +1,163 @@
+package tbank
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm/clause"
+
+	"github.com/jfk9w/hoarder/internal/database"
+	"github.com/jfk9w/hoarder/internal/firefly"
+	. "github.com/jfk9w/hoarder/internal/jobs/tbank/internal/entities"
+)
+
+// legacyFireflyIds describes a table which stored Firefly ids of the default instance in its records.
+type legacyFireflyIds struct {
+	model interface{ TableName() string }
+	key   string
+	hash  bool
+}
+
+var legacyFireflyIdTables = []legacyFireflyIds{
+	{model: new(Account), key: "id"},
+	{model: new(InvestAccount), key: "id"},
+	{model: new(Operation), key: "id", hash: true},
+	{model: new(InvestOperation), key: "internal_id"},
+	{model: new(SpendingCategory), key: "id"},
+	{model: new(Currency), key: "name"},
+}
+
+// legacyCounterparty is a record of the counterparties table which was replaced by Firefly ids mappings.
+type legacyCounterparty struct {
+	Name      string
+	Type      string
+	FireflyId string
+}
+
+const legacyCounterpartiesTable = "counterparties"
+
+// prepareFireflyMappings moves Firefly ids from record tables to mappings of the default instance.
+func prepareFireflyMappings(db database.DB) error {
+	migrator := db.Migrator()
+	if err := migrator.AutoMigrate(new(FireflyMapping)); err != nil {
+		return errors.Wrap(err, "create firefly mappings table")
+	}
+
+	for _, table := range legacyFireflyIdTables {
+		if err := table.migrate(db); err != nil {
+			return errors.Wrapf(err, "migrate %s", table.model.TableName())
+		}
+	}
+
+	if migrator.HasTable(legacyCounterpartiesTable) {
+		var counterparties []legacyCounterparty
+		if err := db.
+			Table(legacyCounterpartiesTable).
+			Where("firefly_id is not null").
+			Find(&counterparties).
+			Error; err != nil {
+			return errors.Wrap(err, "select counterparties")
+		}
+
+		mappings := make([]FireflyMapping, len(counterparties))
+		for i, counterparty := range counterparties {
+			mappings[i] = FireflyMapping{
+				Instance:  firefly.DefaultInstance,
+				Entity:    legacyCounterpartiesTable,
+				Key:       counterparty.Type + ":" + counterparty.Name,
+				FireflyId: counterparty.FireflyId,
+			}
+		}
+
+		if err := saveLegacyMappings(db, mappings); err != nil {
+			return errors.Wrap(err, "save counterparties")
+		}
+
+		if err := migrator.DropTable(legacyCounterpartiesTable); err != nil {
+			return errors.Wrap(err, "drop counterparties")
+		}
+	}
+
+	if migrator.HasTable(new(BalanceMismatch)) && !migrator.HasColumn(new(BalanceMismatch), "instance") {
+		if err := migrator.AddColumn(new(BalanceMismatch), "Instance"); err != nil {
+			return errors.Wrap(err, "add balance mismatch instance")
+		}
+
+		if err := db.
+			Model(new(BalanceMismatch)).
+			Where("1 = 1").
+			Update("instance", firefly.DefaultInstance).
+			Error; err != nil {
+			return errors.Wrap(err, "update balance mismatch instance")
+		}
+	}
+
+	return nil
+}
+
+func (t legacyFireflyIds) migrate(db database.DB) error {
+	migrator := db.Migrator()
+	if !migrator.HasTable(t.model) || !migrator.HasColumn(t.model, "firefly_id") {
+		return nil
+	}
+
+	query := db.Table(t.model.TableName())
+	if t.hash {
+		query = query.Select(t.key + " as entity_key, firefly_id, firefly_hash as hash")
+	} else {
+		query = query.Select(t.key + " as entity_key, firefly_id")
+	}
+
+	var mappings []FireflyMapping
+	if err := query.
+		Where("firefly_id is not null").
+		Find(&mappings).
+		Error; err != nil {
+		return errors.Wrap(err, "select firefly ids")
+	}
+
+	for i := range mappings {
+		mappings[i].Instance = firefly.DefaultInstance
+		mappings[i].Entity = t.model.TableName()
+	}
+
+	if err := saveLegacyMappings(db, mappings); err != nil {
+		return err
+	}
+
+	columns := []string{"firefly_id"}
+	if t.hash {
+		columns = append(columns, "firefly_hash")
+	}
+
+	for _, column := range columns {
+		if index := fmt.Sprintf("idx_%s_%s", t.model.TableName(), column); migrator.HasIndex(t.model, index) {
+			if err := migrator.DropIndex(t.model, index); err != nil {
+				return errors.Wrapf(err, "drop index %s", index)
+			}
+		}
+
+		if migrator.HasColumn(t.model, column) {
+			if err := migrator.DropColumn(t.model, column); err != nil {
+				return errors.Wrapf(err, "drop %s", column)
+			}
+		}
+	}
+
+	return nil
+}
+
+func saveLegacyMappings(db database.DB, mappings []FireflyMapping) error {
+	if len(mappings) == 0 {
+		return nil
+	}
+
+	if err := db.
+		Clauses(clause.OnConflict{DoNothing: true}).
+		CreateInBatches(&mappings, 1000).
+		Error; err != nil {
+		return errors.Wrap(err, "save firefly mappings")
+	}
+
+	return nil
+}
@@ -14,7 +14,8 @@ import (
 )
 
 type storage struct {
-	db database.DB
+	db     database.DB
+	cipher *database.Cipher
 }
 
 func (s *storage) LoadSession(ctx context.Context, phone string) (*tbank.Session, error) {
@@ -27,6 +28,12 @@ func (s *storage) LoadSession(ctx context.Context, phone string) (*tbank.Session
 		return nil, errors.Wrap(err, "get session from db")
 	}
 
+	var err error
+	entity.ID, err = s.cipher.Decrypt(entity.ID, sessionAssociatedData(phone))
+	if err != nil {
+		return nil, errors.Wrap(err, "decrypt session")
+	}
+
 	return database.ToViaJSON[*tbank.Session](entity)
 }
 
@@ -41,6 +48,10 @@ func (s *storage) UpdateSession(ctx context.Context, phone string, session *tban
 	}
 
 	entity.UserPhone = phone
+	entity.ID, err = s.cipher.Encrypt(entity.ID, sessionAssociatedData(phone))
+	if err != nil {
+		return errors.Wrap(err, "encrypt session")
+	}
 
 	if err := s.db.Upsert(entity).Error; err != nil {
 		return errors.Wrap(err, "save session in db")
@@ -48,3 +59,37 @@ func (s *storage) UpdateSession(ctx context.Context, phone string, session *tban
 
 	return nil
 }
+
+// encryptSecrets encrypts stored sessions with the current key.
+// Sessions stored in plain text or encrypted with other keys are re-encrypted.
+func (s *storage) encryptSecrets() error {
+	var sessions []Session
+	if err := s.db.Find(&sessions).Error; err != nil {
+		return errors.Wrap(err, "select sessions")
+	}
+
+	for _, session := range sessions {
+		id, changed, err := s.cipher.Reencrypt(session.ID, sessionAssociatedData(session.UserPhone))
+		if err != nil {
+			return errors.Wrapf(err, "encrypt session for %s", session.UserPhone)
+		}
+
+		if !changed {
+			continue
+		}
+
+		if err := s.db.
+			Model(new(Session)).
+			Where("user_phone = ?", session.UserPhone).
+			Update("id", id).
+			Error; err != nil {
+			return errors.Wrapf(err, "update session for %s", session.UserPhone)
+		}
+	}
+
+	return nil
+}
+
+func sessionAssociatedData(phone string) string {
+	return "sessions:" + phone
+}
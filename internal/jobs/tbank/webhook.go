@@ -0,0 +1,88 @@
+package tbank
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm/clause"
+
+	"github.com/jfk9w/hoarder/internal/firefly/webhook"
+	. "github.com/jfk9w/hoarder/internal/jobs/tbank/internal/entities"
+)
+
+// ReceiveFireflyEvent stores changes made by the user to synced transactions in Firefly III as overrides.
+// Events for transactions which were not synced from T-Bank are ignored.
+func (j *Job) ReceiveFireflyEvent(ctx context.Context, event webhook.Event) error {
+	fireflyId := string(event.Transaction.ID)
+	if fireflyId == "" {
+		return nil
+	}
+
+	var count int64
+	if err := j.db.WithContext(ctx).
+		Model(new(FireflyMapping)).
+		Where("instance = ? and entity = ? and firefly_id = ?", event.Instance, new(Operation).TableName(), fireflyId).
+		Count(&count).
+		Error; err != nil {
+		return errors.Wrap(err, "select firefly mappings")
+	}
+
+	if count == 0 {
+		return nil
+	}
+
+	switch event.Trigger {
+	case webhook.TriggerDestroyTransaction:
+		if err := j.db.WithContext(ctx).
+			Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "instance"}, {Name: "firefly_id"}},
+				DoUpdates: clause.AssignmentColumns([]string{"deleted"}),
+			}).
+			Create(&FireflyOverride{Instance: event.Instance, FireflyId: fireflyId, Deleted: true}).
+			Error; err != nil {
+			return errors.Wrap(err, "save deleted override")
+		}
+
+	case webhook.TriggerUpdateTransaction:
+		if len(event.Transaction.Splits) == 0 {
+			return nil
+		}
+
+		override := FireflyOverride{Instance: event.Instance, FireflyId: fireflyId}
+		if err := j.db.WithContext(ctx).
+			Where("instance = ? and firefly_id = ?", event.Instance, fireflyId).
+			Limit(1).
+			Find(&override).
+			Error; err != nil {
+			return errors.Wrap(err, "select override")
+		}
+
+		split := event.Transaction.Splits[0]
+		if split.Description != override.SentDescription {
+			override.Description = &split.Description
+		}
+
+		if categoryId := string(split.CategoryID); categoryId != override.SentCategoryId {
+			override.CategoryId = &categoryId
+		}
+
+		var counterpartyId string
+		switch split.Type {
+		case "withdrawal":
+			counterpartyId = string(split.DestinationID)
+		case "deposit":
+			counterpartyId = string(split.SourceID)
+		}
+
+		if counterpartyId != "" && counterpartyId != override.SentCounterpartyId {
+			override.CounterpartyId = &counterpartyId
+		}
+
+		override.Deleted = false
+		if err := j.db.WithContext(ctx).Upsert(&override).Error; err != nil {
+			return errors.Wrap(err, "save override")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,110 @@
+package retention
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/jfk9w-go/based"
+	"github.com/pkg/errors"
+
+	"github.com/jfk9w/hoarder/internal/database"
+	"github.com/jfk9w/hoarder/internal/jobs"
+)
+
+const JobID = "retention"
+
+type Rule struct {
+	MaxAge     time.Duration `yaml:"maxAge,omitempty" doc:"Максимальный возраст записей."`
+	KeepLatest int           `yaml:"keepLatest,omitempty" doc:"Количество последних записей, которые хранятся независимо от возраста.\n\nЕсли maxAge не задан, удаляются все более старые записи."`
+}
+
+type Config struct {
+	BatchSize int                        `yaml:"batchSize,omitempty" default:"1000" doc:"Количество записей, удаляемых в одной транзакции."`
+	Rules     map[string]map[string]Rule `yaml:"rules" doc:"Правила хранения записей по джобам и таблицам.\n\nЗапись удаляется, если она старше maxAge и не входит в keepLatest последних записей таблицы. Поддерживаются таблицы locations, loyalty_payments, operations (только несписанные операции), raw_responses и outbox_events джобы tinkoff и outbox_events джобы lkdr."`
+}
+
+type JobParams struct {
+	Config    Config            `validate:"required"`
+	Databases []database.Params `validate:"required"`
+}
+
+// Job prunes records of other jobs according to retention rules.
+// Like backups, pruning does not depend on the user, so concurrent runs for different users are skipped.
+type Job struct {
+	batchSize int
+	rules     map[string]map[string]database.RetentionRule
+	databases []database.Params
+	mu        sync.Mutex
+}
+
+func NewJob(params JobParams) (*Job, error) {
+	if err := based.Validate(params); err != nil {
+		return nil, err
+	}
+
+	rules := make(map[string]map[string]database.RetentionRule, len(params.Config.Rules))
+	for jobID, tables := range params.Config.Rules {
+		i := slices.IndexFunc(params.Databases, func(params database.Params) bool { return params.Name == jobID })
+		if i < 0 {
+			return nil, errors.Errorf("%s job is not enabled", jobID)
+		}
+
+		rules[jobID] = make(map[string]database.RetentionRule, len(tables))
+		for table, rule := range tables {
+			if _, ok := params.Databases[i].RetentionTarget(table); !ok {
+				return nil, errors.Errorf("retention is not supported for %s table of %s job", table, jobID)
+			}
+
+			if rule.MaxAge <= 0 && rule.KeepLatest <= 0 {
+				return nil, errors.Errorf("retention rule for %s table of %s job has no limits", table, jobID)
+			}
+
+			rules[jobID][table] = database.RetentionRule{
+				MaxAge:     rule.MaxAge,
+				KeepLatest: rule.KeepLatest,
+			}
+		}
+	}
+
+	return &Job{
+		batchSize: params.Config.BatchSize,
+		rules:     rules,
+		databases: params.Databases,
+	}, nil
+}
+
+func (j *Job) Info() jobs.Info {
+	return jobs.Info{
+		ID:          JobID,
+		Description: "Удаление устаревших записей из БД",
+		Manual:      true,
+	}
+}
+
+func (j *Job) Run(ctx jobs.Context, _ time.Time, _ string) (errs error) {
+	if !j.mu.TryLock() {
+		ctx.Info("retention is already running")
+		return nil
+	}
+
+	defer j.mu.Unlock()
+
+	for _, params := range j.databases {
+		rules, ok := j.rules[params.Name]
+		if !ok {
+			continue
+		}
+
+		ctx := ctx.With("database", params.Name)
+		pruned, err := database.Prune(ctx, params, rules, j.batchSize)
+		for _, pruned := range pruned {
+			ctx.Info("pruned records", "table", pruned.Table, "count", pruned.Rows)
+			ctx.Report("pruned %d records from %s", pruned.Rows, pruned.Table)
+		}
+
+		_ = ctx.Error(&errs, err, "failed to prune database")
+	}
+
+	return
+}
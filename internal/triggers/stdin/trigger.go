@@ -85,6 +85,12 @@ func (t *Trigger) Run(ctx triggers.Context, job triggers.Jobs) {
 					reply.WriteRune('\n')
 				}
 			}
+
+			for _, line := range result.Report {
+				reply.WriteString("   ")
+				reply.WriteString(line)
+				reply.WriteRune('\n')
+			}
 		}
 
 		if _, err := fmt.Fprintln(t.out, reply.String()); err != nil {
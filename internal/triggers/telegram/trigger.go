@@ -140,6 +140,10 @@ func (t *Trigger) execute(ctx context.Context, msg *tgb.MessageUpdate, client *t
 		} else {
 			report = append(report, fmt.Sprintf("✔ %s", result.JobID))
 		}
+
+		for _, line := range result.Report {
+			report = append(report, "  "+line)
+		}
 	}
 
 	return msg.Answer(tg.HTML.Text(report...)).DoVoid(ctx)
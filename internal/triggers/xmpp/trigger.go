@@ -180,6 +180,12 @@ func (t *Trigger) handleMessage(ctx triggers.Context, sender xmpp.Sender, messag
 					reply.WriteRune('\n')
 				}
 			}
+
+			for _, line := range result.Report {
+				reply.WriteString("  ")
+				reply.WriteString(line)
+				reply.WriteRune('\n')
+			}
 		}
 
 		typing.Cancel()